@@ -0,0 +1,399 @@
+// Package auth authenticates requests with API keys or with JWTs issued by a
+// login route and carries the caller's identity to the resolvers in the request
+// context.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	jwt "github.com/golang-jwt/jwt/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Roles a user may have.  Each role may do everything the roles before it may.
+const (
+	Reader = "reader" // may run queries
+	Editor = "editor" // may also change user defined foods
+	Admin  = "admin"  // may also manage users
+)
+
+// Anonymous is the name of the identity given to requests when authentication
+// is disabled
+const Anonymous = "anonymous"
+
+// anonymous is the identity of every request when authentication is disabled.
+// It may only read so a server left without authentication cannot be changed.
+var anonymous = Identity{Name: Anonymous, Role: Reader}
+
+// KeyPrefix starts every API key so keys can be told apart from JWTs
+const KeyPrefix = "fdc_"
+
+var ranks = map[string]int{Reader: 1, Editor: 2, Admin: 3}
+
+// ErrExists is returned when bootstrapping a store which already has its admin user
+var ErrExists = errors.New("user already exists")
+
+// Config are the authentication settings in the auth section of the config file
+type Config struct {
+	Enabled bool          `yaml:"enabled"`
+	Secret  string        `yaml:"secret"`  // key signing JWTs
+	Timeout time.Duration `yaml:"timeout"` // lifetime of a JWT
+	Users   string        `yaml:"users"`   // JSON file holding the users
+	Admin   string        `yaml:"admin"`   // name of the user created by -i
+}
+
+// Identity is the authenticated caller of a request
+type Identity struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+	Key  string `json:"key,omitempty"` // id of the API key used, empty for a JWT
+}
+
+// Can reports whether the identity has a role or one above it
+func (id Identity) Can(role string) bool {
+	return ranks[id.Role] >= ranks[role]
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying an identity
+func NewContext(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the identity carried by a context
+func FromContext(ctx context.Context) (Identity, bool) {
+	if ctx == nil {
+		return Identity{}, false
+	}
+	id, ok := ctx.Value(contextKey{}).(Identity)
+	return id, ok
+}
+
+// Require returns an error unless the context carries an identity with a role
+func Require(ctx context.Context, role string) error {
+	id, ok := FromContext(ctx)
+	switch {
+	case !ok:
+		return errors.New("authentication required")
+	case !id.Can(role):
+		return fmt.Errorf("user %s is not permitted to do this.  The %s role is required", id.Name, role)
+	}
+	return nil
+}
+
+// Authenticator checks credentials against a user store
+type Authenticator struct {
+	cfg    Config
+	store  Store
+	secret []byte
+}
+
+// New returns an Authenticator for a store.  A secret is required when
+// authentication is enabled.
+func New(cfg Config, store Store) (*Authenticator, error) {
+	if cfg.Enabled && len(cfg.Secret) < 16 {
+		return nil, errors.New("auth secret must be at least 16 characters")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = time.Hour
+	}
+	return &Authenticator{cfg: cfg, store: store, secret: []byte(cfg.Secret)}, nil
+}
+
+// Bootstrap creates the admin user named in the config with a random password
+// and an API key which are returned.  It returns ErrExists if the user exists.
+func (a *Authenticator) Bootstrap() (name, password, key string, err error) {
+	name = a.cfg.Admin
+	if name == "" {
+		name = Admin
+	}
+	if u, err := a.store.User(name); err != nil || u != nil {
+		if err == nil {
+			err = fmt.Errorf("%s: %w", name, ErrExists)
+		}
+		return name, "", "", err
+	}
+	if password, err = random(""); err != nil {
+		return name, "", "", err
+	}
+	u := User{Name: name, Role: Admin}
+	if u.Password, err = hashPassword(password); err != nil {
+		return name, "", "", err
+	}
+	k, key, err := newKey()
+	if err != nil {
+		return name, "", "", err
+	}
+	u.Keys = append(u.Keys, k)
+	return name, password, key, a.store.PutUser(u)
+}
+
+// Middleware rejects requests without valid credentials and adds the caller's
+// identity to the request context.  Credentials are an API key in the X-API-Key
+// header or a JWT or API key in an Authorization: Bearer header.
+func (a *Authenticator) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := a.Authenticate(c.Request.Header)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer realm="fdcgql"`)
+			fail(c, http.StatusUnauthorized, err.Error())
+			return
+		}
+		c.Request = c.Request.WithContext(NewContext(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// Disabled treats every request as an anonymous reader.  It is used when
+// authentication is not enabled so mutations and the editor and admin routes
+// are refused.
+func Disabled() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(NewContext(c.Request.Context(), anonymous))
+		c.Next()
+	}
+}
+
+// Unauthenticated returns the anonymous reader identity whatever the headers.
+// It stands in for Authenticate when authentication is not enabled.
+func Unauthenticated(header http.Header) (Identity, error) {
+	return anonymous, nil
+}
+
+// Authenticate returns the identity for the credentials in a request's headers
+func (a *Authenticator) Authenticate(header http.Header) (Identity, error) {
+	cred := header.Get("X-API-Key")
+	if cred == "" {
+		h := header.Get("Authorization")
+		if !strings.HasPrefix(h, "Bearer ") {
+			return Identity{}, errors.New("an API key or bearer token is required")
+		}
+		cred = strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+	}
+	if strings.HasPrefix(cred, KeyPrefix) {
+		hash := hashKey(cred)
+		u, err := a.store.UserByKey(hash)
+		if err != nil {
+			return Identity{}, err
+		}
+		if u == nil {
+			return Identity{}, errors.New("invalid API key")
+		}
+		return Identity{Name: u.Name, Role: u.Role, Key: hash[:keyIDLen]}, nil
+	}
+	var claims jwt.RegisteredClaims
+	_, err := jwt.ParseWithClaims(cred, &claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return a.secret, nil
+	})
+	if err != nil {
+		return Identity{}, fmt.Errorf("invalid token: %v", err)
+	}
+	// the role is read from the store so changes take effect before tokens expire
+	u, err := a.store.User(claims.Subject)
+	if err != nil {
+		return Identity{}, err
+	}
+	if u == nil {
+		return Identity{}, errors.New("invalid token: unknown user")
+	}
+	return Identity{Name: u.Name, Role: u.Role}, nil
+}
+
+// LoginHandler exchanges a username and password for a JWT
+func (a *Authenticator) LoginHandler(c *gin.Context) {
+	var login struct {
+		Username string `json:"username" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&login); err != nil {
+		fail(c, http.StatusBadRequest, "username and password are required")
+		return
+	}
+	u, err := a.store.User(login.Username)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if u == nil || bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(login.Password)) != nil {
+		fail(c, http.StatusUnauthorized, "incorrect username or password")
+		return
+	}
+	now := time.Now()
+	expire := now.Add(a.cfg.Timeout)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Subject:   u.Name,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(expire),
+	}).SignedString(a.secret)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token": token, "expire": expire.UTC().Format(time.RFC3339)})
+}
+
+// CreateKeyHandler issues a new API key to the caller.  The key is only shown once.
+func (a *Authenticator) CreateKeyHandler(c *gin.Context) {
+	u, ok := a.caller(c)
+	if !ok {
+		return
+	}
+	k, key, err := newKey()
+	if err != nil {
+		fail(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	u.Keys = append(u.Keys, k)
+	if err = a.store.PutUser(*u); err != nil {
+		fail(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"id": k.ID, "key": key, "created": k.Created})
+}
+
+// DeleteKeyHandler revokes one of the caller's API keys given by its id
+func (a *Authenticator) DeleteKeyHandler(c *gin.Context) {
+	u, ok := a.caller(c)
+	if !ok {
+		return
+	}
+	var keys []Key
+	for _, k := range u.Keys {
+		if k.ID != c.Param("id") {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == len(u.Keys) {
+		fail(c, http.StatusNotFound, fmt.Sprintf("key %s not found", c.Param("id")))
+		return
+	}
+	u.Keys = keys
+	if err := a.store.PutUser(*u); err != nil {
+		fail(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// UserHandler creates a user or changes the password and role of one.  Only
+// admins may use it.
+func (a *Authenticator) UserHandler(c *gin.Context) {
+	var in struct {
+		Username string `json:"username" binding:"required"`
+		Password string `json:"password"`
+		Role     string `json:"role"`
+	}
+	if err := Require(c.Request.Context(), Admin); err != nil {
+		fail(c, http.StatusForbidden, err.Error())
+		return
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		fail(c, http.StatusBadRequest, "username is required")
+		return
+	}
+	u, err := a.store.User(in.Username)
+	if err != nil {
+		fail(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	status := http.StatusOK
+	if u == nil {
+		if in.Password == "" {
+			fail(c, http.StatusBadRequest, "password is required for a new user")
+			return
+		}
+		u, status = &User{Name: in.Username, Role: Reader}, http.StatusCreated
+	}
+	if in.Role != "" {
+		if _, ok := ranks[in.Role]; !ok {
+			fail(c, http.StatusBadRequest, fmt.Sprintf("role must be %s, %s or %s", Reader, Editor, Admin))
+			return
+		}
+		u.Role = in.Role
+	}
+	if in.Password != "" {
+		if len(in.Password) < 8 {
+			fail(c, http.StatusBadRequest, "password must be at least 8 characters")
+			return
+		}
+		if u.Password, err = hashPassword(in.Password); err != nil {
+			fail(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	if err = a.store.PutUser(*u); err != nil {
+		fail(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(status, gin.H{"username": u.Name, "role": u.Role})
+}
+
+// caller reads the user making a request writing an error response if it cannot
+func (a *Authenticator) caller(c *gin.Context) (*User, bool) {
+	id, _ := FromContext(c.Request.Context())
+	u, err := a.store.User(id.Name)
+	switch {
+	case err != nil:
+		fail(c, http.StatusInternalServerError, err.Error())
+		return nil, false
+	case u == nil:
+		fail(c, http.StatusForbidden, "API keys can only be managed by stored users")
+		return nil, false
+	}
+	return u, true
+}
+
+func fail(c *gin.Context, status int, msg string) {
+	c.AbortWithStatusJSON(status, gin.H{
+		"error":  msg,
+		"status": status,
+	})
+}
+
+// keyIDLen is the number of characters of a key's hash used as its id
+const keyIDLen = 12
+
+// newKey returns a new API key and its stored form
+func newKey() (Key, string, error) {
+	key, err := random(KeyPrefix)
+	if err != nil {
+		return Key{}, "", err
+	}
+	hash := hashKey(key)
+	return Key{ID: hash[:keyIDLen], Hash: hash, Created: time.Now().UTC()}, key, nil
+}
+
+// random returns a prefix followed by 32 random bytes encoded as base64
+func random(prefix string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashKey returns the SHA-256 of an API key which is how keys are stored.  Keys
+// are long and random so a salted hash is not needed.
+func hashKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+func hashPassword(password string) (string, error) {
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(h), err
+}
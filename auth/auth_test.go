@@ -0,0 +1,316 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+const secret = "0123456789abcdef0123"
+
+// server is an Authenticator on a FileStore behind the routes main sets up
+type server struct {
+	a      *Authenticator
+	store  *FileStore
+	router *gin.Engine
+	// password and key of the bootstrapped admin
+	password, key string
+}
+
+func newServer(t *testing.T) *server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	store, err := OpenFile(filepath.Join(t.TempDir(), "users.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := New(Config{Enabled: true, Secret: secret}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{a: a, store: store, router: gin.New()}
+	if _, s.password, s.key, err = a.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	s.router.POST("/login", a.LoginHandler)
+	api := s.router.Group("", a.Middleware())
+	api.POST("/keys", a.CreateKeyHandler)
+	api.DELETE("/keys/:id", a.DeleteKeyHandler)
+	api.POST("/users", a.UserHandler)
+	api.GET("/whoami", whoami)
+	return s
+}
+
+// whoami responds with the identity of the caller
+func whoami(c *gin.Context) {
+	id, _ := FromContext(c.Request.Context())
+	c.JSON(http.StatusOK, id)
+}
+
+// do sends a request with a credential returning the status and decoded body
+func (s *server) do(t *testing.T, method, path, cred string, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	var b []byte
+	if body != nil {
+		b, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	if cred != "" {
+		req.Header.Set("Authorization", "Bearer "+cred)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	var rs map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &rs)
+	return w.Code, rs
+}
+
+// user creates a user with a role returning an API key for it
+func (s *server) user(t *testing.T, name, role string) string {
+	t.Helper()
+	if code, rs := s.do(t, http.MethodPost, "/users", s.key, map[string]string{"username": name, "password": "password-" + name, "role": role}); code != http.StatusCreated {
+		t.Fatalf("creating %s: %d %v", name, code, rs)
+	}
+	_, rs := s.do(t, http.MethodPost, "/login", "", map[string]string{"username": name, "password": "password-" + name})
+	code, rs := s.do(t, http.MethodPost, "/keys", rs["token"].(string), nil)
+	if code != http.StatusCreated {
+		t.Fatalf("creating a key for %s: %d %v", name, code, rs)
+	}
+	return rs["key"].(string)
+}
+
+func token(t *testing.T, method jwt.SigningMethod, key interface{}, subject string, expires time.Time) string {
+	t.Helper()
+	s, err := jwt.NewWithClaims(method, jwt.RegisteredClaims{Subject: subject, ExpiresAt: jwt.NewNumericDate(expires)}).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := newServer(t)
+	_, rs := s.do(t, http.MethodPost, "/login", "", map[string]string{"username": Admin, "password": s.password})
+	login, _ := rs["token"].(string)
+	if login == "" {
+		t.Fatalf("login returned %v", rs)
+	}
+	hour := time.Now().Add(time.Hour)
+	valid := token(t, jwt.SigningMethodHS256, []byte(secret), Admin, hour)
+	parts := strings.Split(valid, ".")
+	// the claims of a valid token with a later expiry but the old signature
+	claims, _ := json.Marshal(jwt.RegisteredClaims{Subject: Admin, ExpiresAt: jwt.NewNumericDate(hour.Add(time.Hour))})
+	tampered := parts[0] + "." + jwt.EncodeSegment(claims) + "." + parts[2]
+	for _, tc := range []struct {
+		name string
+		cred string
+		want string
+	}{
+		{"login token", login, Admin},
+		{"token", valid, Admin},
+		{"api key", s.key, Admin},
+		{"expired", token(t, jwt.SigningMethodHS256, []byte(secret), Admin, time.Now().Add(-time.Minute)), ""},
+		{"tampered", tampered, ""},
+		{"other secret", token(t, jwt.SigningMethodHS256, []byte("another secret of 20"), Admin, hour), ""},
+		{"none algorithm", token(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, Admin, hour), ""},
+		{"HS512", token(t, jwt.SigningMethodHS512, []byte(secret), Admin, hour), Admin},
+		{"unknown user", token(t, jwt.SigningMethodHS256, []byte(secret), "nobody", hour), ""},
+		{"unknown key", KeyPrefix + "not-a-key", ""},
+		{"no credentials", "", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			code, rs := s.do(t, http.MethodGet, "/whoami", tc.cred, nil)
+			if tc.want == "" {
+				if code != http.StatusUnauthorized {
+					t.Errorf("status %d %v, want 401", code, rs)
+				}
+				return
+			}
+			if code != http.StatusOK || rs["name"] != tc.want {
+				t.Errorf("status %d identity %v, want %s", code, rs, tc.want)
+			}
+		})
+	}
+}
+
+func TestWrongAlgorithm(t *testing.T) {
+	s := newServer(t)
+	// a token signed with RS256 must not be accepted even if its key were the secret
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.RegisteredClaims{Subject: Admin})
+	signing, _ := tok.SigningString()
+	if _, err := s.a.Authenticate(http.Header{"Authorization": {"Bearer " + signing + ".c2ln"}}); err == nil || !strings.Contains(err.Error(), "signing method") {
+		t.Errorf("RS256 token error %v", err)
+	}
+}
+
+func TestDeletedKey(t *testing.T) {
+	s := newServer(t)
+	key := s.user(t, "reader", Reader)
+	id, err := s.a.Authenticate(http.Header{"X-Api-Key": {key}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := s.do(t, http.MethodDelete, "/keys/"+id.Key, key, nil); code != http.StatusNoContent {
+		t.Fatalf("delete returned %d", code)
+	}
+	if code, _ := s.do(t, http.MethodGet, "/whoami", key, nil); code != http.StatusUnauthorized {
+		t.Errorf("deleted key returned %d", code)
+	}
+	if code, _ := s.do(t, http.MethodDelete, "/keys/"+id.Key, s.key, nil); code != http.StatusNotFound {
+		t.Errorf("deleting a deleted key returned %d", code)
+	}
+}
+
+func TestRoleEscalation(t *testing.T) {
+	s := newServer(t)
+	reader := s.user(t, "reader", Reader)
+	editor := s.user(t, "editor", Editor)
+	admin, err := s.a.Authenticate(http.Header{"X-Api-Key": {s.key}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name   string
+		cred   string
+		method string
+		path   string
+		body   interface{}
+		status int
+	}{
+		{"reader makes itself admin", reader, http.MethodPost, "/users", map[string]string{"username": "reader", "role": Admin}, http.StatusForbidden},
+		{"editor makes itself admin", editor, http.MethodPost, "/users", map[string]string{"username": "editor", "role": Admin}, http.StatusForbidden},
+		{"editor creates an admin", editor, http.MethodPost, "/users", map[string]string{"username": "mallory", "password": "password1", "role": Admin}, http.StatusForbidden},
+		{"reader resets the admin password", reader, http.MethodPost, "/users", map[string]string{"username": Admin, "password": "password1"}, http.StatusForbidden},
+		{"reader deletes the admin key", reader, http.MethodDelete, "/keys/" + admin.Key, nil, http.StatusNotFound},
+		{"admin sets an unknown role", s.key, http.MethodPost, "/users", map[string]string{"username": "reader", "role": "root"}, http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if code, rs := s.do(t, tc.method, tc.path, tc.cred, tc.body); code != tc.status {
+				t.Errorf("status %d %v, want %d", code, rs, tc.status)
+			}
+		})
+	}
+	for name, role := range map[string]string{"reader": Reader, "editor": Editor, Admin: Admin} {
+		if u, _ := s.store.User(name); u == nil || u.Role != role {
+			t.Errorf("%s is %v, want %s", name, u, role)
+		}
+	}
+	if _, err := s.a.Authenticate(http.Header{"X-Api-Key": {s.key}}); err != nil {
+		t.Errorf("admin key: %v", err)
+	}
+	// a key a reader creates has the reader's role
+	code, rs := s.do(t, http.MethodPost, "/keys", reader, nil)
+	if code != http.StatusCreated {
+		t.Fatalf("creating a key returned %d", code)
+	}
+	if id, err := s.a.Authenticate(http.Header{"X-Api-Key": {rs["key"].(string)}}); err != nil || id.Role != Reader {
+		t.Errorf("new key is %v %v", id, err)
+	}
+	// roles changed by an admin apply to tokens already issued
+	_, rs = s.do(t, http.MethodPost, "/login", "", map[string]string{"username": "editor", "password": "password-editor"})
+	tok := rs["token"].(string)
+	if code, _ := s.do(t, http.MethodPost, "/users", s.key, map[string]string{"username": "editor", "role": Reader}); code != http.StatusOK {
+		t.Fatalf("changing the role returned %d", code)
+	}
+	if _, rs = s.do(t, http.MethodGet, "/whoami", tok, nil); rs["role"] != Reader {
+		t.Errorf("token identity %v, want the reader role", rs)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Disabled())
+	r.GET("/whoami", whoami)
+	r.POST("/edit", func(c *gin.Context) {
+		if err := Require(c.Request.Context(), Editor); err != nil {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/whoami", nil)
+	req.Header.Set("X-API-Key", KeyPrefix+"anything")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var id Identity
+	if err := json.Unmarshal(w.Body.Bytes(), &id); err != nil || id.Name != Anonymous || id.Role != Reader {
+		t.Errorf("identity %+v %v, want the anonymous reader", id, err)
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/edit", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("anonymous edit returned %d", w.Code)
+	}
+	if id, err := Unauthenticated(http.Header{"Authorization": {"Bearer x"}}); err != nil || id != anonymous {
+		t.Errorf("Unauthenticated returned %+v %v", id, err)
+	}
+	if err := Require(context.Background(), Reader); err == nil {
+		t.Error("a context without an identity may read")
+	}
+	if _, err := New(Config{Enabled: true, Secret: "short"}, nil); err == nil {
+		t.Error("a short secret is accepted")
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	s, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, err := s.User("ann"); u != nil || err != nil {
+		t.Errorf("empty store has %v %v", u, err)
+	}
+	k := Key{ID: "abc", Hash: hashKey("fdc_key"), Created: time.Now().UTC()}
+	for _, u := range []User{{Name: "ann", Role: Reader}, {Name: "bob", Role: Editor}, {Name: "ann", Role: Admin, Keys: []Key{k}}} {
+		if err := s.PutUser(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("users file mode %v", fi.Mode())
+	}
+	s, err = OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, _ := s.User("ann"); u == nil || u.Role != Admin {
+		t.Errorf("ann is %v after reopening", u)
+	}
+	if u, _ := s.UserByKey(k.Hash); u == nil || u.Name != "ann" {
+		t.Errorf("key belongs to %v after reopening", u)
+	}
+	if u, _ := s.UserByKey(hashKey("fdc_other")); u != nil {
+		t.Errorf("unknown key belongs to %v", u)
+	}
+	// a failed save leaves the store as it was
+	s.path = filepath.Join(t.TempDir(), "missing", "users.json")
+	if err := s.PutUser(User{Name: "carol"}); err == nil {
+		t.Error("saving to a missing directory succeeded")
+	}
+	if u, _ := s.User("carol"); u != nil {
+		t.Error("carol was kept after the save failed")
+	}
+	if err := os.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFile(path); err == nil {
+		t.Error("a corrupt users file opened")
+	}
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// User is an account which may log in or use its API keys
+type User struct {
+	Name     string `json:"name"`
+	Password string `json:"password"` // bcrypt hash
+	Role     string `json:"role"`
+	Keys     []Key  `json:"keys"`
+}
+
+// Key is a stored API key
+type Key struct {
+	ID      string    `json:"id"`
+	Hash    string    `json:"hash"` // SHA-256 of the key
+	Created time.Time `json:"created"`
+}
+
+// Store reads and writes users.  Lookups return a nil user without an error
+// when there is no match.
+type Store interface {
+	User(name string) (*User, error)
+	UserByKey(hash string) (*User, error)
+	PutUser(u User) error
+}
+
+// FileStore keeps users in a JSON file which is rewritten on every change
+type FileStore struct {
+	mu    sync.RWMutex
+	path  string
+	users map[string]User
+}
+
+// OpenFile returns a FileStore for a path.  The file is created by the first
+// change if it does not exist.
+func OpenFile(path string) (*FileStore, error) {
+	s := &FileStore{path: path, users: make(map[string]User)}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var users []User
+	if err = json.NewDecoder(f).Decode(&users); err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		s.users[u.Name] = u
+	}
+	return s, nil
+}
+
+// User returns a user by name
+func (s *FileStore) User(name string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.users[name]
+	if !ok {
+		return nil, nil
+	}
+	return &u, nil
+}
+
+// UserByKey returns the user owning an API key given by its hash
+func (s *FileStore) UserByKey(hash string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, u := range s.users {
+		for _, k := range u.Keys {
+			if k.Hash == hash {
+				return &u, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// PutUser adds or replaces a user and saves the file
+func (s *FileStore) PutUser(u User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, had := s.users[u.Name]
+	s.users[u.Name] = u
+	if err := s.save(); err != nil {
+		if had {
+			s.users[u.Name] = prev
+		} else {
+			delete(s.users, u.Name)
+		}
+		return err
+	}
+	return nil
+}
+
+// save writes the users to a temporary file renamed over the store's file so
+// the file is never left half written.  Callers must hold the lock.
+func (s *FileStore) save() error {
+	var users []User
+	for _, u := range s.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(users); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,228 @@
+// Package cache keeps the results of read queries so repeated requests are
+// answered without going to the datastore.  Results are keyed on the
+// canonical form of the query document and its variables, kept in memory in an
+// LRU list and optionally in a backend shared by several servers.
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/printer"
+	"github.com/littlebunch/fdc-graphql/auth"
+	"github.com/littlebunch/fdc-graphql/events"
+)
+
+// Config are the settings in the cache section of the config file.  Results
+// are kept for TTL and clients and CDNs are told they may keep them for MaxAge.
+// Dir, if set, is a directory shared with other servers for results.
+type Config struct {
+	Enabled bool          `yaml:"enabled"`
+	Size    int           `yaml:"size"`
+	TTL     time.Duration `yaml:"ttl"`
+	MaxAge  time.Duration `yaml:"maxAge"`
+	Dir     string        `yaml:"dir"`
+}
+
+// Backend stores encoded results shared between servers.  Get returns a nil
+// value without an error when a key is missing or has expired.
+type Backend interface {
+	Get(key string) ([]byte, time.Time, error)
+	Set(key string, value []byte, expires time.Time) error
+	Purge() error
+}
+
+// entry is a result in the LRU list
+type entry struct {
+	key     string
+	result  *graphql.Result
+	expires time.Time
+}
+
+// Cache keeps results in memory in front of an optional Backend.  The memory
+// is each server's own so a purge empties the backend but not the memory of
+// other servers sharing it.  gen counts purges so a result computed before one
+// is not set after it.
+type Cache struct {
+	Backend Backend
+	ttl     time.Duration
+	maxAge  time.Duration
+	size    int
+	mu      sync.Mutex
+	order   *list.List
+	items   map[string]*list.Element
+	now     func() time.Time
+	purging sync.RWMutex
+	gen     uint64
+}
+
+// New returns a Cache for a config opening its directory backend, if any
+func New(cfg Config) (*Cache, error) {
+	c := &Cache{ttl: cfg.TTL, maxAge: cfg.MaxAge, size: cfg.Size, order: list.New(), items: make(map[string]*list.Element), now: time.Now}
+	if c.size <= 0 {
+		c.size = 10000
+	}
+	if c.ttl <= 0 {
+		c.ttl = time.Hour
+	}
+	if cfg.Dir != "" {
+		d, err := OpenDir(cfg.Dir)
+		if err != nil {
+			return nil, err
+		}
+		c.Backend = d
+	}
+	return c, nil
+}
+
+// Key returns the cache key of an operation.  The document is printed in its
+// canonical form so formatting and comments do not matter.
+func Key(doc *ast.Document, operationName string, variables map[string]interface{}) string {
+	vars, _ := json.Marshal(variables)
+	h := sha256.New()
+	fmt.Fprintf(h, "%v\x00%s\x00%s", printer.Print(doc), operationName, vars)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// MaxAge is how long clients may keep a result
+func (c *Cache) MaxAge() time.Duration {
+	return c.maxAge
+}
+
+// Get returns a result from memory or the backend if it has not expired
+func (c *Cache) Get(key string) (*graphql.Result, bool) {
+	now := c.now()
+	c.mu.Lock()
+	if e, ok := c.items[key]; ok {
+		en := e.Value.(*entry)
+		if now.Before(en.expires) {
+			c.order.MoveToFront(e)
+			c.mu.Unlock()
+			return en.result, true
+		}
+		c.order.Remove(e)
+		delete(c.items, key)
+	}
+	c.mu.Unlock()
+	if c.Backend == nil {
+		return nil, false
+	}
+	b, expires, err := c.Backend.Get(key)
+	if err != nil {
+		log.Printf("Cannot read cached result %s: %v", key, err)
+		return nil, false
+	}
+	if b == nil || !now.Before(expires) {
+		return nil, false
+	}
+	var r graphql.Result
+	if err = json.Unmarshal(b, &r); err != nil {
+		return nil, false
+	}
+	c.add(key, &r, expires)
+	return &r, true
+}
+
+// Generation returns the number of purges.  Read it before running a query and
+// pass it to Set.
+func (c *Cache) Generation() uint64 {
+	c.purging.RLock()
+	defer c.purging.RUnlock()
+	return c.gen
+}
+
+// Set keeps a result for the TTL unless the cache has been purged since gen
+// was read, as the result may be from before the change purging it.  Results
+// are shared so must not be changed once set.
+func (c *Cache) Set(key string, gen uint64, result *graphql.Result) {
+	c.purging.RLock()
+	defer c.purging.RUnlock()
+	if gen != c.gen {
+		return
+	}
+	expires := c.now().Add(c.ttl)
+	c.add(key, result, expires)
+	if c.Backend == nil {
+		return
+	}
+	b, err := json.Marshal(result)
+	if err == nil {
+		err = c.Backend.Set(key, b, expires)
+	}
+	if err != nil {
+		log.Printf("Cannot store cached result %s: %v", key, err)
+	}
+}
+
+// add puts a result at the front of the LRU list evicting the least recently
+// used once the cache is full
+func (c *Cache) add(key string, result *graphql.Result, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		e.Value = &entry{key: key, result: result, expires: expires}
+		c.order.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.order.PushFront(&entry{key: key, result: result, expires: expires})
+	for c.order.Len() > c.size {
+		e := c.order.Back()
+		c.order.Remove(e)
+		delete(c.items, e.Value.(*entry).key)
+	}
+}
+
+// Purge removes every result returning the number removed from memory
+func (c *Cache) Purge() (int, error) {
+	c.purging.Lock()
+	defer c.purging.Unlock()
+	c.gen++
+	c.mu.Lock()
+	n := c.order.Len()
+	c.order.Init()
+	c.items = make(map[string]*list.Element)
+	c.mu.Unlock()
+	if c.Backend != nil {
+		return n, c.Backend.Purge()
+	}
+	return n, nil
+}
+
+// Watch purges the cache whenever foods change or a release is loaded
+func (c *Cache) Watch(bus *events.Bus) {
+	bus.Observe(func([]events.Event) {
+		if _, err := c.Purge(); err != nil {
+			log.Printf("Cannot purge the cache: %v", err)
+		}
+	})
+}
+
+// PurgeHandler empties the cache.  Only admins may use it.
+func (c *Cache) PurgeHandler(ctx *gin.Context) {
+	if err := auth.Require(ctx.Request.Context(), auth.Admin); err != nil {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error":  err.Error(),
+			"status": http.StatusForbidden,
+		})
+		return
+	}
+	n, err := c.Purge()
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error":  err.Error(),
+			"status": http.StatusInternalServerError,
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"purged": n})
+}
@@ -0,0 +1,35 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/graphql-go/graphql"
+)
+
+func TestSetAfterPurge(t *testing.T) {
+	c, err := New(Config{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := &graphql.Result{Data: map[string]interface{}{"fdcId": "171287"}}
+	gen := c.Generation()
+	c.Set("a", gen, result)
+	// a query running while foods change finishes after the purge
+	stale := c.Generation()
+	if _, err := c.Purge(); err != nil {
+		t.Fatal(err)
+	}
+	c.Set("b", stale, result)
+	for key, want := range map[string]bool{"a": false, "b": false} {
+		if _, ok := c.Get(key); ok != want {
+			t.Errorf("%s cached %v, want %v", key, ok, want)
+		}
+	}
+	if b, _, _ := c.Backend.Get("b"); b != nil {
+		t.Error("stale result stored in the backend")
+	}
+	c.Set("c", c.Generation(), result)
+	if _, ok := c.Get("c"); !ok {
+		t.Error("result after the purge not cached")
+	}
+}
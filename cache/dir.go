@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Dir is a Backend keeping each result in a file of a directory which may be
+// shared by several servers, e.g. a volume mounted by each container.  Files
+// start with their expiry time on a line of their own.
+type Dir struct {
+	path string
+}
+
+// OpenDir returns a Dir for a path creating the directory if needed
+func OpenDir(path string) (*Dir, error) {
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, err
+	}
+	return &Dir{path: path}, nil
+}
+
+// Get reads a result removing it if it has expired
+func (d *Dir) Get(key string) ([]byte, time.Time, error) {
+	name, ok := d.file(key)
+	if !ok {
+		return nil, time.Time{}, nil
+	}
+	b, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, time.Time{}, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	i := bytes.IndexByte(b, '\n')
+	if i < 0 {
+		return nil, time.Time{}, nil
+	}
+	expires, err := time.Parse(time.RFC3339Nano, string(b[:i]))
+	if err != nil || !time.Now().Before(expires) {
+		os.Remove(name)
+		return nil, time.Time{}, nil
+	}
+	return b[i+1:], expires, nil
+}
+
+// Set writes a result to a temporary file renamed into place so readers never
+// see part of it
+func (d *Dir) Set(key string, value []byte, expires time.Time) error {
+	name, ok := d.file(key)
+	if !ok {
+		return nil
+	}
+	f, err := os.CreateTemp(d.path, ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(expires.UTC().Format(time.RFC3339Nano) + "\n")
+	if err == nil {
+		_, err = f.Write(value)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// Purge removes every result leaving other files alone
+func (d *Dir) Purge() error {
+	entries, err := os.ReadDir(d.path)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if _, ok := d.file(e.Name()); ok && e.Type().IsRegular() {
+			if err = os.Remove(filepath.Join(d.path, e.Name())); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// file returns the path of a key's file.  Only keys made by Key are accepted
+// so a key can never name a file outside the directory.
+func (d *Dir) file(key string) (string, bool) {
+	if b, err := hex.DecodeString(key); err != nil || len(b) != 32 {
+		return "", false
+	}
+	return filepath.Join(d.path, key), true
+}
@@ -0,0 +1,53 @@
+// Package main creates or upgrades a SQL database schema for the fdc-graphql
+// server and loads USDA FoodData Central downloads into it.  A running server
+// can be told which foods were loaded so its subscribers hear of the release.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/littlebunch/fdc-graphql/datastore/sqldb"
+	"github.com/littlebunch/fdc-graphql/events"
+)
+
+var (
+	d = flag.String("d", "sqlite3", "SQL driver -- postgres or sqlite3")
+	n = flag.String("n", "fdc.db", "data source name, e.g. a SQLite file or a postgres connection string")
+	f = flag.String("f", "", "comma separated list of FDC JSON files or CSV directories to load")
+	u = flag.String("u", "", "events URL of a server to notify of the foods loaded, e.g. http://localhost:8000/graphql/events.  The admin API key is read from FDC_API_KEY.")
+	v = flag.String("v", "", "name of the release loaded -- defaults to the files loaded")
+)
+
+func main() {
+	flag.Parse()
+	db, err := sqldb.Open(*d, *n)
+	if err != nil {
+		log.Fatalf("Cannot open %s database %s %v", *d, *n, err)
+	}
+	defer db.Close()
+	if err = db.Migrate(); err != nil {
+		log.Fatalf("Cannot migrate the schema %v", err)
+	}
+	if *f == "" {
+		return
+	}
+	ids, err := db.LoadFoods(strings.Split(*f, ",")...)
+	if err != nil {
+		log.Fatalf("Cannot load %s %v", *f, err)
+	}
+	log.Printf("Loaded %d foods from %s\n", len(ids), *f)
+	if *u == "" {
+		return
+	}
+	release := *v
+	if release == "" {
+		release = *f
+	}
+	if err = events.Notify(*u, os.Getenv("FDC_API_KEY"), events.Notice{Type: events.ReleaseLoaded, Release: release, FdcIDs: ids}); err != nil {
+		log.Fatalf("Cannot notify %s %v", *u, err)
+	}
+	log.Printf("Notified %s\n", *u)
+}
@@ -0,0 +1,301 @@
+// Package complexity measures the depth and cost of GraphQL documents so
+// pathological queries can be refused before they are executed.  Every field
+// costs 1 plus the cost of its selections, multiplied for list fields by the
+// number of items they may return.
+package complexity
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// maxItems caps the number of items assumed for a list so a huge max argument
+// cannot overflow the cost
+const maxItems = 1000000
+
+// sizeArgs are arguments giving the number of items a list field returns.
+// Lists give it by their length.  first takes precedence over max.  A field
+// taking both fdcids and nutids returns a value for each food and nutrient.
+var sizeArgs = []string{"first", "max", "fdcids", "nutids", "ingredients"}
+
+// Config are the limits in the complexity section of the config file.  A limit
+// of zero is not checked.  ListSize is the number of items assumed for lists
+// without a size argument.  RankCost is added to a search sorted on a nutrient
+// which reads and ranks many more hits than it returns.
+type Config struct {
+	MaxDepth int `yaml:"maxDepth"`
+	MaxCost  int `yaml:"maxCost"`
+	ListSize int `yaml:"listSize"`
+	RankCost int `yaml:"rankCost"`
+}
+
+// Result is the depth and cost of a document
+type Result struct {
+	Depth int `json:"depth"`
+	Cost  int `json:"cost"`
+}
+
+// Check returns the depth and cost of the operation in a document and an error
+// if either is over its limit.  All operations are measured when operationName
+// is empty.
+func (c Config) Check(schema graphql.Schema, doc *ast.Document, operationName string, variables map[string]interface{}) (Result, error) {
+	r := c.Analyze(schema, doc, operationName, variables)
+	switch {
+	case c.MaxDepth > 0 && r.Depth > c.MaxDepth:
+		return r, fmt.Errorf("query depth %d exceeds the limit of %d", r.Depth, c.MaxDepth)
+	case c.MaxCost > 0 && r.Cost > c.MaxCost:
+		return r, fmt.Errorf("query cost %d exceeds the limit of %d.  Ask for fewer items or fields.", r.Cost, c.MaxCost)
+	}
+	return r, nil
+}
+
+// Analyze returns the greatest depth and cost of the operations in a document
+func (c Config) Analyze(schema graphql.Schema, doc *ast.Document, operationName string, variables map[string]interface{}) Result {
+	w := walker{cfg: c, schema: schema, frags: make(map[string]*ast.FragmentDefinition), visiting: make(map[string]bool)}
+	if w.cfg.ListSize <= 0 {
+		w.cfg.ListSize = 1
+	}
+	for _, d := range doc.Definitions {
+		if f, ok := d.(*ast.FragmentDefinition); ok {
+			w.frags[f.Name.Value] = f
+		}
+	}
+	var r Result
+	for _, d := range doc.Definitions {
+		op, ok := d.(*ast.OperationDefinition)
+		if !ok || (operationName != "" && (op.Name == nil || op.Name.Value != operationName)) {
+			continue
+		}
+		w.vars = make(map[string]interface{})
+		for _, v := range op.VariableDefinitions {
+			if v.DefaultValue != nil {
+				w.vars[v.Variable.Name.Value] = w.value(v.DefaultValue)
+			}
+		}
+		for k, v := range variables {
+			w.vars[k] = v
+		}
+		var root graphql.Type = schema.QueryType()
+		switch op.Operation {
+		case ast.OperationTypeMutation:
+			root = schema.MutationType()
+		case ast.OperationTypeSubscription:
+			root = schema.SubscriptionType()
+		}
+		depth, cost := w.selections(op.SelectionSet, root, 0)
+		if depth > r.Depth {
+			r.Depth = depth
+		}
+		if c := int(math.Min(cost, math.MaxInt32)); c > r.Cost {
+			r.Cost = c
+		}
+	}
+	return r
+}
+
+// walker measures the selections of an operation
+type walker struct {
+	cfg      Config
+	schema   graphql.Schema
+	vars     map[string]interface{}
+	frags    map[string]*ast.FragmentDefinition
+	visiting map[string]bool
+}
+
+// selections returns the depth and cost of a selection set on a type.  size is
+// the number of items given by the arguments of an enclosing field which is not
+// itself a list, e.g. first on a connection whose edges are the list.
+func (w *walker) selections(ss *ast.SelectionSet, parent graphql.Type, size int) (int, float64) {
+	var (
+		depth int
+		cost  float64
+	)
+	if ss == nil {
+		return 0, 0
+	}
+	add := func(d int, c float64) {
+		if d > depth {
+			depth = d
+		}
+		cost += c
+	}
+	for _, sel := range ss.Selections {
+		switch s := sel.(type) {
+		case *ast.Field:
+			// introspection is bounded by the schema so is not counted
+			if strings.HasPrefix(s.Name.Value, "__") {
+				continue
+			}
+			var (
+				ftype graphql.Type
+				def   *graphql.FieldDefinition
+			)
+			if def = fields(parent)[s.Name.Value]; def != nil {
+				ftype = def.Type
+			}
+			args := w.args(s)
+			n, sized := w.size(args, def)
+			inherit := 0
+			if sized && !isList(ftype) {
+				inherit = n
+			}
+			d, c := w.selections(s.SelectionSet, ftype, inherit)
+			if isList(ftype) {
+				switch {
+				case sized:
+				case size > 0:
+					n = size
+				default:
+					n = w.cfg.ListSize
+				}
+				c *= float64(n)
+			}
+			if search, ok := args["search"].(map[string]interface{}); ok && search["nutrientSort"] != nil {
+				c += float64(w.cfg.RankCost)
+			}
+			add(d+1, 1+c)
+		case *ast.InlineFragment:
+			t := parent
+			if s.TypeCondition != nil {
+				t = w.schema.Type(s.TypeCondition.Name.Value)
+			}
+			add(w.selections(s.SelectionSet, t, size))
+		case *ast.FragmentSpread:
+			name := s.Name.Value
+			f, ok := w.frags[name]
+			if !ok || w.visiting[name] {
+				continue
+			}
+			w.visiting[name] = true
+			add(w.selections(f.SelectionSet, w.schema.Type(f.TypeCondition.Name.Value), size))
+			delete(w.visiting, name)
+		}
+	}
+	return depth, cost
+}
+
+// args returns the values of a field's arguments.  The fields of input objects
+// such as browse.max are included unless the field has an argument of the same
+// name.
+func (w *walker) args(f *ast.Field) map[string]interface{} {
+	args := make(map[string]interface{})
+	for _, a := range f.Arguments {
+		args[a.Name.Value] = w.value(a.Value)
+	}
+	for _, v := range args {
+		if m, ok := v.(map[string]interface{}); ok {
+			for k, v := range m {
+				if _, ok := args[k]; !ok {
+					args[k] = v
+				}
+			}
+		}
+	}
+	return args
+}
+
+// size returns the number of items a field's arguments ask for
+func (w *walker) size(args map[string]interface{}, def *graphql.FieldDefinition) (int, bool) {
+	for _, name := range sizeArgs {
+		n := count(args[name])
+		if n <= 0 {
+			// resolvers give a size of 0 or less, or an empty list, their default
+			// size so it is left to the list size
+			continue
+		}
+		if name == "fdcids" && hasArg(def, "nutids") {
+			nutids := count(args["nutids"])
+			if nutids <= 0 {
+				nutids = w.cfg.ListSize
+			}
+			n = clamp(n) * clamp(nutids)
+		}
+		return clamp(n), true
+	}
+	return 0, false
+}
+
+// count returns the number given by a size argument
+func count(v interface{}) int {
+	switch v := v.(type) {
+	case int:
+		return v
+	case float64:
+		return int(math.Min(v, maxItems))
+	case []interface{}:
+		return len(v)
+	}
+	return 0
+}
+
+// hasArg reports whether a field takes an argument
+func hasArg(def *graphql.FieldDefinition, name string) bool {
+	if def == nil {
+		return false
+	}
+	for _, a := range def.Args {
+		if a.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// value converts an argument to the Go value graphql would give a resolver
+func (w *walker) value(v ast.Value) interface{} {
+	switch v := v.(type) {
+	case *ast.Variable:
+		return w.vars[v.Name.Value]
+	case *ast.IntValue:
+		n, err := strconv.Atoi(v.Value)
+		if err != nil {
+			return maxItems
+		}
+		return n
+	case *ast.ListValue:
+		l := make([]interface{}, len(v.Values))
+		for i, e := range v.Values {
+			l[i] = w.value(e)
+		}
+		return l
+	case *ast.ObjectValue:
+		m := make(map[string]interface{})
+		for _, f := range v.Fields {
+			m[f.Name.Value] = w.value(f.Value)
+		}
+		return m
+	}
+	return nil
+}
+
+// fields returns the fields of an object or interface type
+func fields(t graphql.Type) graphql.FieldDefinitionMap {
+	switch t := graphql.GetNamed(t).(type) {
+	case *graphql.Object:
+		return t.Fields()
+	case *graphql.Interface:
+		return t.Fields()
+	}
+	return nil
+}
+
+// isList reports whether a type is a list, allowing for non null
+func isList(t graphql.Type) bool {
+	if nn, ok := t.(*graphql.NonNull); ok {
+		t = nn.OfType
+	}
+	_, ok := t.(*graphql.List)
+	return ok
+}
+
+func clamp(n int) int {
+	if n > maxItems {
+		return maxItems
+	}
+	return n
+}
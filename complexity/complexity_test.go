@@ -0,0 +1,134 @@
+package complexity
+
+import (
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+// testSchema is a small schema shaped like the fdc schema's lists, input objects
+// and connections
+func testSchema(t *testing.T) graphql.Schema {
+	t.Helper()
+	nutrient := graphql.NewObject(graphql.ObjectConfig{Name: "Nutrient", Fields: graphql.Fields{
+		"value": &graphql.Field{Type: graphql.Float},
+	}})
+	food := graphql.NewObject(graphql.ObjectConfig{Name: "Food", Fields: graphql.Fields{
+		"fdcId":     &graphql.Field{Type: graphql.String},
+		"nutrients": &graphql.Field{Type: graphql.NewList(nutrient)},
+	}})
+	edge := graphql.NewObject(graphql.ObjectConfig{Name: "FoodEdge", Fields: graphql.Fields{
+		"cursor": &graphql.Field{Type: graphql.String},
+		"node":   &graphql.Field{Type: food},
+	}})
+	connection := graphql.NewObject(graphql.ObjectConfig{Name: "FoodConnection", Fields: graphql.Fields{
+		"totalCount": &graphql.Field{Type: graphql.Int},
+		"edges":      &graphql.Field{Type: graphql.NewList(edge)},
+	}})
+	browse := graphql.NewInputObject(graphql.InputObjectConfig{Name: "BrowseRequest", Fields: graphql.InputObjectConfigFieldMap{
+		"max": &graphql.InputObjectFieldConfig{Type: graphql.Int},
+	}})
+	nutrientSort := graphql.NewInputObject(graphql.InputObjectConfig{Name: "NutrientSort", Fields: graphql.InputObjectConfigFieldMap{
+		"nutrientno": &graphql.InputObjectFieldConfig{Type: graphql.Int},
+	}})
+	search := graphql.NewInputObject(graphql.InputObjectConfig{Name: "SearchRequest", Fields: graphql.InputObjectConfigFieldMap{
+		"terms":        &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"max":          &graphql.InputObjectFieldConfig{Type: graphql.Int},
+		"nutrientSort": &graphql.InputObjectFieldConfig{Type: nutrientSort},
+	}})
+	ids := &graphql.ArgumentConfig{Type: graphql.NewList(graphql.String)}
+	query := graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: graphql.Fields{
+		"foods": &graphql.Field{Type: graphql.NewList(food), Args: graphql.FieldConfigArgument{"fdcids": ids}},
+		"nutrientdata": &graphql.Field{Type: graphql.NewList(nutrient), Args: graphql.FieldConfigArgument{
+			"fdcids": ids,
+			"nutids": &graphql.ArgumentConfig{Type: graphql.NewList(graphql.Int)},
+		}},
+		"foodsBrowse": &graphql.Field{Type: graphql.NewList(food), Args: graphql.FieldConfigArgument{
+			"browse": &graphql.ArgumentConfig{Type: browse},
+		}},
+		"foodSearch": &graphql.Field{Type: graphql.NewList(food), Args: graphql.FieldConfigArgument{
+			"search": &graphql.ArgumentConfig{Type: search},
+		}},
+		"foodSearchConnection": &graphql.Field{Type: connection, Args: graphql.FieldConfigArgument{
+			"search": &graphql.ArgumentConfig{Type: search},
+			"first":  &graphql.ArgumentConfig{Type: graphql.Int},
+		}},
+	}})
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: query})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+func TestAnalyze(t *testing.T) {
+	schema := testSchema(t)
+	cfg := Config{ListSize: 10, RankCost: 100}
+	for _, tc := range []struct {
+		name  string
+		query string
+		vars  map[string]interface{}
+		depth int
+		cost  int
+	}{
+		{"list size", `{foodsBrowse{fdcId}}`, nil, 2, 11},
+		{"input object max", `{foodsBrowse(browse:{max:5}){fdcId}}`, nil, 2, 6},
+		{"zero max", `{foodsBrowse(browse:{max:0}){fdcId}}`, nil, 2, 11},
+		{"negative max", `{foodsBrowse(browse:{max:-1}){fdcId}}`, nil, 2, 11},
+		{"nested lists", `{foodsBrowse(browse:{max:2}){fdcId nutrients{value}}}`, nil, 3, 25},
+		{"variable", `query($n:Int){foodsBrowse(browse:{max:$n}){fdcId}}`, map[string]interface{}{"n": 3.0}, 2, 4},
+		{"variable default", `query($n:Int=4){foodsBrowse(browse:{max:$n}){fdcId}}`, nil, 2, 5},
+		{"variable input object", `query($b:BrowseRequest){foodsBrowse(browse:$b){fdcId}}`, map[string]interface{}{"b": map[string]interface{}{"max": 7.0}}, 2, 8},
+		{"fragment", `{foodsBrowse(browse:{max:2}){...f}} fragment f on Food{fdcId nutrients{value}}`, nil, 3, 25},
+		{"inline fragment", `{foodsBrowse(browse:{max:2}){... on Food{fdcId nutrients{value}}}}`, nil, 3, 25},
+		{"connection", `{foodSearchConnection(first:3){totalCount edges{node{fdcId}}}}`, nil, 4, 9},
+		{"connection fragment", `{foodSearchConnection(first:3){...c}} fragment c on FoodConnection{edges{cursor}}`, nil, 3, 5},
+		{"foods by id", `{foods(fdcids:["1","2"]){fdcId}}`, nil, 2, 3},
+		{"nutrientdata", `{nutrientdata(fdcids:["1","2"],nutids:[208,203,204]){value}}`, nil, 2, 7},
+		{"nutrientdata every nutrient", `{nutrientdata(fdcids:["1","2"]){value}}`, nil, 2, 21},
+		{"nutrientdata nutids", `{nutrientdata(nutids:[208,203]){value}}`, nil, 2, 3},
+		{"nutrientdata variables", `query($f:[String],$n:[Int]){nutrientdata(fdcids:$f,nutids:$n){value}}`, map[string]interface{}{
+			"f": []interface{}{"1", "2", "3"}, "n": []interface{}{208.0, 203.0},
+		}, 2, 7},
+		{"nutrient sort", `{foodSearch(search:{terms:"x",max:2,nutrientSort:{nutrientno:208}}){fdcId}}`, nil, 2, 103},
+		{"nutrient sort connection", `{foodSearchConnection(first:3,search:{terms:"x",nutrientSort:{nutrientno:208}}){totalCount}}`, nil, 2, 102},
+		{"introspection", `{__schema{types{name}} foods(fdcids:["1"]){fdcId}}`, nil, 2, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			doc, err := parser.Parse(parser.ParseParams{Source: tc.query})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r := cfg.Analyze(schema, doc, "", tc.vars); r.Depth != tc.depth || r.Cost != tc.cost {
+				t.Errorf("depth %d cost %d, want %d %d", r.Depth, r.Cost, tc.depth, tc.cost)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	schema := testSchema(t)
+	doc, err := parser.Parse(parser.ParseParams{Source: `query a{foodsBrowse(browse:{max:5}){fdcId}} query b{foodsBrowse{nutrients{value}}}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		cfg  Config
+		op   string
+		err  bool
+	}{
+		{"no limits", Config{ListSize: 10}, "", false},
+		{"depth", Config{ListSize: 10, MaxDepth: 2}, "", true},
+		{"depth of operation", Config{ListSize: 10, MaxDepth: 2}, "a", false},
+		{"cost", Config{ListSize: 10, MaxCost: 100}, "b", true},
+		{"cost of operation", Config{ListSize: 10, MaxCost: 100}, "a", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := tc.cfg.Check(schema, doc, tc.op, nil); (err != nil) != tc.err {
+				t.Errorf("error %v, want error %v", err, tc.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+// Package config reads the server settings kept in the YAML config file
+// alongside the fdc-api datastore settings
+package config
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/littlebunch/fdc-graphql/auth"
+	"github.com/littlebunch/fdc-graphql/cache"
+	"github.com/littlebunch/fdc-graphql/complexity"
+	"github.com/littlebunch/fdc-graphql/gqlhttp"
+	"github.com/littlebunch/fdc-graphql/persisted"
+	"github.com/littlebunch/fdc-graphql/ratelimit"
+	"github.com/littlebunch/fdc-graphql/subscriptions"
+	"github.com/littlebunch/fdc-graphql/suggest"
+	"gopkg.in/yaml.v2"
+)
+
+// Config are the server settings
+type Config struct {
+	Auth          auth.Config          `yaml:"auth"`
+	RateLimit     ratelimit.Config     `yaml:"ratelimit"`
+	Complexity    complexity.Config    `yaml:"complexity"`
+	Batch         gqlhttp.BatchConfig  `yaml:"batch"`
+	Persisted     persisted.Config     `yaml:"persisted"`
+	Subscriptions subscriptions.Config `yaml:"subscriptions"`
+	Cache         cache.Config         `yaml:"cache"`
+	Suggest       suggest.Config       `yaml:"suggest"`
+}
+
+// Read returns the settings in a YAML file with defaults for those not given.
+// A missing file gives the defaults.  FDC_AUTH_SECRET in the environment
+// overrides the auth secret.
+func Read(path string) (Config, error) {
+	cfg := Config{
+		Auth:          auth.Config{Timeout: time.Hour, Users: "users.json", Admin: auth.Admin},
+		Complexity:    complexity.Config{MaxDepth: 10, MaxCost: 25000, ListSize: 50, RankCost: 1000},
+		Batch:         gqlhttp.BatchConfig{MaxSize: 20, Concurrency: 4},
+		Persisted:     persisted.Config{Enabled: true, Size: 1000},
+		Subscriptions: subscriptions.Config{Enabled: true, KeepAlive: 15 * time.Second, MaxSubscriptions: 20},
+		Cache:         cache.Config{Enabled: true, Size: 10000, TTL: time.Hour, MaxAge: time.Minute},
+		Suggest:       suggest.Config{Enabled: true},
+	}
+	f, err := os.Open(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return cfg, err
+	default:
+		defer f.Close()
+		if err = yaml.NewDecoder(f).Decode(&cfg); err != nil && err != io.EOF {
+			return cfg, err
+		}
+	}
+	if s := os.Getenv("FDC_AUTH_SECRET"); s != "" {
+		cfg.Auth.Secret = s
+	}
+	return cfg, nil
+}
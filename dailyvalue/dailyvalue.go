@@ -0,0 +1,160 @@
+// Package dailyvalue holds the reference amounts used to express nutrient values
+// as a percent of daily value.  The built-in profiles are the FDA 2020 label
+// Daily Values.  Other profiles such as age and sex DRIs may be loaded from a
+// JSON file.
+package dailyvalue
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Default is the profile used when none is asked for
+const Default = "adult"
+
+// Reference is the daily amount of a nutrient in the unit FDC reports it in
+type Reference struct {
+	Nutrientno uint    `json:"nutrientno"`
+	Value      float64 `json:"value"`
+	Unit       string  `json:"unit"`
+}
+
+// Profile maps nutrient numbers to their daily reference amounts
+type Profile map[uint]Reference
+
+var (
+	mu       sync.RWMutex
+	profiles = map[string]Profile{
+		"adult":    fda(78, 20, 300, 2300, 275, 28, 50, 50, 20, 1300, 18, 4700, 900, 90, 15, 120, 1.2, 1.3, 16, 1.7, 400, 2.4, 30, 5, 1250, 420, 11, 55, 0.9, 2.3, 550),
+		"child":    fda(39, 10, 300, 1500, 150, 14, 25, 13, 15, 700, 7, 3000, 300, 15, 6, 30, 0.5, 0.5, 6, 0.5, 150, 0.9, 8, 2, 460, 80, 3, 20, 0.3, 1.2, 200),
+		"infant":   fda(30, 0, 0, 0, 95, 0, 0, 11, 10, 260, 11, 700, 500, 50, 5, 2.5, 0.3, 0.4, 4, 0.3, 80, 0.5, 6, 1.8, 275, 75, 3, 20, 0.2, 0.6, 150),
+		"pregnant": fda(78, 20, 300, 2300, 275, 28, 50, 71, 15, 1300, 27, 5100, 1300, 120, 19, 90, 1.4, 1.6, 18, 2.0, 600, 2.8, 35, 7, 1250, 400, 13, 70, 1.3, 2.6, 550),
+	}
+)
+
+// fdaNutrients are the nutrient numbers and units of the FDA Daily Values in
+// the order the fda function takes them
+var fdaNutrients = []Reference{
+	{Nutrientno: 204, Unit: "g"},  // total fat
+	{Nutrientno: 606, Unit: "g"},  // saturated fat
+	{Nutrientno: 601, Unit: "mg"}, // cholesterol
+	{Nutrientno: 307, Unit: "mg"}, // sodium
+	{Nutrientno: 205, Unit: "g"},  // total carbohydrate
+	{Nutrientno: 291, Unit: "g"},  // dietary fiber
+	{Nutrientno: 539, Unit: "g"},  // added sugars
+	{Nutrientno: 203, Unit: "g"},  // protein
+	{Nutrientno: 328, Unit: "µg"}, // vitamin D
+	{Nutrientno: 301, Unit: "mg"}, // calcium
+	{Nutrientno: 303, Unit: "mg"}, // iron
+	{Nutrientno: 306, Unit: "mg"}, // potassium
+	{Nutrientno: 320, Unit: "µg"}, // vitamin A, RAE
+	{Nutrientno: 401, Unit: "mg"}, // vitamin C
+	{Nutrientno: 323, Unit: "mg"}, // vitamin E
+	{Nutrientno: 430, Unit: "µg"}, // vitamin K
+	{Nutrientno: 404, Unit: "mg"}, // thiamin
+	{Nutrientno: 405, Unit: "mg"}, // riboflavin
+	{Nutrientno: 406, Unit: "mg"}, // niacin
+	{Nutrientno: 415, Unit: "mg"}, // vitamin B6
+	{Nutrientno: 435, Unit: "µg"}, // folate, DFE
+	{Nutrientno: 418, Unit: "µg"}, // vitamin B12
+	{Nutrientno: 416, Unit: "µg"}, // biotin
+	{Nutrientno: 410, Unit: "mg"}, // pantothenic acid
+	{Nutrientno: 305, Unit: "mg"}, // phosphorus
+	{Nutrientno: 304, Unit: "mg"}, // magnesium
+	{Nutrientno: 309, Unit: "mg"}, // zinc
+	{Nutrientno: 317, Unit: "µg"}, // selenium
+	{Nutrientno: 312, Unit: "mg"}, // copper
+	{Nutrientno: 315, Unit: "mg"}, // manganese
+	{Nutrientno: 421, Unit: "mg"}, // choline
+}
+
+// fda builds a Profile from Daily Values given in the order of fdaNutrients.
+// A zero value means the nutrient has no Daily Value for the profile.
+func fda(values ...float64) Profile {
+	p := make(Profile)
+	for i, v := range values {
+		if v > 0 {
+			r := fdaNutrients[i]
+			r.Value = v
+			p[r.Nutrientno] = r
+		}
+	}
+	return p
+}
+
+// Lookup returns a profile by name.  An empty name is the Default profile.
+func Lookup(name string) (Profile, error) {
+	if name == "" {
+		name = Default
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	p, ok := profiles[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown daily value profile %s.  Must be one of %s", name, strings.Join(names(), ", "))
+	}
+	return p, nil
+}
+
+// names returns the sorted profile names.  Callers must hold the lock.
+func names() []string {
+	var ns []string
+	for n := range profiles {
+		ns = append(ns, n)
+	}
+	sort.Strings(ns)
+	return ns
+}
+
+// Load adds the profiles in a JSON file replacing built-in profiles of the same
+// name.  The file maps profile names to lists of references, e.g.
+// {"female-31-50":[{"nutrientno":301,"value":1000,"unit":"mg"}]}
+func Load(path string) error {
+	var refs map[string][]Reference
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = json.NewDecoder(f).Decode(&refs); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for name, rs := range refs {
+		p := make(Profile)
+		for _, r := range rs {
+			if r.Nutrientno == 0 || r.Value <= 0 {
+				return fmt.Errorf("%s: profile %s needs a nutrientno and a positive value for every reference", path, name)
+			}
+			p[r.Nutrientno] = r
+		}
+		profiles[strings.ToLower(name)] = p
+	}
+	return nil
+}
+
+// Percent returns an amount of a nutrient as a percent of its daily value.  It
+// reports false if the profile has no daily value for the nutrient or the
+// amount is in a different unit.
+func (p Profile) Percent(nutrientno uint, value float64, unit string) (float64, bool) {
+	r, ok := p[nutrientno]
+	if !ok || (unit != "" && r.Unit != "" && normalize(unit) != normalize(r.Unit)) {
+		return 0, false
+	}
+	return value / r.Value * 100, true
+}
+
+// normalize folds the spellings of units found in FDC data
+func normalize(unit string) string {
+	u := strings.ToLower(strings.TrimSpace(unit))
+	switch u {
+	case "mcg", "ug", "μg":
+		return "µg"
+	}
+	return u
+}
@@ -0,0 +1,356 @@
+// Package couchbase implements datastore.Datastore on top of the fdc-api Couchbase client
+package couchbase
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/littlebunch/fdc-api/ds/cb"
+	fdc "github.com/littlebunch/fdc-api/model"
+	"github.com/littlebunch/fdc-graphql/datastore"
+	"gopkg.in/couchbase/gocb.v1"
+)
+
+// sortFields are the fields foods may be sorted on.  The sort field is the only
+// user input not sent to Couchbase as a query parameter.
+var sortFields = map[string]bool{
+	"fdcId":           true,
+	"foodDescription": true,
+	"company":         true,
+}
+
+// valueField is the NUTDATA field holding the value of a nutrient per 100g
+const valueField = "valuePer100UnitServing"
+
+// Datastore is a Couchbase backed datastore.Datastore
+type Datastore struct {
+	Cb *cb.Cb
+	Cs fdc.Config
+}
+
+// NewDatastore returns a Datastore for a connected Couchbase client
+func NewDatastore(c *cb.Cb, cs fdc.Config) *Datastore {
+	return &Datastore{Cb: c, Cs: cs}
+}
+
+// Get loads a food document by fdcId
+func (d *Datastore) Get(id string, f interface{}) error {
+	return d.Cb.Get(id, f)
+}
+
+// Browse queries a page of foods described by a BrowseRequest
+func (d *Datastore) Browse(br datastore.BrowseRequest) ([]interface{}, error) {
+	var (
+		food interface{}
+		rs   []interface{}
+	)
+	if !sortFields[br.Sort] {
+		return nil, fmt.Errorf("cannot sort on %s", br.Sort)
+	}
+	if br.After != nil && br.NutrientSort != nil {
+		return nil, datastore.ErrAfterNutrientSort
+	}
+	order, cmp := "ASC", ">"
+	if strings.ToUpper(br.Order) == "DESC" {
+		order, cmp = "DESC", "<"
+	}
+	where, params := browseWhere(d.Cs.CouchDb.Bucket, br)
+	if br.After != nil {
+		params = append(params, br.After.Value, br.After.FdcID)
+		where += fmt.Sprintf(" AND (food.%s %s $%d OR (food.%s = $%d AND food.fdcId %s $%d))", br.Sort, cmp, len(params)-1, br.Sort, len(params)-1, cmp, len(params))
+	}
+	from, sort := d.Cs.CouchDb.Bucket+" as food", "food."+br.Sort
+	if ns := br.NutrientSort; ns != nil {
+		var dt *fdc.DocType
+		params = append(params, dt.ToString(fdc.NUTDATA), ns.Nutrientno)
+		from += fmt.Sprintf(" left join %s as nv on nv.fdcId = food.fdcId and nv.type = $%d and nv.nutrientNumber = $%d", d.Cs.CouchDb.Bucket, len(params)-1, len(params))
+		sort = "nv." + valueField
+		if ns.PerServing {
+			sort += " * FIRST s.weight FOR s IN food.servingSizes WHEN s.weight > 0 END / 100"
+		}
+		sort = fmt.Sprintf("(%s) IS NOT VALUED, %s", sort, sort)
+	}
+	params = append(params, br.Offset, br.Max)
+	q := fmt.Sprintf("select food.* from %s where %s order by %s %s, food.fdcId %s offset $%d limit $%d", from, where, sort, order, order, len(params)-1, len(params))
+	rows, err := d.Cb.Conn.ExecuteN1qlQuery(gocb.NewN1qlQuery(q), params)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next(&food) {
+		rs = append(rs, food)
+		food = nil
+	}
+	return rs, rows.Close()
+}
+
+// BrowseCount counts the foods matching a BrowseRequest
+func (d *Datastore) BrowseCount(br datastore.BrowseRequest) (int, error) {
+	var c struct {
+		Count int `json:"count"`
+	}
+	where, params := browseWhere(d.Cs.CouchDb.Bucket, br)
+	q := fmt.Sprintf("select count(*) as count from %s as food where %s", d.Cs.CouchDb.Bucket, where)
+	rows, err := d.Cb.Conn.ExecuteN1qlQuery(gocb.NewN1qlQuery(q), params)
+	if err != nil {
+		return 0, err
+	}
+	err = rows.One(&c)
+	return c.Count, err
+}
+
+// browseWhere builds a N1QL where clause on foods aliased as food and its positional
+// parameters from a BrowseRequest.  User defined foods are included.
+func browseWhere(bucket string, br datastore.BrowseRequest) (string, []interface{}) {
+	var (
+		dt     *fdc.DocType
+		params []interface{}
+	)
+	params = append(params, []string{dt.ToString(fdc.FOOD), datastore.CUSTOM})
+	where := "food.type IN $1"
+	if len(br.FdcIDs) > 0 {
+		params = append(params, br.FdcIDs)
+		where += fmt.Sprintf(" AND food.fdcId in $%d", len(params))
+	}
+	if br.Source != "" {
+		params = append(params, br.Source)
+		where += fmt.Sprintf(" AND food.dataSource = $%d", len(params))
+	}
+	for _, nf := range br.Nutrients {
+		if _, ok := datastore.Ops[nf.Op]; !ok {
+			continue
+		}
+		params = append(params, dt.ToString(fdc.NUTDATA), nf.Nutrientno, nf.Value)
+		n := len(params)
+		where += fmt.Sprintf(" AND food.fdcId IN (SELECT RAW nd.fdcId FROM %s AS nd WHERE nd.type = $%d AND nd.nutrientNumber = $%d AND nd.%s %s $%d)", bucket, n-2, n-1, valueField, nf.Op, n)
+	}
+	return where, params
+}
+
+// Search runs a SearchRequest against the configured full-text index
+func (d *Datastore) Search(sr datastore.SearchRequest, foods *[]interface{}) (int, error) {
+	if datastore.IsWords(sr.SearchType) {
+		return d.searchWords(sr, foods)
+	}
+	sr.IndexName = d.Cs.CouchDb.Fts
+	return d.Cb.Search(sr.SearchRequest, foods)
+}
+
+// searchWords runs a FUZZY, PREFIX or MATCH search with the N1QL SEARCH
+// function ordered by score
+func (d *Datastore) searchWords(sr datastore.SearchRequest, foods *[]interface{}) (int, error) {
+	var (
+		food interface{}
+		c    struct {
+			Count int `json:"count"`
+		}
+	)
+	where, params, err := d.searchWhere(sr)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := d.Cb.Conn.ExecuteN1qlQuery(gocb.NewN1qlQuery(fmt.Sprintf("select count(*) as count from %s as food where %s", d.Cs.CouchDb.Bucket, where)), params)
+	if err != nil {
+		return 0, err
+	}
+	if err = rows.One(&c); err != nil {
+		return 0, err
+	}
+	params = append(params, sr.Page, sr.Max)
+	q := fmt.Sprintf("select food.* from %s as food where %s order by SEARCH_SCORE(food) desc, food.fdcId offset $3 limit $4", d.Cs.CouchDb.Bucket, where)
+	if rows, err = d.Cb.Conn.ExecuteN1qlQuery(gocb.NewN1qlQuery(q), params); err != nil {
+		return 0, err
+	}
+	for rows.Next(&food) {
+		*foods = append(*foods, food)
+		food = nil
+	}
+	return c.Count, rows.Close()
+}
+
+// searchWhere builds a N1QL where clause on foods aliased as food running a
+// SearchRequest on the full-text index with the SEARCH function.  The query
+// and its food types are the first two positional parameters.
+func (d *Datastore) searchWhere(sr datastore.SearchRequest) (string, []interface{}, error) {
+	var dt *fdc.DocType
+	query, err := ftsQuery(sr)
+	if err != nil {
+		return "", nil, err
+	}
+	params := []interface{}{[]string{dt.ToString(fdc.FOOD), datastore.CUSTOM}, map[string]interface{}{"query": query}}
+	return fmt.Sprintf("food.type IN $1 AND SEARCH(food, $2, {\"index\": %q})", d.Cs.CouchDb.Fts), params, nil
+}
+
+// ftsQuery returns the full-text query of a SearchRequest.  Each term of a
+// FUZZY, PREFIX or MATCH search becomes a match or prefix query.
+func ftsQuery(sr datastore.SearchRequest) (map[string]interface{}, error) {
+	var (
+		terms []interface{}
+		q     map[string]interface{}
+	)
+	switch sr.SearchType {
+	case fdc.PHRASE:
+		q = map[string]interface{}{"match_phrase": sr.Query}
+	case fdc.WILDCARD:
+		q = map[string]interface{}{"wildcard": sr.Query}
+	case fdc.REGEX:
+		q = map[string]interface{}{"regexp": sr.Query}
+	case datastore.FUZZY, datastore.PREFIX, datastore.MATCH:
+	default:
+		q = map[string]interface{}{"match": sr.Query, "operator": "and"}
+	}
+	if q != nil {
+		if sr.SearchField != "" {
+			q["field"] = sr.SearchField
+		}
+		return q, nil
+	}
+	for _, t := range sr.Terms() {
+		q := map[string]interface{}{"match": t, "fuzziness": sr.Edits(t)}
+		if sr.SearchType == datastore.PREFIX {
+			q = map[string]interface{}{"prefix": t}
+		}
+		if sr.SearchField != "" {
+			q["field"] = sr.SearchField
+		}
+		terms = append(terms, q)
+	}
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("no search terms")
+	}
+	if sr.Operator == datastore.OR {
+		return map[string]interface{}{"disjuncts": terms, "min": 1}, nil
+	}
+	return map[string]interface{}{"conjuncts": terms}, nil
+}
+
+// facetFields are the food fields counted by Facets
+var facetFields = []string{"dataSource", "foodGroup.description", "company"}
+
+// Facets counts the hits of a SearchRequest grouped by field values and in
+// nutrient value ranges with N1QL aggregates over the SEARCH function
+func (d *Datastore) Facets(sr datastore.SearchRequest, fr datastore.FacetRequest) (datastore.Facets, error) {
+	var dt *fdc.DocType
+	fs := datastore.Facets{Nutrients: []datastore.NutrientFacet{}}
+	where, params, err := d.searchWhere(sr)
+	if err != nil {
+		return fs, err
+	}
+	counts := make([][]datastore.FacetCount, len(facetFields))
+	for i, f := range facetFields {
+		counts[i] = []datastore.FacetCount{}
+		q := fmt.Sprintf("select food.%s as `value`, count(*) as `count` from %s as food where %s and food.%s != \"\" group by food.%s order by count(*) desc, food.%s limit $3", f, d.Cs.CouchDb.Bucket, where, f, f, f)
+		rows, err := d.Cb.Conn.ExecuteN1qlQuery(gocb.NewN1qlQuery(q), append(params, fr.Size))
+		if err != nil {
+			return fs, err
+		}
+		var c datastore.FacetCount
+		for rows.Next(&c) {
+			counts[i] = append(counts[i], c)
+			c = datastore.FacetCount{}
+		}
+		if err = rows.Close(); err != nil {
+			return fs, err
+		}
+	}
+	fs.DataSource, fs.Category, fs.Company = counts[0], counts[1], counts[2]
+	for _, nr := range fr.Nutrients {
+		var sums []string
+		np := append(params, dt.ToString(fdc.NUTDATA), nr.Nutrientno)
+		for i, r := range nr.Ranges {
+			cond := "true"
+			if r.Min != nil {
+				np = append(np, *r.Min)
+				cond += fmt.Sprintf(" and nd.%s >= $%d", valueField, len(np))
+			}
+			if r.Max != nil {
+				np = append(np, *r.Max)
+				cond += fmt.Sprintf(" and nd.%s < $%d", valueField, len(np))
+			}
+			sums = append(sums, fmt.Sprintf("sum(case when %s then 1 else 0 end) as r%d", cond, i))
+		}
+		q := fmt.Sprintf("select %s from %s as nd where nd.type = $3 and nd.nutrientNumber = $4 and nd.fdcId in (select raw food.fdcId from %s as food where %s)", strings.Join(sums, ", "), d.Cs.CouchDb.Bucket, d.Cs.CouchDb.Bucket, where)
+		rows, err := d.Cb.Conn.ExecuteN1qlQuery(gocb.NewN1qlQuery(q), np)
+		if err != nil {
+			return fs, err
+		}
+		var row map[string]float64
+		if err = rows.One(&row); err != nil {
+			return fs, err
+		}
+		nf := datastore.NutrientFacet{Nutrientno: nr.Nutrientno, Ranges: []datastore.RangeCount{}}
+		for i, r := range nr.Ranges {
+			nf.Ranges = append(nf.Ranges, datastore.RangeCount{Min: r.Min, Max: r.Max, Count: int(row[fmt.Sprintf("r%d", i)])})
+		}
+		fs.Nutrients = append(fs.Nutrients, nf)
+	}
+	return fs, nil
+}
+
+// GetDictionary returns documents of a dictionary type
+func (d *Datastore) GetDictionary(doctype string, offset int64, limit int64) ([]interface{}, error) {
+	return d.Cb.GetDictionary(d.Cs.CouchDb.Bucket, doctype, offset, limit)
+}
+
+// NutrientData queries NUTDATA documents for a list of fdcIds and nutrient numbers
+func (d *Datastore) NutrientData(fdcids []string, nutids []int) ([]fdc.NutrientData, error) {
+	var (
+		dt      *fdc.DocType
+		nutdata []fdc.NutrientData
+		rows    gocb.QueryResults
+		err     error
+	)
+	params := []interface{}{dt.ToString(fdc.NUTDATA), fdcids}
+	q := fmt.Sprintf("select nutrientdata.* from %s as nutrientdata where type = $1 and fdcId in $2", d.Cs.CouchDb.Bucket)
+	if len(nutids) > 0 {
+		params = append(params, nutids)
+		q += " and nutrientNumber in $3"
+	}
+	rows, err = d.Cb.Conn.ExecuteN1qlQuery(gocb.NewN1qlQuery(q+" order by fdcId,nutrientNumber"), params)
+	if err != nil {
+		return nil, err
+	}
+	// put the query results into the nutrientdata array
+	for {
+		var nut fdc.NutrientData
+		if !rows.Next(&nut) {
+			break
+		}
+		nutdata = append(nutdata, nut)
+	}
+	return nutdata, rows.Close()
+}
+
+// PutFood upserts a food document replacing its NUTDATA documents
+func (d *Datastore) PutFood(f fdc.Food, nd []fdc.NutrientData) error {
+	if err := d.deleteNutrientData(f.FdcID); err != nil {
+		return err
+	}
+	if _, err := d.Cb.Conn.Upsert(f.FdcID, f, 0); err != nil {
+		return err
+	}
+	for _, n := range nd {
+		if _, err := d.Cb.Conn.Upsert(fmt.Sprintf("%s_%d", n.FdcID, n.Nutrientno), n, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteFood removes a food document and its NUTDATA documents
+func (d *Datastore) DeleteFood(fdcid string) error {
+	if _, err := d.Cb.Conn.Remove(fdcid, 0); err != nil {
+		return err
+	}
+	return d.deleteNutrientData(fdcid)
+}
+
+// deleteNutrientData removes the NUTDATA documents of a food
+func (d *Datastore) deleteNutrientData(fdcid string) error {
+	var dt *fdc.DocType
+	q := fmt.Sprintf("delete from %s where type = $1 and fdcId = $2", d.Cs.CouchDb.Bucket)
+	rows, err := d.Cb.Conn.ExecuteN1qlQuery(gocb.NewN1qlQuery(q), []interface{}{dt.ToString(fdc.NUTDATA), fdcid})
+	if err != nil {
+		return err
+	}
+	return rows.Close()
+}
@@ -0,0 +1,105 @@
+package couchbase
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	fdc "github.com/littlebunch/fdc-api/model"
+	"github.com/littlebunch/fdc-graphql/datastore"
+)
+
+// injections are user values which would change a query built by concatenation
+var injections = []string{
+	"'; DELETE FROM fdc; --",
+	"x' OR '1'='1",
+	"x\") OR true --",
+	"` UNION SELECT * FROM fdc",
+}
+
+func TestBrowseWhereParameters(t *testing.T) {
+	for _, q := range injections {
+		br := datastore.BrowseRequest{
+			FdcIDs:    []string{q},
+			Source:    q,
+			Nutrients: []datastore.NutrientFilter{{Nutrientno: 208, Op: ">", Value: 1}, {Nutrientno: 208, Op: q, Value: 1}},
+		}
+		where, params := browseWhere("fdc", br)
+		if strings.Contains(where, q) {
+			t.Errorf("%q is in %s", q, where)
+		}
+		if !reflect.DeepEqual(params[1], []string{q}) || params[2] != q {
+			t.Errorf("%q parameters are %v", q, params)
+		}
+		if n := strings.Count(where, "nutrientNumber"); n != 1 {
+			t.Errorf("%d nutrient filters in %s, want 1", n, where)
+		}
+	}
+}
+
+func TestBrowseSort(t *testing.T) {
+	d := &Datastore{}
+	for _, sort := range []string{"ingredients", "fdcId desc", "fdcId; DELETE FROM fdc", "food.fdcId"} {
+		if _, err := d.Browse(datastore.BrowseRequest{Sort: sort, Max: 10}); err == nil {
+			t.Errorf("sort %q is accepted", sort)
+		}
+	}
+	br := datastore.BrowseRequest{Sort: "fdcId", Max: 10, After: &datastore.Key{Value: "1", FdcID: "1"}, NutrientSort: &datastore.NutrientSort{Nutrientno: 208}}
+	if _, err := d.Browse(br); err != datastore.ErrAfterNutrientSort {
+		t.Errorf("after with a nutrient sort returned %v", err)
+	}
+}
+
+func TestFtsQuery(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		sr   datastore.SearchRequest
+		want map[string]interface{}
+		err  bool
+	}{
+		{"default", sr("bread rolls", "", ""), map[string]interface{}{"match": "bread rolls", "operator": "and"}, false},
+		{"phrase", sr("whole wheat", fdc.PHRASE, "ingredients"), map[string]interface{}{"match_phrase": "whole wheat", "field": "ingredients"}, false},
+		{"wildcard", sr("brea*", fdc.WILDCARD, ""), map[string]interface{}{"wildcard": "brea*"}, false},
+		{"regex", sr("^bre", fdc.REGEX, "foodDescription_kw"), map[string]interface{}{"regexp": "^bre", "field": "foodDescription_kw"}, false},
+		{"prefix", sr("bre ro", datastore.PREFIX, ""), map[string]interface{}{"conjuncts": []interface{}{
+			map[string]interface{}{"prefix": "bre"}, map[string]interface{}{"prefix": "ro"},
+		}}, false},
+		{"fuzzy", sr("bred", datastore.FUZZY, "company"), map[string]interface{}{"conjuncts": []interface{}{
+			map[string]interface{}{"match": "bred", "fuzziness": 1, "field": "company"},
+		}}, false},
+		{"injection", sr("x' OR '1'='1", "", ""), map[string]interface{}{"match": "x' OR '1'='1", "operator": "and"}, false},
+		{"no terms", sr("--", datastore.MATCH, ""), nil, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			q, err := ftsQuery(tc.sr)
+			if (err != nil) != tc.err {
+				t.Fatalf("error %v, want error %v", err, tc.err)
+			}
+			if !tc.err && !reflect.DeepEqual(q, tc.want) {
+				t.Errorf("query %v, want %v", q, tc.want)
+			}
+		})
+	}
+}
+
+func TestSearchWhereParameters(t *testing.T) {
+	d := &Datastore{}
+	d.Cs.CouchDb.Fts = "fd_food"
+	for _, q := range injections {
+		where, params, err := d.searchWhere(sr(q, datastore.MATCH, ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(where, q) || len(params) != 2 {
+			t.Errorf("%q is not a parameter of %s", q, where)
+		}
+	}
+}
+
+func sr(query, searchType, field string) datastore.SearchRequest {
+	return datastore.SearchRequest{
+		SearchRequest: fdc.SearchRequest{Query: query, SearchType: searchType, SearchField: field},
+		Fuzziness:     datastore.AutoFuzziness,
+		Operator:      datastore.AND,
+	}
+}
@@ -0,0 +1,122 @@
+// Package datastore defines the interface the GraphQL resolvers use to query
+// a Food Data Central database.  Implementations live in sub-packages.
+package datastore
+
+import (
+	"errors"
+	"strings"
+
+	fdc "github.com/littlebunch/fdc-api/model"
+)
+
+// User defined foods are kept alongside the FDC foods with their own document
+// type and dataSource.  Their fdcIds start with CustomPrefix so they never
+// collide with the ids assigned by FDC.
+const (
+	CUSTOM       = "CUSTOM"
+	CustomPrefix = "C"
+)
+
+// IsCustom reports whether an fdcId belongs to a user defined food
+func IsCustom(fdcid string) bool {
+	return strings.HasPrefix(fdcid, CustomPrefix)
+}
+
+// ErrAfterNutrientSort is returned by Browse for a request with both After and
+// NutrientSort as a food's key does not hold its nutrient value.  Page foods
+// sorted on a nutrient with Offset instead.
+var ErrAfterNutrientSort = errors.New("after cannot be combined with a nutrient sort")
+
+// BrowseRequest describes a page of foods to be returned by Browse
+type BrowseRequest struct {
+	FdcIDs []string
+	Source string
+	Offset int64
+	Max    int64
+	Sort   string
+	Order  string
+	// After restricts the list to foods which sort after a previously seen food.
+	// It cannot be combined with NutrientSort.
+	After *Key
+	// Nutrients restricts the list to foods with nutrient values passing every filter
+	Nutrients []NutrientFilter
+	// NutrientSort orders the list by a nutrient value instead of the Sort field
+	NutrientSort *NutrientSort
+}
+
+// NutrientSort orders foods by their value of a nutrient, either per 100g or per
+// the weight of the food's first serving.  Foods without a value sort last.
+type NutrientSort struct {
+	Nutrientno uint
+	PerServing bool
+}
+
+// ServingWeight returns the weight in g or ml of the first serving with a weight
+func ServingWeight(servings []fdc.Serving) (float64, bool) {
+	for _, s := range servings {
+		if s.Weight > 0 {
+			return float64(s.Weight), true
+		}
+	}
+	return 0, false
+}
+
+// SortValue returns the value used to order a food by a NutrientSort given the
+// food's servings and its nutrient value per 100g
+func (ns NutrientSort) SortValue(servings []fdc.Serving, value float64) (float64, bool) {
+	if !ns.PerServing {
+		return value, true
+	}
+	w, ok := ServingWeight(servings)
+	return value * w / 100, ok
+}
+
+// NutrientFilter compares the value of a nutrient per 100g of a food with a
+// value.  Op is one of >, >=, <, <= or =.  Foods without the nutrient never pass.
+type NutrientFilter struct {
+	Nutrientno uint
+	Op         string
+	Value      float64
+}
+
+// Ops are the comparisons a NutrientFilter may make
+var Ops = map[string]func(a, b float64) bool{
+	">":  func(a, b float64) bool { return a > b },
+	">=": func(a, b float64) bool { return a >= b },
+	"<":  func(a, b float64) bool { return a < b },
+	"<=": func(a, b float64) bool { return a <= b },
+	"=":  func(a, b float64) bool { return a == b },
+}
+
+// Key identifies a food's position in a sorted list by the value of the sort
+// field and its fdcId which breaks ties
+type Key struct {
+	Value string `json:"v"`
+	FdcID string `json:"id"`
+}
+
+// Datastore wraps the queries required to resolve the FDC schema
+type Datastore interface {
+	// Get loads the food identified by an fdcId into f
+	Get(id string, f interface{}) error
+	// Browse returns a list of foods described by a BrowseRequest
+	Browse(br BrowseRequest) ([]interface{}, error)
+	// BrowseCount returns the number of foods matching a BrowseRequest ignoring its paging
+	BrowseCount(br BrowseRequest) (int, error)
+	// Search runs a full-text search putting hits into foods and returning the total hit count
+	Search(sr SearchRequest, foods *[]interface{}) (int, error)
+	// Facets counts the hits of a search by dataSource, category, company and nutrient value ranges
+	Facets(sr SearchRequest, fr FacetRequest) (Facets, error)
+	// GetDictionary returns a list of documents for a dictionary type, e.g. NUT
+	GetDictionary(doctype string, offset int64, limit int64) ([]interface{}, error)
+	// NutrientData returns nutrient values for a list of foods.  An empty nutids returns all nutrients.
+	NutrientData(fdcids []string, nutids []int) ([]fdc.NutrientData, error)
+}
+
+// Writer is implemented by datastores which can store user defined foods
+type Writer interface {
+	// PutFood creates or replaces a food with its nutrient values
+	PutFood(f fdc.Food, nd []fdc.NutrientData) error
+	// DeleteFood removes a food and its nutrient values
+	DeleteFood(fdcid string) error
+}
@@ -0,0 +1,78 @@
+package datastore
+
+import "sort"
+
+// FacetRequest asks for the facets of a search.  Size is the most values
+// returned for each of dataSource, category and company.
+type FacetRequest struct {
+	Size      int
+	Nutrients []NutrientRanges
+}
+
+// NutrientRanges asks for the number of hits with a value of a nutrient per
+// 100g in each of a list of ranges
+type NutrientRanges struct {
+	Nutrientno uint
+	Ranges     []Range
+}
+
+// Range holds the values from Min up to but not including Max.  A nil Min or
+// Max leaves that end open.
+type Range struct {
+	Min *float64
+	Max *float64
+}
+
+// Contains reports whether a value is in the range
+func (r Range) Contains(v float64) bool {
+	return (r.Min == nil || v >= *r.Min) && (r.Max == nil || v < *r.Max)
+}
+
+// Facets counts the hits of a search by the values of their fields and by
+// ranges of nutrient values
+type Facets struct {
+	DataSource []FacetCount    `json:"dataSource"`
+	Category   []FacetCount    `json:"category"`
+	Company    []FacetCount    `json:"company"`
+	Nutrients  []NutrientFacet `json:"nutrients"`
+}
+
+// FacetCount is the number of hits with a value
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// NutrientFacet is the number of hits in each range of a nutrient's values
+type NutrientFacet struct {
+	Nutrientno uint         `json:"nutrientno"`
+	Ranges     []RangeCount `json:"ranges"`
+}
+
+// RangeCount is the number of hits with a nutrient value in a range
+type RangeCount struct {
+	Min   *float64 `json:"min"`
+	Max   *float64 `json:"max"`
+	Count int      `json:"count"`
+}
+
+// TopCounts returns up to size of the most common values in counts, most
+// common first and ties in order of value.  Empty values are left out.
+func TopCounts(counts map[string]int, size int) []FacetCount {
+	rs := []FacetCount{}
+	for v, n := range counts {
+		if v != "" {
+			rs = append(rs, FacetCount{Value: v, Count: n})
+		}
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		if rs[i].Count != rs[j].Count {
+			return rs[i].Count > rs[j].Count
+		}
+		return rs[i].Value < rs[j].Value
+	})
+	if len(rs) > size {
+		rs = rs[:size]
+	}
+	return rs
+}
@@ -0,0 +1,176 @@
+package fdcload
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	fdc "github.com/littlebunch/fdc-api/model"
+)
+
+// row gives access to the columns of a csv record by header name
+type row map[string]string
+
+func (r row) float(col string) float32 {
+	v, _ := strconv.ParseFloat(r[col], 32)
+	return float32(v)
+}
+
+func (r row) int(col string) int {
+	v, _ := strconv.Atoi(r[col])
+	return v
+}
+
+// readCSV calls fn for each record in a csv file in dir.  Files missing from
+// the download are skipped.
+func readCSV(dir, name string, fn func(r row)) error {
+	f, err := os.Open(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cr := csv.NewReader(f)
+	cr.ReuseRecord = true
+	header, err := cr.Read()
+	if err != nil {
+		return err
+	}
+	header = append([]string(nil), header...)
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		r := make(row, len(header))
+		for i, h := range header {
+			if i < len(rec) {
+				r[h] = rec[i]
+			}
+		}
+		fn(r)
+	}
+}
+
+// LoadCSV reads an unzipped FDC CSV download from dir into a Store
+func LoadCSV(s Store, dir string) error {
+	var (
+		dt         *fdc.DocType
+		err        error
+		foods      = make(map[string]*fdc.Food)
+		nutdata    = make(map[string][]fdc.NutrientData)
+		nutrients  = make(map[string]fdc.Nutrient)
+		categories = make(map[string]*fdc.FoodGroup)
+		wweia      = make(map[string]*fdc.FoodGroup)
+		units      = make(map[string]string)
+		dervs      = make(map[string]fdc.Derivation)
+	)
+	// dictionaries
+	steps := []struct {
+		file string
+		fn   func(r row)
+	}{
+		{"food_category.csv", func(r row) {
+			categories[r["id"]] = &fdc.FoodGroup{ID: int32(r.int("id")), Code: r["code"], Description: r["description"], Type: dt.ToString(fdc.FGGPC)}
+		}},
+		{"wweia_food_category.csv", func(r row) {
+			code := r["wweia_food_category"]
+			wweia[code] = &fdc.FoodGroup{ID: int32(r.int("wweia_food_category")), Code: code, Description: r["wweia_food_category_description"], Type: dt.ToString(fdc.FGGPC)}
+		}},
+		{"measure_unit.csv", func(r row) {
+			units[r["id"]] = r["name"]
+		}},
+		{"food_nutrient_derivation.csv", func(r row) {
+			dervs[r["id"]] = fdc.Derivation{ID: int32(r.int("id")), Code: r["code"], Description: r["description"], Type: dt.ToString(fdc.DERV)}
+		}},
+		{"nutrient.csv", func(r row) {
+			no, perr := strconv.ParseFloat(r["nutrient_nbr"], 64)
+			if perr != nil {
+				return
+			}
+			n := fdc.Nutrient{ID: int64(r.int("id")), Nutrientno: uint(no), Name: r["name"], Unit: r["unit_name"], Type: dt.ToString(fdc.NUT)}
+			nutrients[r["id"]] = n
+			if err == nil {
+				err = s.PutNutrient(n)
+			}
+		}},
+		// foods
+		{"food.csv", func(r row) {
+			source, ok := dataSources[r["data_type"]]
+			if !ok {
+				return
+			}
+			f := &fdc.Food{FdcID: r["fdc_id"], Description: r["description"], Source: source, Type: dt.ToString(fdc.FOOD)}
+			if g, ok := categories[r["food_category_id"]]; ok && source != "FNDDS" {
+				f.Group = g
+			} else if g, ok := wweia[r["food_category_id"]]; ok && source == "FNDDS" {
+				f.Group = g
+			}
+			foods[f.FdcID] = f
+		}},
+		{"branded_food.csv", func(r row) {
+			f, ok := foods[r["fdc_id"]]
+			if !ok {
+				return
+			}
+			f.Source = r["data_source"]
+			f.Upc = r["gtin_upc"]
+			f.Ingredients = r["ingredients"]
+			f.Manufacturer = r["brand_owner"]
+			if r["branded_food_category"] != "" {
+				f.Group = &fdc.FoodGroup{Description: r["branded_food_category"], Type: dt.ToString(fdc.FGGPC)}
+			}
+			if size := r.float("serving_size"); size > 0 {
+				f.Servings = append(f.Servings, fdc.Serving{
+					Nutrientbasis: r["serving_size_unit"],
+					Description:   r["household_serving_fulltext"],
+					Weight:        size,
+					Servingamount: size,
+				})
+			}
+		}},
+		{"food_portion.csv", func(r row) {
+			f, ok := foods[r["fdc_id"]]
+			if !ok {
+				return
+			}
+			f.Servings = append(f.Servings, portion(r["portion_description"], r["modifier"], units[r["measure_unit_id"]], r.float("amount"), r.float("gram_weight"), int32(r.int("data_points"))))
+		}},
+		{"food_nutrient.csv", func(r row) {
+			f, ok := foods[r["fdc_id"]]
+			if !ok {
+				return
+			}
+			n, ok := nutrients[r["nutrient_id"]]
+			if !ok {
+				return
+			}
+			var derv *fdc.Derivation
+			if dv, ok := dervs[r["derivation_id"]]; ok {
+				derv = &dv
+			}
+			nutdata[f.FdcID] = append(nutdata[f.FdcID], nutrientData(*f, n, r.float("amount"), r.int("data_points"), r.float("min"), r.float("max"), derv))
+		}},
+	}
+	for _, step := range steps {
+		if e := readCSV(dir, step.file, step.fn); e != nil {
+			return e
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for id, f := range foods {
+		if err = s.PutFood(*f, nutdata[id]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+// Package fdcload reads USDA FoodData Central bulk downloads in JSON or CSV
+// format and hands each food to a Store.
+package fdcload
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	fdc "github.com/littlebunch/fdc-api/model"
+)
+
+// Store receives the nutrients and foods read from a download
+type Store interface {
+	PutNutrient(n fdc.Nutrient) error
+	PutFood(f fdc.Food, nd []fdc.NutrientData) error
+}
+
+// Recorder is a Store which notes the fdcIds of the foods it passes on, e.g. to
+// report them to a server once a load is committed
+type Recorder struct {
+	Store
+	FdcIDs []string
+}
+
+// PutFood stores a food and records its fdcId
+func (r *Recorder) PutFood(f fdc.Food, nd []fdc.NutrientData) error {
+	if err := r.Store.PutFood(f, nd); err != nil {
+		return err
+	}
+	r.FdcIDs = append(r.FdcIDs, f.FdcID)
+	return nil
+}
+
+// dataSources maps FDC data types to the dataSource codes used by fdc-ingest.
+// Branded foods carry their own GDSN or LI code.
+var dataSources = map[string]string{
+	"Branded":           "",
+	"branded_food":      "",
+	"SR Legacy":         "SR",
+	"sr_legacy_food":    "SR",
+	"Foundation":        "FOUNDATION",
+	"foundation_food":   "FOUNDATION",
+	"Survey (FNDDS)":    "FNDDS",
+	"survey_fndds_food": "FNDDS",
+}
+
+type jsonNutrient struct {
+	ID       int64  `json:"id"`
+	Number   string `json:"number"`
+	Name     string `json:"name"`
+	UnitName string `json:"unitName"`
+}
+
+type jsonFoodNutrient struct {
+	Nutrient   jsonNutrient    `json:"nutrient"`
+	Amount     float32         `json:"amount"`
+	DataPoints int             `json:"dataPoints"`
+	Min        float32         `json:"min"`
+	Max        float32         `json:"max"`
+	Derivation *fdc.Derivation `json:"foodNutrientDerivation"`
+}
+
+type jsonPortion struct {
+	Amount             float32 `json:"amount"`
+	GramWeight         float32 `json:"gramWeight"`
+	Modifier           string  `json:"modifier"`
+	PortionDescription string  `json:"portionDescription"`
+	DataPoints         int32   `json:"dataPoints"`
+	MeasureUnit        struct {
+		Name string `json:"name"`
+	} `json:"measureUnit"`
+}
+
+type jsonFood struct {
+	FdcID                    int64              `json:"fdcId"`
+	Description              string             `json:"description"`
+	DataType                 string             `json:"dataType"`
+	DataSource               string             `json:"dataSource"`
+	GtinUpc                  string             `json:"gtinUpc"`
+	Ingredients              string             `json:"ingredients"`
+	BrandOwner               string             `json:"brandOwner"`
+	BrandedFoodCategory      string             `json:"brandedFoodCategory"`
+	ServingSize              float32            `json:"servingSize"`
+	ServingSizeUnit          string             `json:"servingSizeUnit"`
+	HouseholdServingFullText string             `json:"householdServingFullText"`
+	FoodCategory             *fdc.FoodGroup     `json:"foodCategory"`
+	WweiaFoodCategory        *jsonWweiaCategory `json:"wweiaFoodCategory"`
+	FoodNutrients            []jsonFoodNutrient `json:"foodNutrients"`
+	FoodPortions             []jsonPortion      `json:"foodPortions"`
+}
+
+type jsonWweiaCategory struct {
+	Code        int32  `json:"wweiaFoodCategoryCode"`
+	Description string `json:"wweiaFoodCategoryDescription"`
+}
+
+// Load reads one or more FDC downloads into a Store.  A directory is read as an
+// unzipped CSV download; any other path is read as a JSON download.
+func Load(s Store, paths ...string) error {
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			err = LoadCSV(s, path)
+		} else {
+			var f *os.File
+			if f, err = os.Open(path); err != nil {
+				return err
+			}
+			err = LoadJSON(s, f)
+			f.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// LoadJSON streams the foods in an FDC JSON download, e.g. {"BrandedFoods":[...]},
+// into a Store one at a time so large files need not be held in memory
+func LoadJSON(s Store, r io.Reader) error {
+	dec := json.NewDecoder(r)
+	if err := expect(dec, json.Delim('{')); err != nil {
+		return err
+	}
+	for dec.More() {
+		// skip the name of the food list
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+		if err := expect(dec, json.Delim('[')); err != nil {
+			return err
+		}
+		for dec.More() {
+			var jf jsonFood
+			if err := dec.Decode(&jf); err != nil {
+				return err
+			}
+			if err := putJSONFood(s, jf); err != nil {
+				return err
+			}
+		}
+		if err := expect(dec, json.Delim(']')); err != nil {
+			return err
+		}
+	}
+	return expect(dec, json.Delim('}'))
+}
+
+// expect reads the next token and checks it is the delimiter wanted
+func expect(dec *json.Decoder, want json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t != want {
+		return fmt.Errorf("expected %v but found %v", want, t)
+	}
+	return nil
+}
+
+// putJSONFood converts an FDC JSON food to a fdc.Food and its nutrient data
+func putJSONFood(s Store, jf jsonFood) error {
+	var (
+		dt *fdc.DocType
+		nd []fdc.NutrientData
+	)
+	source, ok := dataSources[jf.DataType]
+	if !ok {
+		return nil
+	}
+	if source == "" {
+		source = jf.DataSource
+	}
+	f := fdc.Food{
+		FdcID:        strconv.FormatInt(jf.FdcID, 10),
+		Upc:          jf.GtinUpc,
+		Description:  jf.Description,
+		Ingredients:  jf.Ingredients,
+		Source:       source,
+		Type:         dt.ToString(fdc.FOOD),
+		Manufacturer: jf.BrandOwner,
+	}
+	switch {
+	case jf.BrandedFoodCategory != "":
+		f.Group = &fdc.FoodGroup{Description: jf.BrandedFoodCategory}
+	case jf.FoodCategory != nil:
+		f.Group = jf.FoodCategory
+	case jf.WweiaFoodCategory != nil:
+		f.Group = &fdc.FoodGroup{ID: jf.WweiaFoodCategory.Code, Code: strconv.Itoa(int(jf.WweiaFoodCategory.Code)), Description: jf.WweiaFoodCategory.Description}
+	}
+	if f.Group != nil {
+		f.Group.Type = dt.ToString(fdc.FGGPC)
+	}
+	if jf.ServingSize > 0 {
+		f.Servings = append(f.Servings, fdc.Serving{
+			Nutrientbasis: jf.ServingSizeUnit,
+			Description:   jf.HouseholdServingFullText,
+			Weight:        jf.ServingSize,
+			Servingamount: jf.ServingSize,
+		})
+	}
+	for _, p := range jf.FoodPortions {
+		f.Servings = append(f.Servings, portion(p.PortionDescription, p.Modifier, p.MeasureUnit.Name, p.Amount, p.GramWeight, p.DataPoints))
+	}
+	for _, fn := range jf.FoodNutrients {
+		no, err := strconv.Atoi(fn.Nutrient.Number)
+		if err != nil {
+			continue
+		}
+		n := fdc.Nutrient{
+			ID:         fn.Nutrient.ID,
+			Nutrientno: uint(no),
+			Name:       fn.Nutrient.Name,
+			Unit:       fn.Nutrient.UnitName,
+			Type:       dt.ToString(fdc.NUT),
+		}
+		if err := s.PutNutrient(n); err != nil {
+			return err
+		}
+		nd = append(nd, nutrientData(f, n, fn.Amount, fn.DataPoints, fn.Min, fn.Max, fn.Derivation))
+	}
+	return s.PutFood(f, nd)
+}
+
+// portion creates a Serving from an FDC food portion which is reported in grams
+func portion(description, modifier, unit string, amount, grams float32, datapoints int32) fdc.Serving {
+	if description == "" || description == "Quantity not specified" {
+		description = strings.TrimSpace(strings.TrimPrefix(unit+" "+modifier, "undetermined"))
+	}
+	return fdc.Serving{
+		Nutrientbasis: "g",
+		Description:   description,
+		Weight:        grams,
+		Servingamount: amount,
+		Datapoints:    datapoints,
+	}
+}
+
+// nutrientData creates a NUTDATA value for a food
+func nutrientData(f fdc.Food, n fdc.Nutrient, value float32, datapoints int, min, max float32, derv *fdc.Derivation) fdc.NutrientData {
+	var dt *fdc.DocType
+	if derv != nil {
+		derv.Type = dt.ToString(fdc.DERV)
+	}
+	return fdc.NutrientData{
+		FdcID:      f.FdcID,
+		Source:     f.Source,
+		Type:       dt.ToString(fdc.NUTDATA),
+		Value:      value,
+		Unit:       n.Unit,
+		Derivation: derv,
+		Nutrientno: n.Nutrientno,
+		Nutrient:   n.Name,
+		Datapoints: datapoints,
+		Min:        min,
+		Max:        max,
+	}
+}
@@ -0,0 +1,522 @@
+// Package memory implements datastore.Datastore with foods held in memory.  Foods
+// are loaded from USDA FoodData Central bulk downloads in JSON or CSV format so
+// the API can be run without a Couchbase cluster.
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	fdc "github.com/littlebunch/fdc-api/model"
+	"github.com/littlebunch/fdc-graphql/datastore"
+	"github.com/littlebunch/fdc-graphql/datastore/fdcload"
+)
+
+// Datastore holds foods, nutrient data and the nutrient dictionary in memory
+type Datastore struct {
+	mu        sync.RWMutex
+	foods     map[string]*fdc.Food
+	nutdata   map[string][]fdc.NutrientData
+	nutrients map[uint]fdc.Nutrient
+	sorted    map[string][]*fdc.Food
+}
+
+// NewDatastore returns an empty Datastore
+func NewDatastore() *Datastore {
+	return &Datastore{
+		foods:     make(map[string]*fdc.Food),
+		nutdata:   make(map[string][]fdc.NutrientData),
+		nutrients: make(map[uint]fdc.Nutrient),
+	}
+}
+
+// Get loads a food by fdcId into f which must be a *fdc.Food or a pointer to
+// a type the food can be JSON decoded into
+func (d *Datastore) Get(id string, f interface{}) error {
+	d.mu.RLock()
+	food, ok := d.foods[id]
+	d.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("food %s not found", id)
+	}
+	if fp, ok := f.(*fdc.Food); ok {
+		*fp = *food
+		return nil
+	}
+	b, err := json.Marshal(food)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, f)
+}
+
+// Browse returns a page of foods sorted on foodDescription, company or fdcId
+func (d *Datastore) Browse(br datastore.BrowseRequest) ([]interface{}, error) {
+	var rs []interface{}
+	if br.After != nil && br.NutrientSort != nil {
+		return nil, datastore.ErrAfterNutrientSort
+	}
+	foods, err := d.filter(br)
+	if err != nil {
+		return nil, err
+	}
+	start := int64(0)
+	if br.After != nil {
+		start = int64(sort.Search(len(foods), func(i int) bool { return after(foods[i], br) }))
+	}
+	for i := start + br.Offset; i < int64(len(foods)) && int64(len(rs)) < br.Max; i++ {
+		rs = append(rs, *foods[i])
+	}
+	return rs, nil
+}
+
+// BrowseCount returns the number of foods matching a BrowseRequest
+func (d *Datastore) BrowseCount(br datastore.BrowseRequest) (int, error) {
+	foods, err := d.filter(br)
+	return len(foods), err
+}
+
+// filter returns the foods matching a BrowseRequest in the order requested
+func (d *Datastore) filter(br datastore.BrowseRequest) ([]*fdc.Food, error) {
+	var foods []*fdc.Food
+	d.mu.Lock()
+	if d.sorted == nil {
+		d.index()
+	}
+	list, ok := d.sorted[br.Sort]
+	if ok && len(br.FdcIDs) > 0 {
+		// a few foods are found quicker by id than by walking every food
+		list = d.lookup(br.FdcIDs, br.Sort)
+	}
+	d.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("cannot sort on %s", br.Sort)
+	}
+	desc := strings.ToUpper(br.Order) == "DESC"
+	for i := range list {
+		f := list[i]
+		if desc {
+			f = list[len(list)-1-i]
+		}
+		if br.Source != "" && f.Source != br.Source {
+			continue
+		}
+		if len(br.Nutrients) > 0 && !d.passes(f.FdcID, br.Nutrients) {
+			continue
+		}
+		foods = append(foods, f)
+	}
+	if br.NutrientSort != nil {
+		d.sortByNutrient(foods, *br.NutrientSort, desc)
+	}
+	return foods, nil
+}
+
+// sortByNutrient orders foods by a nutrient value keeping foods without a value
+// last.  The sort is stable so ties stay in fdcId order.
+func (d *Datastore) sortByNutrient(foods []*fdc.Food, ns datastore.NutrientSort, desc bool) {
+	type sortValue struct {
+		v  float64
+		ok bool
+	}
+	values := make(map[string]sortValue, len(foods))
+	d.mu.RLock()
+	for _, f := range foods {
+		v, ok := d.value(f.FdcID, ns.Nutrientno)
+		if ok {
+			v, ok = ns.SortValue(f.Servings, v)
+		}
+		values[f.FdcID] = sortValue{v, ok}
+	}
+	d.mu.RUnlock()
+	sort.SliceStable(foods, func(i, j int) bool {
+		a, b := values[foods[i].FdcID], values[foods[j].FdcID]
+		if a.ok != b.ok {
+			return a.ok
+		}
+		if desc {
+			return a.v > b.v
+		}
+		return a.v < b.v
+	})
+}
+
+// passes reports whether a food's nutrient values pass every filter
+func (d *Datastore) passes(fdcid string, filters []datastore.NutrientFilter) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, nf := range filters {
+		v, ok := d.value(fdcid, nf.Nutrientno)
+		if !ok || !datastore.Ops[nf.Op](v, nf.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// value returns a food's value for a nutrient.  Callers must hold the lock.
+func (d *Datastore) value(fdcid string, nutrientno uint) (float64, bool) {
+	for _, n := range d.nutdata[fdcid] {
+		if n.Nutrientno == nutrientno {
+			return float64(n.Value), true
+		}
+	}
+	return 0, false
+}
+
+// after reports whether a food sorts after the key in a BrowseRequest
+func after(f *fdc.Food, br datastore.BrowseRequest) bool {
+	k := br.After
+	v := sortValue(f, br.Sort)
+	if strings.ToUpper(br.Order) == "DESC" {
+		if br.Sort != "fdcId" && v != k.Value {
+			return v < k.Value
+		}
+		return lessID(f.FdcID, k.FdcID)
+	}
+	if br.Sort != "fdcId" && v != k.Value {
+		return v > k.Value
+	}
+	return lessID(k.FdcID, f.FdcID)
+}
+
+// sortValue returns the value of a food's sort field
+func sortValue(f *fdc.Food, field string) string {
+	switch field {
+	case "foodDescription":
+		return f.Description
+	case "company":
+		return f.Manufacturer
+	}
+	return f.FdcID
+}
+
+// Search scans foods for a SearchRequest putting a page of hits into foods and
+// returning the total number of hits
+func (d *Datastore) Search(sr datastore.SearchRequest, foods *[]interface{}) (int, error) {
+	match, err := matcher(sr)
+	if err != nil {
+		return 0, err
+	}
+	field := strings.TrimSuffix(sr.SearchField, "_kw")
+	d.mu.Lock()
+	if d.sorted == nil {
+		d.index()
+	}
+	list := d.sorted["fdcId"]
+	d.mu.Unlock()
+	count := 0
+	for _, f := range list {
+		if !match(searchFields(f, field)) {
+			continue
+		}
+		if count >= sr.Page && count < sr.Page+sr.Max {
+			*foods = append(*foods, hit(f))
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Facets scans foods for a SearchRequest counting the hits by field values and
+// nutrient ranges
+func (d *Datastore) Facets(sr datastore.SearchRequest, fr datastore.FacetRequest) (datastore.Facets, error) {
+	fs := datastore.Facets{Nutrients: []datastore.NutrientFacet{}}
+	match, err := matcher(sr)
+	if err != nil {
+		return fs, err
+	}
+	field := strings.TrimSuffix(sr.SearchField, "_kw")
+	sources, categories, companies := make(map[string]int), make(map[string]int), make(map[string]int)
+	ranges := make([][]int, len(fr.Nutrients))
+	for i, nr := range fr.Nutrients {
+		ranges[i] = make([]int, len(nr.Ranges))
+	}
+	d.mu.Lock()
+	if d.sorted == nil {
+		d.index()
+	}
+	list := d.sorted["fdcId"]
+	d.mu.Unlock()
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, f := range list {
+		if !match(searchFields(f, field)) {
+			continue
+		}
+		sources[f.Source]++
+		categories[category(f)]++
+		companies[f.Manufacturer]++
+		for i, nr := range fr.Nutrients {
+			for _, nd := range d.nutdata[f.FdcID] {
+				if nd.Nutrientno != nr.Nutrientno {
+					continue
+				}
+				for j, r := range nr.Ranges {
+					if r.Contains(float64(nd.Value)) {
+						ranges[i][j]++
+					}
+				}
+			}
+		}
+	}
+	fs.DataSource = datastore.TopCounts(sources, fr.Size)
+	fs.Category = datastore.TopCounts(categories, fr.Size)
+	fs.Company = datastore.TopCounts(companies, fr.Size)
+	for i, nr := range fr.Nutrients {
+		nf := datastore.NutrientFacet{Nutrientno: nr.Nutrientno, Ranges: []datastore.RangeCount{}}
+		for j, r := range nr.Ranges {
+			nf.Ranges = append(nf.Ranges, datastore.RangeCount{Min: r.Min, Max: r.Max, Count: ranges[i][j]})
+		}
+		fs.Nutrients = append(fs.Nutrients, nf)
+	}
+	return fs, nil
+}
+
+// GetDictionary returns the nutrient dictionary.  Only the NUT document type is
+// kept in memory.
+func (d *Datastore) GetDictionary(doctype string, offset int64, limit int64) ([]interface{}, error) {
+	var (
+		dt  *fdc.DocType
+		rs  []interface{}
+		nos []int
+	)
+	if doctype != dt.ToString(fdc.NUT) {
+		return nil, fmt.Errorf("unsupported dictionary %s", doctype)
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for no := range d.nutrients {
+		nos = append(nos, int(no))
+	}
+	sort.Ints(nos)
+	for i := offset; i < int64(len(nos)) && int64(len(rs)) < limit; i++ {
+		rs = append(rs, d.nutrients[uint(nos[i])])
+	}
+	return rs, nil
+}
+
+// NutrientData returns the nutrient values for a list of foods ordered by fdcId and nutrient number
+func (d *Datastore) NutrientData(fdcids []string, nutids []int) ([]fdc.NutrientData, error) {
+	var nutdata []fdc.NutrientData
+	nos := make(map[uint]bool)
+	for _, n := range nutids {
+		nos[uint(n)] = true
+	}
+	ids := append([]string(nil), fdcids...)
+	sort.Slice(ids, func(i, j int) bool { return lessID(ids[i], ids[j]) })
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for i, id := range ids {
+		// a repeated id has the same values as the one before it
+		if i > 0 && id == ids[i-1] {
+			continue
+		}
+		for _, n := range d.nutdata[id] {
+			if len(nos) == 0 || nos[n.Nutrientno] {
+				nutdata = append(nutdata, n)
+			}
+		}
+	}
+	return nutdata, nil
+}
+
+// Load reads FDC JSON files or CSV download directories into the datastore
+func (d *Datastore) Load(paths ...string) error {
+	return fdcload.Load(d, paths...)
+}
+
+// PutFood stores a food and its nutrient data replacing any previous version
+func (d *Datastore) PutFood(f fdc.Food, nd []fdc.NutrientData) error {
+	sort.Slice(nd, func(i, j int) bool { return nd[i].Nutrientno < nd[j].Nutrientno })
+	d.mu.Lock()
+	d.foods[f.FdcID] = &f
+	d.nutdata[f.FdcID] = nd
+	d.sorted = nil
+	d.mu.Unlock()
+	return nil
+}
+
+// DeleteFood removes a food and its nutrient values
+func (d *Datastore) DeleteFood(fdcid string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.foods[fdcid]; !ok {
+		return fmt.Errorf("food %s not found", fdcid)
+	}
+	delete(d.foods, fdcid)
+	delete(d.nutdata, fdcid)
+	d.sorted = nil
+	return nil
+}
+
+// PutNutrient adds a nutrient to the dictionary
+func (d *Datastore) PutNutrient(n fdc.Nutrient) error {
+	d.mu.Lock()
+	d.nutrients[n.Nutrientno] = n
+	d.mu.Unlock()
+	return nil
+}
+
+// index builds the lists of foods in each supported sort order.  Callers must hold the lock.
+func (d *Datastore) index() {
+	var byID []*fdc.Food
+	for _, f := range d.foods {
+		byID = append(byID, f)
+	}
+	sort.Slice(byID, func(i, j int) bool { return lessID(byID[i].FdcID, byID[j].FdcID) })
+	d.sorted = map[string][]*fdc.Food{"fdcId": byID}
+	for _, field := range []string{"foodDescription", "company"} {
+		field := field
+		list := append([]*fdc.Food(nil), byID...)
+		sort.SliceStable(list, func(i, j int) bool { return sortValue(list[i], field) < sortValue(list[j], field) })
+		d.sorted[field] = list
+	}
+}
+
+// lookup returns the foods with fdcIds in the order of the sorted list of a
+// field.  Callers must hold the lock.
+func (d *Datastore) lookup(fdcids []string, field string) []*fdc.Food {
+	foods := make([]*fdc.Food, 0, len(fdcids))
+	seen := make(map[string]bool, len(fdcids))
+	for _, id := range fdcids {
+		if f, ok := d.foods[id]; ok && !seen[id] {
+			seen[id] = true
+			foods = append(foods, f)
+		}
+	}
+	sort.Slice(foods, func(i, j int) bool {
+		a, b := foods[i], foods[j]
+		if va, vb := sortValue(a, field), sortValue(b, field); field != "fdcId" && va != vb {
+			return va < vb
+		}
+		return lessID(a.FdcID, b.FdcID)
+	})
+	return foods
+}
+
+// lessID orders fdcIds numerically with FDC's before custom C ids.  Without
+// leading zeros a number is smaller the shorter it is.
+func lessID(a, b string) bool {
+	if ac, bc := datastore.IsCustom(a), datastore.IsCustom(b); ac != bc {
+		return bc
+	}
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// category returns the description of a food's group if it has one
+func category(f *fdc.Food) string {
+	if f.Group == nil {
+		return ""
+	}
+	return f.Group.Description
+}
+
+// hit creates a FoodSearch result for a food
+func hit(f *fdc.Food) map[string]interface{} {
+	return map[string]interface{}{
+		"fdcId":           f.FdcID,
+		"upc":             f.Upc,
+		"foodDescription": f.Description,
+		"ingredients":     f.Ingredients,
+		"dataSource":      f.Source,
+		"company":         f.Manufacturer,
+		"category":        category(f),
+	}
+}
+
+// searchFields returns the values of a food to be searched for a field.  An
+// empty field searches all text fields.
+func searchFields(f *fdc.Food, field string) []string {
+	switch field {
+	case "foodDescription":
+		return []string{f.Description}
+	case "company":
+		return []string{f.Manufacturer}
+	case "ingredients":
+		return []string{f.Ingredients}
+	case "upc":
+		return []string{f.Upc}
+	case "foodGroup.description":
+		return []string{category(f)}
+	case "":
+		return []string{f.Description, f.Manufacturer, f.Ingredients, f.Upc, category(f)}
+	}
+	return nil
+}
+
+// matcher returns a function which tests field values against a SearchRequest
+func matcher(sr datastore.SearchRequest) (func([]string) bool, error) {
+	terms := strings.ToLower(sr.Query)
+	if datastore.IsWords(sr.SearchType) {
+		return func(vals []string) bool {
+			var words []string
+			for _, v := range vals {
+				words = append(words, datastore.Tokens(v)...)
+			}
+			return sr.Matches(words)
+		}, nil
+	}
+	switch sr.SearchType {
+	case fdc.PHRASE:
+		return func(vals []string) bool {
+			for _, v := range vals {
+				if strings.Contains(strings.ToLower(v), terms) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case fdc.WILDCARD:
+		// the pattern may span words, e.g. "chicken br*", and need not match the
+		// whole value, as with the SQL datastore's LIKE '%pattern%'
+		re, err := regexp.Compile("(?s)" + strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(regexp.QuoteMeta(terms)))
+		if err != nil {
+			return nil, err
+		}
+		return func(vals []string) bool {
+			for _, v := range vals {
+				if re.MatchString(strings.ToLower(v)) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case fdc.REGEX:
+		re, err := regexp.Compile(sr.Query)
+		if err != nil {
+			return nil, err
+		}
+		return func(vals []string) bool {
+			for _, v := range vals {
+				if re.MatchString(v) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+	// default is every term appearing somewhere in the fields
+	want := datastore.Tokens(terms)
+	return func(vals []string) bool {
+		have := make(map[string]bool)
+		for _, v := range vals {
+			for _, w := range datastore.Tokens(v) {
+				have[w] = true
+			}
+		}
+		for _, w := range want {
+			if !have[w] {
+				return false
+			}
+		}
+		return len(want) > 0
+	}, nil
+}
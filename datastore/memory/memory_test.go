@@ -0,0 +1,106 @@
+package memory
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	fdc "github.com/littlebunch/fdc-api/model"
+	"github.com/littlebunch/fdc-graphql/datastore"
+)
+
+// testDatastore returns a Datastore holding a few foods with energy values
+func testDatastore(t *testing.T) *Datastore {
+	t.Helper()
+	d := NewDatastore()
+	for _, f := range []struct {
+		food fdc.Food
+		kcal float32
+	}{
+		{fdc.Food{FdcID: "1000", Description: "Broccoli soup", Manufacturer: "Acme", Source: "GDSN"}, 40},
+		{fdc.Food{FdcID: "999", Description: "Chicken breast, roasted", Source: "SR"}, 165},
+		{fdc.Food{FdcID: "3", Description: "Broccoli, raw", Source: "SR"}, 34},
+		{fdc.Food{FdcID: "C1", Description: "Chicken broth", Source: datastore.CUSTOM}, 15},
+	} {
+		if err := d.PutFood(f.food, []fdc.NutrientData{{FdcID: f.food.FdcID, Nutrientno: 208, Value: f.kcal}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return d
+}
+
+func foodIDs(t *testing.T, rs []interface{}) string {
+	t.Helper()
+	var ids []string
+	for _, f := range rs {
+		ids = append(ids, f.(fdc.Food).FdcID)
+	}
+	return strings.Join(ids, ",")
+}
+
+// errSort stands for the error of an unknown sort field
+var errSort = errors.New("cannot sort")
+
+func TestBrowse(t *testing.T) {
+	d := testDatastore(t)
+	energy := &datastore.NutrientSort{Nutrientno: 208}
+	for _, tc := range []struct {
+		name string
+		br   datastore.BrowseRequest
+		want string
+		err  error
+	}{
+		{"fdcId", datastore.BrowseRequest{Sort: "fdcId", Max: 10}, "3,999,1000,C1", nil},
+		{"after", datastore.BrowseRequest{Sort: "fdcId", Max: 10, After: &datastore.Key{Value: "999", FdcID: "999"}}, "1000,C1", nil},
+		{"nutrient sort", datastore.BrowseRequest{Sort: "fdcId", Order: "DESC", Max: 10, NutrientSort: energy}, "999,1000,3,C1", nil},
+		{"nutrient sort page", datastore.BrowseRequest{Sort: "fdcId", Order: "DESC", Max: 2, Offset: 1, NutrientSort: energy}, "1000,3", nil},
+		{"ids", datastore.BrowseRequest{Sort: "fdcId", Max: 10, FdcIDs: []string{"C1", "1000", "3", "404", "3"}}, "3,1000,C1", nil},
+		{"ids by description", datastore.BrowseRequest{Sort: "foodDescription", Order: "DESC", Max: 10, FdcIDs: []string{"3", "999", "1000"}}, "999,3,1000", nil},
+		{"ids after", datastore.BrowseRequest{Sort: "fdcId", Max: 10, FdcIDs: []string{"C1", "1000", "3"}, After: &datastore.Key{Value: "3", FdcID: "3"}}, "1000,C1", nil},
+		{"ids with source", datastore.BrowseRequest{Sort: "fdcId", Max: 10, FdcIDs: []string{"C1", "999", "1000"}, Source: "SR"}, "999", nil},
+		{"unknown ids", datastore.BrowseRequest{Sort: "fdcId", Max: 10, FdcIDs: []string{"404"}}, "", nil},
+		{"ids unknown sort", datastore.BrowseRequest{Sort: "ingredients", Max: 10, FdcIDs: []string{"3"}}, "", errSort},
+		{"after with nutrient sort", datastore.BrowseRequest{Sort: "fdcId", Max: 10, After: &datastore.Key{Value: "1000", FdcID: "1000"}, NutrientSort: energy}, "", datastore.ErrAfterNutrientSort},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rs, err := d.Browse(tc.br)
+			if err != tc.err && (tc.err != errSort || err == nil) {
+				t.Fatalf("error %v, want %v", err, tc.err)
+			}
+			if got := foodIDs(t, rs); got != tc.want {
+				t.Errorf("foods %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWildcardSearch(t *testing.T) {
+	d := testDatastore(t)
+	for _, tc := range []struct {
+		query string
+		field string
+		want  string
+	}{
+		{"chicken br*", "", "999,C1"},
+		{"chicken br*st", "", "999"},
+		{"CHICKEN BR?TH", "foodDescription", "C1"},
+		{"broc*", "", "3,1000"},
+		{"oli, r", "", "3"},
+		{"ac?e", "company", "1000"},
+		{"ac?e", "foodDescription", ""},
+		{"(br*", "", ""},
+	} {
+		var foods []interface{}
+		sr := datastore.SearchRequest{SearchRequest: fdc.SearchRequest{Query: tc.query, SearchType: fdc.WILDCARD, SearchField: tc.field, Max: 10}}
+		if _, err := d.Search(sr, &foods); err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, f := range foods {
+			ids = append(ids, f.(map[string]interface{})["fdcId"].(string))
+		}
+		if got := strings.Join(ids, ","); got != tc.want {
+			t.Errorf("%s on %q: %s, want %s", tc.query, tc.field, got, tc.want)
+		}
+	}
+}
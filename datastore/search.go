@@ -0,0 +1,152 @@
+package datastore
+
+import (
+	"strings"
+
+	fdc "github.com/littlebunch/fdc-api/model"
+)
+
+// Search types matching each term against the words of a food in addition to
+// the fdc-api PHRASE, WILDCARD and REGEX types.  FUZZY allows a few edits
+// between a term and a word, PREFIX matches words starting with a term and
+// MATCH matches whole words.
+const (
+	FUZZY  = "FUZZY"
+	PREFIX = "PREFIX"
+	MATCH  = "MATCH"
+)
+
+// Operators of FUZZY, PREFIX and MATCH searches.  AND requires every term to
+// match a word and OR any of them.
+const (
+	AND = "AND"
+	OR  = "OR"
+)
+
+// AutoFuzziness lets the length of a term decide the edits it allows
+const AutoFuzziness = -1
+
+// MaxFuzziness is the most edits a term may allow
+const MaxFuzziness = 2
+
+// SearchRequest is an fdc-api SearchRequest with the options of the word
+// matching search types
+type SearchRequest struct {
+	fdc.SearchRequest
+	// Fuzziness is the edits allowed between a term and a word by FUZZY and MATCH searches
+	Fuzziness int
+	// Operator is AND or OR
+	Operator string
+}
+
+// IsWords reports whether a search type matches terms against words
+func IsWords(searchType string) bool {
+	return searchType == FUZZY || searchType == PREFIX || searchType == MATCH
+}
+
+// Terms returns the lower case words of the query
+func (sr SearchRequest) Terms() []string {
+	return Tokens(sr.Query)
+}
+
+// Edits returns the number of edits a term allows.  With AutoFuzziness FUZZY
+// terms of 3 to 5 letters allow 1 and longer terms 2 while MATCH terms must be
+// spelt exactly.
+func (sr SearchRequest) Edits(term string) int {
+	if sr.SearchType == PREFIX {
+		return 0
+	}
+	if sr.Fuzziness != AutoFuzziness {
+		return sr.Fuzziness
+	}
+	n := len([]rune(term))
+	switch {
+	case sr.SearchType != FUZZY || n < 3:
+		return 0
+	case n <= 5:
+		return 1
+	}
+	return 2
+}
+
+// MatchWord reports whether a word matches a term for a search type allowing
+// a number of edits
+func MatchWord(searchType, term, word string, edits int) bool {
+	if searchType == PREFIX {
+		return strings.HasPrefix(word, term)
+	}
+	return Distance(term, word, edits) <= edits
+}
+
+// Matches reports whether the words of a food pass a FUZZY, PREFIX or MATCH
+// search
+func (sr SearchRequest) Matches(words []string) bool {
+	terms := sr.Terms()
+	if len(terms) == 0 {
+		return false
+	}
+	for _, t := range terms {
+		edits, found := sr.Edits(t), false
+		for _, w := range words {
+			if MatchWord(sr.SearchType, t, w, edits) {
+				found = true
+				break
+			}
+		}
+		if found && sr.Operator == OR {
+			return true
+		}
+		if !found && sr.Operator != OR {
+			return false
+		}
+	}
+	return sr.Operator != OR
+}
+
+// Tokens splits a string into lower case words
+func Tokens(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r > 127)
+	})
+}
+
+// Distance returns the Levenshtein distance between two words or max+1 once it
+// is known to exceed max
+func Distance(a, b string, max int) int {
+	s, t := []rune(a), []rune(b)
+	if d := len(s) - len(t); d > max || -d > max {
+		return max + 1
+	}
+	prev := make([]int, len(t)+1)
+	cur := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur[0] = i
+		low := cur[0]
+		for j := 1; j <= len(t); j++ {
+			cur[j] = prev[j-1]
+			if s[i-1] != t[j-1] {
+				cur[j]++
+			}
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+			if cur[j] < low {
+				low = cur[j]
+			}
+		}
+		if low > max {
+			return max + 1
+		}
+		prev, cur = cur, prev
+	}
+	if prev[len(t)] > max {
+		return max + 1
+	}
+	return prev[len(t)]
+}
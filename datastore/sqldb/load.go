@@ -0,0 +1,67 @@
+package sqldb
+
+import (
+	"database/sql"
+
+	fdc "github.com/littlebunch/fdc-api/model"
+)
+
+// txStore writes the foods read by fdcload inside a transaction
+type txStore struct {
+	tx *sql.Tx
+}
+
+// PutNutrient inserts or updates a nutrient in the dictionary
+func (s *txStore) PutNutrient(n fdc.Nutrient) error {
+	_, err := s.tx.Exec(`INSERT INTO nutrients (nutrientno, id, tagname, name, unit) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (nutrientno) DO UPDATE SET id = excluded.id, tagname = excluded.tagname, name = excluded.name, unit = excluded.unit`,
+		n.Nutrientno, n.ID, n.Tagname, n.Name, n.Unit)
+	return err
+}
+
+// PutFood replaces a food with its servings and nutrient data
+func (s *txStore) PutFood(f fdc.Food, nd []fdc.NutrientData) error {
+	var (
+		catID      sql.NullInt64
+		code, desc sql.NullString
+	)
+	if f.Group != nil {
+		catID = sql.NullInt64{Int64: int64(f.Group.ID), Valid: f.Group.ID != 0}
+		code = sql.NullString{String: f.Group.Code, Valid: f.Group.Code != ""}
+		desc = sql.NullString{String: f.Group.Description, Valid: true}
+	}
+	if _, err := s.tx.Exec(`DELETE FROM foods WHERE fdc_id = $1`, f.FdcID); err != nil {
+		return err
+	}
+	if f.Type == "" {
+		var dt *fdc.DocType
+		f.Type = dt.ToString(fdc.FOOD)
+	}
+	if _, err := s.tx.Exec(`INSERT INTO foods (`+foodColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		f.FdcID, f.Upc, f.Description, f.Ingredients, f.Source, f.Manufacturer, catID, code, desc, f.Type); err != nil {
+		return err
+	}
+	for i, sv := range f.Servings {
+		if _, err := s.tx.Exec(`INSERT INTO servings (fdc_id, seq, nutrient_basis, serving_unit, serving_state, weight, value, data_points) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			f.FdcID, i, sv.Nutrientbasis, sv.Description, sv.Servingstate, sv.Weight, sv.Servingamount, sv.Datapoints); err != nil {
+			return err
+		}
+	}
+	for _, n := range nd {
+		var (
+			dervID        sql.NullInt64
+			dervCode, dsc sql.NullString
+		)
+		if n.Derivation != nil {
+			dervID = sql.NullInt64{Int64: int64(n.Derivation.ID), Valid: true}
+			dervCode = sql.NullString{String: n.Derivation.Code, Valid: true}
+			dsc = sql.NullString{String: n.Derivation.Description, Valid: true}
+		}
+		if _, err := s.tx.Exec(`INSERT INTO nutrient_data (fdc_id, nutrientno, value, unit, nutrient, source, datapoints, min, max, derivation_id, derivation_code, derivation_description)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) ON CONFLICT DO NOTHING`,
+			f.FdcID, n.Nutrientno, n.Value, n.Unit, n.Nutrient, n.Source, n.Datapoints, n.Min, n.Max, dervID, dervCode, dsc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package sqldb
+
+import "fmt"
+
+// migration is a schema change as statements for each driver.  The statements
+// under "" run on drivers without their own.
+type migration map[string][]string
+
+// all returns a migration which runs the same statements on every driver
+func all(stmts ...string) migration {
+	return migration{"": stmts}
+}
+
+// migrations are applied in order to bring a database up to the current
+// schema.  The number of migrations applied is kept in schema_version so
+// new ones must only ever be appended.
+var migrations = []migration{
+	all(`CREATE TABLE foods (
+		fdc_id        VARCHAR(32) PRIMARY KEY,
+		upc           VARCHAR(32),
+		description   TEXT NOT NULL,
+		ingredients   TEXT,
+		data_source   VARCHAR(16),
+		company       TEXT,
+		category_id   INTEGER,
+		category_code VARCHAR(16),
+		category      TEXT
+	)`),
+	all(`CREATE INDEX foods_description ON foods (description)`),
+	all(`CREATE INDEX foods_company ON foods (company)`),
+	all(`CREATE INDEX foods_data_source ON foods (data_source)`),
+	all(`CREATE TABLE servings (
+		fdc_id         VARCHAR(32) NOT NULL REFERENCES foods (fdc_id) ON DELETE CASCADE,
+		seq            INTEGER NOT NULL,
+		nutrient_basis VARCHAR(16),
+		serving_unit   TEXT,
+		serving_state  TEXT,
+		weight         REAL,
+		value          REAL,
+		data_points    INTEGER,
+		PRIMARY KEY (fdc_id, seq)
+	)`),
+	all(`CREATE TABLE nutrients (
+		nutrientno INTEGER PRIMARY KEY,
+		id         INTEGER,
+		tagname    VARCHAR(32),
+		name       TEXT NOT NULL,
+		unit       VARCHAR(16)
+	)`),
+	all(`CREATE TABLE nutrient_data (
+		fdc_id                 VARCHAR(32) NOT NULL REFERENCES foods (fdc_id) ON DELETE CASCADE,
+		nutrientno             INTEGER NOT NULL,
+		value                  REAL,
+		unit                   VARCHAR(16),
+		nutrient               TEXT,
+		source                 VARCHAR(16),
+		datapoints             INTEGER,
+		min                    REAL,
+		max                    REAL,
+		derivation_id          INTEGER,
+		derivation_code        VARCHAR(16),
+		derivation_description TEXT,
+		PRIMARY KEY (fdc_id, nutrientno)
+	)`),
+	all(`CREATE INDEX nutrient_data_nutrientno ON nutrient_data (nutrientno, value)`),
+	all(`ALTER TABLE foods ADD COLUMN type VARCHAR(16) NOT NULL DEFAULT 'FOOD'`),
+	// the default search type looks words up in a full-text index.  SQLite keeps
+	// a porter stemmed FTS4 table in step with foods by triggers, its docids are
+	// the rowids of foods.
+	{
+		"postgres": {
+			`CREATE INDEX foods_fts ON foods USING GIN (to_tsvector('english', ` + allColumns + `))`,
+			`CREATE INDEX foods_fts_description ON foods USING GIN (to_tsvector('english', description))`,
+		},
+		"sqlite3": {
+			`CREATE VIRTUAL TABLE foods_fts USING fts4(` + ftsColumns + `, tokenize=porter)`,
+			`INSERT INTO foods_fts (docid, ` + ftsColumns + `) SELECT rowid, ` + ftsColumns + ` FROM foods`,
+			`CREATE TRIGGER foods_fts_insert AFTER INSERT ON foods BEGIN
+				INSERT INTO foods_fts (docid, ` + ftsColumns + `) VALUES (new.rowid, new.description, new.company, new.ingredients, new.upc, new.category);
+			END`,
+			`CREATE TRIGGER foods_fts_delete AFTER DELETE ON foods BEGIN
+				DELETE FROM foods_fts WHERE docid = old.rowid;
+			END`,
+			`CREATE TRIGGER foods_fts_update AFTER UPDATE ON foods BEGIN
+				DELETE FROM foods_fts WHERE docid = old.rowid;
+				INSERT INTO foods_fts (docid, ` + ftsColumns + `) VALUES (new.rowid, new.description, new.company, new.ingredients, new.upc, new.category);
+			END`,
+		},
+	},
+	// VACUUM may renumber the rowids of foods so the full-text docids are
+	// instead the INTEGER PRIMARY KEY of foods_docids, which it keeps
+	{
+		"sqlite3": {
+			`DROP TRIGGER foods_fts_insert`,
+			`DROP TRIGGER foods_fts_delete`,
+			`DROP TRIGGER foods_fts_update`,
+			`DELETE FROM foods_fts`,
+			`CREATE TABLE foods_docids (
+				docid  INTEGER PRIMARY KEY,
+				fdc_id VARCHAR(32) NOT NULL UNIQUE
+			)`,
+			`INSERT INTO foods_docids (fdc_id) SELECT fdc_id FROM foods`,
+			`INSERT INTO foods_fts (docid, ` + ftsColumns + `) SELECT m.docid, f.description, f.company, f.ingredients, f.upc, f.category FROM foods f JOIN foods_docids m ON m.fdc_id = f.fdc_id`,
+			`CREATE TRIGGER foods_fts_insert AFTER INSERT ON foods BEGIN
+				INSERT INTO foods_docids (fdc_id) VALUES (new.fdc_id);
+				INSERT INTO foods_fts (docid, ` + ftsColumns + `) VALUES ((SELECT docid FROM foods_docids WHERE fdc_id = new.fdc_id), new.description, new.company, new.ingredients, new.upc, new.category);
+			END`,
+			`CREATE TRIGGER foods_fts_delete AFTER DELETE ON foods BEGIN
+				DELETE FROM foods_fts WHERE docid = (SELECT docid FROM foods_docids WHERE fdc_id = old.fdc_id);
+				DELETE FROM foods_docids WHERE fdc_id = old.fdc_id;
+			END`,
+			`CREATE TRIGGER foods_fts_update AFTER UPDATE ON foods BEGIN
+				DELETE FROM foods_fts WHERE docid = (SELECT docid FROM foods_docids WHERE fdc_id = old.fdc_id);
+				UPDATE foods_docids SET fdc_id = new.fdc_id WHERE fdc_id = old.fdc_id;
+				INSERT INTO foods_fts (docid, ` + ftsColumns + `) VALUES ((SELECT docid FROM foods_docids WHERE fdc_id = new.fdc_id), new.description, new.company, new.ingredients, new.upc, new.category);
+			END`,
+		},
+	},
+	// browsing and searching order foods by idKey
+	all(`CREATE INDEX foods_id_order ON foods ((SUBSTR(fdc_id, 1, 1) = 'C'), LENGTH(fdc_id), fdc_id)`),
+}
+
+// ftsColumns are the columns of foods copied into the SQLite full-text index
+const ftsColumns = "description, company, ingredients, upc, category"
+
+// Migrate creates or upgrades the database schema
+func (d *Datastore) Migrate() error {
+	var applied int
+	if _, err := d.DB.Exec(`CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`); err != nil {
+		return err
+	}
+	if err := d.DB.QueryRow(`SELECT COUNT(*) FROM schema_version`).Scan(&applied); err != nil {
+		return err
+	}
+	if applied == 0 {
+		if _, err := d.DB.Exec(`INSERT INTO schema_version (version) VALUES (0)`); err != nil {
+			return err
+		}
+	}
+	if err := d.DB.QueryRow(`SELECT version FROM schema_version`).Scan(&applied); err != nil {
+		return err
+	}
+	for i := applied; i < len(migrations); i++ {
+		tx, err := d.DB.Begin()
+		if err != nil {
+			return err
+		}
+		stmts, ok := migrations[i][d.Driver]
+		if !ok {
+			stmts = migrations[i][""]
+		}
+		for _, stmt := range stmts {
+			if _, err = tx.Exec(stmt); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			_, err = tx.Exec(`UPDATE schema_version SET version = `+placeholder(1), i+1)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+	}
+	if d.Driver == "postgres" {
+		// FUZZY and MATCH searches compare words with levenshtein_less_equal
+		if _, err := d.DB.Exec(`CREATE EXTENSION IF NOT EXISTS fuzzystrmatch`); err != nil {
+			return fmt.Errorf("cannot create the fuzzystrmatch extension: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,651 @@
+// Package sqldb implements datastore.Datastore on a relational database.
+// PostgreSQL (driver "postgres") and SQLite (driver "sqlite3") are supported.
+// All queries are parameterized.
+package sqldb
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	fdc "github.com/littlebunch/fdc-api/model"
+	"github.com/littlebunch/fdc-graphql/datastore"
+	"github.com/littlebunch/fdc-graphql/datastore/fdcload"
+	// register the postgres driver
+	_ "github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteDriver is SQLite with foreign keys enabled and REGEXP and FDC_WORD
+// functions added
+const sqliteDriver = "sqlite3_fdc"
+
+func init() {
+	sql.Register(sqliteDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			if _, err := conn.Exec("PRAGMA foreign_keys = ON", nil); err != nil {
+				return err
+			}
+			if err := conn.RegisterFunc("regexp", func(re, s string) (bool, error) {
+				return regexp.MatchString(re, s)
+			}, true); err != nil {
+				return err
+			}
+			// FDC_WORD reports whether any word of a value matches a term of a FUZZY, PREFIX or MATCH search
+			return conn.RegisterFunc("fdc_word", func(s, searchType, term string, edits int) bool {
+				for _, w := range datastore.Tokens(s) {
+					if datastore.MatchWord(searchType, term, w, edits) {
+						return true
+					}
+				}
+				return false
+			}, true)
+		},
+	})
+}
+
+// sortColumns maps the browse sort fields onto columns
+var sortColumns = map[string]string{
+	"fdcId":           "fdc_id",
+	"foodDescription": "description",
+	"company":         "company",
+}
+
+// searchColumns maps the search fields onto columns
+var searchColumns = map[string]string{
+	"foodDescription":       "description",
+	"company":               "company",
+	"ingredients":           "ingredients",
+	"upc":                   "upc",
+	"foodGroup.description": "category",
+}
+
+// allColumns is searched when a SearchRequest does not name a field
+const allColumns = "COALESCE(description,'') || ' ' || COALESCE(company,'') || ' ' || COALESCE(ingredients,'') || ' ' || COALESCE(upc,'') || ' ' || COALESCE(category,'')"
+
+// idKey orders foods by fdcId as numbers, FDC's before custom C ids, as
+// memory.lessID does.  Without leading zeros a number is smaller the shorter it
+// is.  foods_id_order indexes it.
+var idKey = []string{"SUBSTR(fdc_id, 1, 1) = 'C'", "LENGTH(fdc_id)", "fdc_id"}
+
+// idOrder returns the ORDER BY terms of idKey in an order
+func idOrder(order string) string {
+	terms := make([]string, len(idKey))
+	for i, k := range idKey {
+		terms[i] = k + " " + order
+	}
+	return strings.Join(terms, ", ")
+}
+
+// idValues adds the values of idKey for an fdcId returning their placeholders
+func idValues(a *args, fdcid string) string {
+	return a.add(datastore.IsCustom(fdcid)) + ", " + a.add(len(fdcid)) + ", " + a.add(fdcid)
+}
+
+const foodColumns = "fdc_id, upc, description, ingredients, data_source, company, category_id, category_code, category, type"
+
+// Datastore is a datastore.Datastore backed by a SQL database
+type Datastore struct {
+	DB     *sql.DB
+	Driver string
+}
+
+// Open connects to a database.  driver is either postgres or sqlite3.
+func Open(driver string, dsn string) (*Datastore, error) {
+	name := driver
+	switch driver {
+	case "postgres":
+	case "sqlite3":
+		name = sqliteDriver
+	default:
+		return nil, fmt.Errorf("unsupported sql driver %s", driver)
+	}
+	db, err := sql.Open(name, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Datastore{DB: db, Driver: driver}, nil
+}
+
+// Close closes the database
+func (d *Datastore) Close() error {
+	return d.DB.Close()
+}
+
+// Get loads a food by fdcId.  f must be a *fdc.Food.
+func (d *Datastore) Get(id string, f interface{}) error {
+	food, ok := f.(*fdc.Food)
+	if !ok {
+		return fmt.Errorf("cannot get a food into %T", f)
+	}
+	foods, err := d.foods("SELECT "+foodColumns+" FROM foods WHERE fdc_id = "+placeholder(1), id)
+	if err != nil {
+		return err
+	}
+	if len(foods) == 0 {
+		return fmt.Errorf("food %s not found", id)
+	}
+	*food = foods[0].(fdc.Food)
+	return nil
+}
+
+// Browse returns a page of foods described by a BrowseRequest
+func (d *Datastore) Browse(br datastore.BrowseRequest) ([]interface{}, error) {
+	var a args
+	col, ok := sortColumns[br.Sort]
+	if !ok {
+		return nil, fmt.Errorf("cannot sort on %s", br.Sort)
+	}
+	// SQLite takes a negative LIMIT as no limit at all
+	if br.Max < 1 || br.Offset < 0 {
+		return nil, fmt.Errorf("invalid page of %d foods at %d", br.Max, br.Offset)
+	}
+	if br.After != nil && br.NutrientSort != nil {
+		return nil, datastore.ErrAfterNutrientSort
+	}
+	order, cmp := "ASC", ">"
+	if strings.ToUpper(br.Order) == "DESC" {
+		order, cmp = "DESC", "<"
+	}
+	where := browseWhere(&a, br)
+	key := strings.Join(idKey, ", ")
+	if br.After != nil {
+		if col == "fdc_id" {
+			where = append(where, fmt.Sprintf("(%s) %s (%s)", key, cmp, idValues(&a, br.After.FdcID)))
+		} else {
+			v := a.add(br.After.Value)
+			where = append(where, fmt.Sprintf("(%s, %s) %s (%s, %s)", col, key, cmp, v, idValues(&a, br.After.FdcID)))
+		}
+	}
+	q := "SELECT " + foodColumns + " FROM foods"
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	switch {
+	case br.NutrientSort != nil:
+		v := nutrientValue(&a, *br.NutrientSort)
+		q += fmt.Sprintf(" ORDER BY %s IS NULL, %s %s,", v, nutrientValue(&a, *br.NutrientSort), order)
+	case col != "fdc_id":
+		q += fmt.Sprintf(" ORDER BY %s %s,", col, order)
+	default:
+		q += " ORDER BY"
+	}
+	q += fmt.Sprintf(" %s LIMIT %s OFFSET %s", idOrder(order), a.add(br.Max), a.add(br.Offset))
+	return d.foods(q, a...)
+}
+
+// nutrientValue returns a subquery selecting a food's value for a NutrientSort
+func nutrientValue(a *args, ns datastore.NutrientSort) string {
+	if ns.PerServing {
+		return fmt.Sprintf("(SELECT nd.value * s.weight / 100 FROM nutrient_data nd JOIN servings s ON s.fdc_id = nd.fdc_id WHERE nd.fdc_id = foods.fdc_id AND nd.nutrientno = %s AND s.weight > 0 ORDER BY s.seq LIMIT 1)", a.add(ns.Nutrientno))
+	}
+	return fmt.Sprintf("(SELECT nd.value FROM nutrient_data nd WHERE nd.fdc_id = foods.fdc_id AND nd.nutrientno = %s)", a.add(ns.Nutrientno))
+}
+
+// BrowseCount returns the number of foods matching a BrowseRequest
+func (d *Datastore) BrowseCount(br datastore.BrowseRequest) (int, error) {
+	var (
+		a     args
+		count int
+	)
+	q := "SELECT COUNT(*) FROM foods"
+	if where := browseWhere(&a, br); len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	err := d.DB.QueryRow(q, a...).Scan(&count)
+	return count, err
+}
+
+// browseWhere returns the conditions selecting the foods in a BrowseRequest
+func browseWhere(a *args, br datastore.BrowseRequest) []string {
+	var where []string
+	if len(br.FdcIDs) > 0 {
+		where = append(where, "fdc_id IN ("+a.list(br.FdcIDs)+")")
+	}
+	if br.Source != "" {
+		where = append(where, "data_source = "+a.add(br.Source))
+	}
+	for _, nf := range br.Nutrients {
+		if _, ok := datastore.Ops[nf.Op]; ok {
+			where = append(where, fmt.Sprintf("EXISTS (SELECT 1 FROM nutrient_data nd WHERE nd.fdc_id = foods.fdc_id AND nd.nutrientno = %s AND nd.value %s %s)", a.add(nf.Nutrientno), nf.Op, a.add(nf.Value)))
+		}
+	}
+	return where
+}
+
+// Search runs a SearchRequest putting a page of hits into foods and returning the total hit count
+func (d *Datastore) Search(sr datastore.SearchRequest, foods *[]interface{}) (int, error) {
+	var (
+		a     args
+		count int
+	)
+	if sr.Max < 1 || sr.Page < 0 {
+		return 0, fmt.Errorf("invalid page of %d hits at %d", sr.Max, sr.Page)
+	}
+	where, err := d.searchWhere(&a, sr)
+	if err != nil {
+		return 0, err
+	}
+	if err = d.DB.QueryRow("SELECT COUNT(*) FROM foods WHERE "+where, a...).Scan(&count); err != nil {
+		return 0, err
+	}
+	q := "SELECT " + foodColumns + " FROM foods WHERE " + where + " ORDER BY " + idOrder("ASC") + " LIMIT " + a.add(sr.Max) + " OFFSET " + a.add(sr.Page)
+	rs, err := d.foods(q, a...)
+	if err != nil {
+		return 0, err
+	}
+	for _, r := range rs {
+		f := r.(fdc.Food)
+		*foods = append(*foods, map[string]interface{}{
+			"fdcId":           f.FdcID,
+			"upc":             f.Upc,
+			"foodDescription": f.Description,
+			"ingredients":     f.Ingredients,
+			"dataSource":      f.Source,
+			"company":         f.Manufacturer,
+			"category":        category(f),
+		})
+	}
+	return count, nil
+}
+
+// searchWhere builds the where clause of a SearchRequest on the column of its field
+func (d *Datastore) searchWhere(a *args, sr datastore.SearchRequest) (string, error) {
+	col := allColumns
+	if sr.SearchField != "" {
+		var ok bool
+		if col, ok = searchColumns[strings.TrimSuffix(sr.SearchField, "_kw")]; !ok {
+			return "", fmt.Errorf("cannot search on %s", sr.SearchField)
+		}
+	}
+	return d.match(a, col, sr)
+}
+
+// Facets counts the hits of a SearchRequest grouped by column values and in
+// nutrient value ranges
+func (d *Datastore) Facets(sr datastore.SearchRequest, fr datastore.FacetRequest) (datastore.Facets, error) {
+	var a args
+	fs := datastore.Facets{Nutrients: []datastore.NutrientFacet{}}
+	where, err := d.searchWhere(&a, sr)
+	if err != nil {
+		return fs, err
+	}
+	for _, t := range []struct {
+		col    string
+		counts *[]datastore.FacetCount
+	}{{"data_source", &fs.DataSource}, {"category", &fs.Category}, {"company", &fs.Company}} {
+		if *t.counts, err = d.facetCounts(append(args(nil), a...), where, t.col, fr.Size); err != nil {
+			return fs, err
+		}
+	}
+	for _, nr := range fr.Nutrients {
+		var (
+			sums []string
+			qa   = append(args(nil), a...)
+		)
+		// SQLite numbers parameters in the order they appear so the hits come first
+		q := "WITH hits AS (SELECT nd.value FROM nutrient_data nd WHERE nd.fdc_id IN (SELECT fdc_id FROM foods WHERE " + where + ") AND nd.nutrientno = " + qa.add(nr.Nutrientno) + ") SELECT "
+		for _, r := range nr.Ranges {
+			cond := "1 = 1"
+			if r.Min != nil {
+				cond += " AND value >= " + qa.add(*r.Min)
+			}
+			if r.Max != nil {
+				cond += " AND value < " + qa.add(*r.Max)
+			}
+			sums = append(sums, "SUM(CASE WHEN "+cond+" THEN 1 ELSE 0 END)")
+		}
+		q += strings.Join(sums, ", ") + " FROM hits"
+		counts := make([]sql.NullInt64, len(nr.Ranges))
+		dest := make([]interface{}, len(counts))
+		for i := range counts {
+			dest[i] = &counts[i]
+		}
+		if err = d.DB.QueryRow(q, qa...).Scan(dest...); err != nil {
+			return fs, err
+		}
+		nf := datastore.NutrientFacet{Nutrientno: nr.Nutrientno, Ranges: []datastore.RangeCount{}}
+		for i, r := range nr.Ranges {
+			nf.Ranges = append(nf.Ranges, datastore.RangeCount{Min: r.Min, Max: r.Max, Count: int(counts[i].Int64)})
+		}
+		fs.Nutrients = append(fs.Nutrients, nf)
+	}
+	return fs, nil
+}
+
+// facetCounts returns the most common values of a column among the foods
+// passing a where clause
+func (d *Datastore) facetCounts(a args, where string, col string, size int) ([]datastore.FacetCount, error) {
+	counts := []datastore.FacetCount{}
+	rows, err := d.DB.Query("SELECT "+col+", COUNT(*) FROM foods WHERE ("+where+") AND "+col+" <> '' GROUP BY "+col+" ORDER BY COUNT(*) DESC, "+col+" LIMIT "+a.add(size), a...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c datastore.FacetCount
+		if err = rows.Scan(&c.Value, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// match builds a where clause testing col against the terms of a SearchRequest
+func (d *Datastore) match(a *args, col string, sr datastore.SearchRequest) (string, error) {
+	terms := strings.ToLower(sr.Query)
+	switch sr.SearchType {
+	case datastore.FUZZY, datastore.PREFIX, datastore.MATCH:
+		return d.words(a, col, sr)
+	case fdc.PHRASE:
+		return "LOWER(" + col + ") LIKE " + a.add("%"+escapeLike(terms)+"%") + " ESCAPE '\\'", nil
+	case fdc.WILDCARD:
+		p := strings.NewReplacer("*", "%", "?", "_").Replace(escapeLike(terms))
+		return "LOWER(" + col + ") LIKE " + a.add("%"+p+"%") + " ESCAPE '\\'", nil
+	case fdc.REGEX:
+		if _, err := regexp.Compile(sr.Query); err != nil {
+			return "", err
+		}
+		if d.Driver == "postgres" {
+			return col + " ~ " + a.add(sr.Query), nil
+		}
+		return col + " REGEXP " + a.add(sr.Query), nil
+	}
+	return d.fullText(a, col, sr)
+}
+
+// fullText builds the condition of the default search type, every term
+// appearing in the full-text index of the column.  Terms are stemmed so
+// "soups" finds soup.
+func (d *Datastore) fullText(a *args, col string, sr datastore.SearchRequest) (string, error) {
+	terms := sr.Terms()
+	if len(terms) == 0 {
+		return "", fmt.Errorf("no search terms")
+	}
+	if d.Driver == "postgres" {
+		return "to_tsvector('english', " + col + ") @@ plainto_tsquery('english', " + a.add(strings.Join(terms, " ")) + ")", nil
+	}
+	// terms are lower case letters and digits so none is taken as an FTS operator
+	if col != allColumns {
+		for i, t := range terms {
+			terms[i] = col + ":" + t
+		}
+	}
+	return "fdc_id IN (SELECT fdc_id FROM foods_docids WHERE docid IN (SELECT docid FROM foods_fts WHERE foods_fts MATCH " + a.add(strings.Join(terms, " ")) + "))", nil
+}
+
+// words builds the condition of a FUZZY, PREFIX or MATCH search.  Postgres
+// splits the column into words and compares them using the fuzzystrmatch
+// extension while SQLite calls FDC_WORD.
+func (d *Datastore) words(a *args, col string, sr datastore.SearchRequest) (string, error) {
+	var where []string
+	for _, t := range sr.Terms() {
+		edits := sr.Edits(t)
+		if d.Driver != "postgres" {
+			where = append(where, "FDC_WORD(COALESCE("+col+",''), "+a.add(sr.SearchType)+", "+a.add(t)+", "+a.add(edits)+")")
+			continue
+		}
+		cond := "w = " + a.add(t)
+		switch {
+		case sr.SearchType == datastore.PREFIX:
+			cond = "w LIKE " + a.add(escapeLike(t)+"%") + " ESCAPE '\\'"
+		case edits > 0:
+			e := a.add(edits)
+			cond = "levenshtein_less_equal(w, " + a.add(t) + ", " + e + ") <= " + e
+		}
+		where = append(where, "EXISTS (SELECT 1 FROM regexp_split_to_table(LOWER("+col+"), '[^[:alnum:]]+') AS w WHERE "+cond+")")
+	}
+	if len(where) == 0 {
+		return "", fmt.Errorf("no search terms")
+	}
+	op := " AND "
+	if sr.Operator == datastore.OR {
+		op = " OR "
+	}
+	return "(" + strings.Join(where, op) + ")", nil
+}
+
+// GetDictionary returns the nutrient dictionary
+func (d *Datastore) GetDictionary(doctype string, offset int64, limit int64) ([]interface{}, error) {
+	var (
+		dt *fdc.DocType
+		rs []interface{}
+	)
+	if doctype != dt.ToString(fdc.NUT) {
+		return nil, fmt.Errorf("unsupported dictionary %s", doctype)
+	}
+	rows, err := d.DB.Query("SELECT nutrientno, id, tagname, name, unit FROM nutrients ORDER BY nutrientno LIMIT "+placeholder(1)+" OFFSET "+placeholder(2), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			n        fdc.Nutrient
+			id       sql.NullInt64
+			tag, unt sql.NullString
+		)
+		if err = rows.Scan(&n.Nutrientno, &id, &tag, &n.Name, &unt); err != nil {
+			return nil, err
+		}
+		n.ID, n.Tagname, n.Unit, n.Type = id.Int64, tag.String, unt.String, doctype
+		rs = append(rs, n)
+	}
+	return rs, rows.Err()
+}
+
+// NutrientData returns nutrient values for a list of foods ordered by fdcId and nutrient number
+func (d *Datastore) NutrientData(fdcids []string, nutids []int) ([]fdc.NutrientData, error) {
+	var (
+		a       args
+		dt      *fdc.DocType
+		nutdata []fdc.NutrientData
+	)
+	if len(fdcids) == 0 {
+		return nil, nil
+	}
+	q := "SELECT fdc_id, nutrientno, value, unit, nutrient, source, datapoints, min, max, derivation_id, derivation_code, derivation_description FROM nutrient_data WHERE fdc_id IN (" + a.list(fdcids) + ")"
+	if len(nutids) > 0 {
+		q += " AND nutrientno IN (" + a.list(nutids) + ")"
+	}
+	rows, err := d.DB.Query(q+" ORDER BY fdc_id, nutrientno", a...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			n                      fdc.NutrientData
+			value, min, max        sql.NullFloat64
+			unit, name, source     sql.NullString
+			points, dervID         sql.NullInt64
+			dervCode, dervDescript sql.NullString
+		)
+		if err = rows.Scan(&n.FdcID, &n.Nutrientno, &value, &unit, &name, &source, &points, &min, &max, &dervID, &dervCode, &dervDescript); err != nil {
+			return nil, err
+		}
+		n.Type = dt.ToString(fdc.NUTDATA)
+		n.Value, n.Min, n.Max = float32(value.Float64), float32(min.Float64), float32(max.Float64)
+		n.Unit, n.Nutrient, n.Source = unit.String, name.String, source.String
+		n.Datapoints = int(points.Int64)
+		if dervCode.Valid {
+			n.Derivation = &fdc.Derivation{ID: int32(dervID.Int64), Code: dervCode.String, Description: dervDescript.String, Type: dt.ToString(fdc.DERV)}
+		}
+		nutdata = append(nutdata, n)
+	}
+	return nutdata, rows.Err()
+}
+
+// PutFood creates or replaces a food with its servings and nutrient values
+func (d *Datastore) PutFood(f fdc.Food, nd []fdc.NutrientData) error {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return err
+	}
+	if err = (&txStore{tx: tx}).PutFood(f, nd); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteFood removes a food.  Its servings and nutrient values are removed by
+// the foreign keys.
+func (d *Datastore) DeleteFood(fdcid string) error {
+	rs, err := d.DB.Exec("DELETE FROM foods WHERE fdc_id = "+placeholder(1), fdcid)
+	if err != nil {
+		return err
+	}
+	if n, err := rs.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("food %s not found", fdcid)
+	}
+	return nil
+}
+
+// Load reads FDC JSON files or CSV download directories into the database in a single transaction
+func (d *Datastore) Load(paths ...string) error {
+	_, err := d.LoadFoods(paths...)
+	return err
+}
+
+// LoadFoods loads like Load returning the fdcIds of the foods loaded
+func (d *Datastore) LoadFoods(paths ...string) ([]string, error) {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	rec := &fdcload.Recorder{Store: &txStore{tx: tx}}
+	if err = fdcload.Load(rec, paths...); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return rec.FdcIDs, nil
+}
+
+// foods runs a query on the foods table and returns fdc.Food values with their servings
+func (d *Datastore) foods(q string, params ...interface{}) ([]interface{}, error) {
+	var (
+		dt    *fdc.DocType
+		foods []fdc.Food
+		ids   []string
+		rs    []interface{}
+	)
+	rows, err := d.DB.Query(q, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			f                                  fdc.Food
+			upc, ingred, source, company, code sql.NullString
+			catID                              sql.NullInt64
+			cat                                sql.NullString
+		)
+		if err = rows.Scan(&f.FdcID, &upc, &f.Description, &ingred, &source, &company, &catID, &code, &cat, &f.Type); err != nil {
+			return nil, err
+		}
+		f.Upc, f.Ingredients, f.Source, f.Manufacturer = upc.String, ingred.String, source.String, company.String
+		if cat.Valid {
+			f.Group = &fdc.FoodGroup{ID: int32(catID.Int64), Code: code.String, Description: cat.String, Type: dt.ToString(fdc.FGGPC)}
+		}
+		foods = append(foods, f)
+		ids = append(ids, f.FdcID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	servings, err := d.servings(ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range foods {
+		f.Servings = servings[f.FdcID]
+		rs = append(rs, f)
+	}
+	return rs, nil
+}
+
+// servings returns the servings for a list of foods keyed by fdcId
+func (d *Datastore) servings(fdcids []string) (map[string][]fdc.Serving, error) {
+	var a args
+	s := make(map[string][]fdc.Serving)
+	if len(fdcids) == 0 {
+		return s, nil
+	}
+	rows, err := d.DB.Query("SELECT fdc_id, nutrient_basis, serving_unit, serving_state, weight, value, data_points FROM servings WHERE fdc_id IN ("+a.list(fdcids)+") ORDER BY fdc_id, seq", a...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id                 string
+			sv                 fdc.Serving
+			basis, unit, state sql.NullString
+			weight, value      sql.NullFloat64
+			points             sql.NullInt64
+		)
+		if err = rows.Scan(&id, &basis, &unit, &state, &weight, &value, &points); err != nil {
+			return nil, err
+		}
+		sv.Nutrientbasis, sv.Description, sv.Servingstate = basis.String, unit.String, state.String
+		sv.Weight, sv.Servingamount, sv.Datapoints = float32(weight.Float64), float32(value.Float64), int32(points.Int64)
+		s[id] = append(s[id], sv)
+	}
+	return s, rows.Err()
+}
+
+// category returns the description of a food's group if it has one
+func category(f fdc.Food) string {
+	if f.Group == nil {
+		return ""
+	}
+	return f.Group.Description
+}
+
+// escapeLike escapes the LIKE wildcards in a string
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// placeholder returns the nth positional parameter.  SQLite treats $n as a
+// named parameter which binds positionally when numbered in order.
+func placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// args accumulates query parameters handing out their placeholders
+type args []interface{}
+
+// add appends a parameter and returns its placeholder
+func (a *args) add(v interface{}) string {
+	*a = append(*a, v)
+	return placeholder(len(*a))
+}
+
+// list appends a slice of parameters and returns their comma separated placeholders
+func (a *args) list(v interface{}) string {
+	var ph []string
+	switch vs := v.(type) {
+	case []string:
+		for _, s := range vs {
+			ph = append(ph, a.add(s))
+		}
+	case []int:
+		for _, i := range vs {
+			ph = append(ph, a.add(i))
+		}
+	}
+	return strings.Join(ph, ",")
+}
@@ -0,0 +1,301 @@
+package sqldb
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	fdc "github.com/littlebunch/fdc-api/model"
+	"github.com/littlebunch/fdc-graphql/datastore"
+)
+
+// injections are user values which would change a query built by concatenation
+var injections = []string{
+	"'; DROP TABLE foods; --",
+	"x' OR '1'='1",
+	"x\") OR 1=1 --",
+	"%' UNION SELECT fdc_id FROM foods --",
+	"1; DELETE FROM foods",
+}
+
+// open returns a migrated SQLite database holding a few foods
+func open(t *testing.T) *Datastore {
+	t.Helper()
+	d, err := Open("sqlite3", filepath.Join(t.TempDir(), "fdc.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	if err = d.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []fdc.Food{
+		{FdcID: "1", Description: "Broccoli soup", Manufacturer: "Acme", Source: "GDSN", Ingredients: "broccoli, water, salt"},
+		{FdcID: "2", Description: "Tomato soups", Manufacturer: "Soup Co", Source: "GDSN", Ingredients: "tomatoes, water"},
+		{FdcID: "3", Description: "Broccoli, raw", Source: "SR", Group: &fdc.FoodGroup{ID: 11, Description: "Vegetables"}},
+	} {
+		if err = d.PutFood(f, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return d
+}
+
+// count returns the number of foods left in the database
+func count(t *testing.T, d *Datastore) int {
+	t.Helper()
+	n, err := d.BrowseCount(datastore.BrowseRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestMatchParameters(t *testing.T) {
+	d := &Datastore{Driver: "sqlite3"}
+	for _, st := range []string{"", fdc.PHRASE, fdc.WILDCARD, datastore.FUZZY, datastore.PREFIX, datastore.MATCH} {
+		for _, q := range injections {
+			var a args
+			sr := datastore.SearchRequest{SearchRequest: fdc.SearchRequest{Query: q, SearchType: st}, Fuzziness: datastore.AutoFuzziness}
+			where, err := d.match(&a, "description", sr)
+			if err != nil {
+				t.Errorf("%s %q: %v", st, q, err)
+				continue
+			}
+			if strings.Contains(where, q) {
+				t.Errorf("%s %q is in %s", st, q, where)
+			}
+			if len(a) == 0 {
+				t.Errorf("%s %q has no parameters", st, q)
+			}
+		}
+	}
+}
+
+func TestBrowseWhereParameters(t *testing.T) {
+	for _, q := range injections {
+		var a args
+		where := strings.Join(browseWhere(&a, datastore.BrowseRequest{FdcIDs: []string{q}, Source: q}), " AND ")
+		if strings.Contains(where, q) {
+			t.Errorf("%q is in %s", q, where)
+		}
+		if len(a) != 2 || a[0] != q || a[1] != q {
+			t.Errorf("%q parameters are %v", q, a)
+		}
+	}
+}
+
+func TestBrowse(t *testing.T) {
+	d := open(t)
+	for _, tc := range []struct {
+		name string
+		br   datastore.BrowseRequest
+		want string
+		err  bool
+	}{
+		{"fdcId", datastore.BrowseRequest{Sort: "fdcId", Max: 10}, "1,2,3", false},
+		{"description desc", datastore.BrowseRequest{Sort: "foodDescription", Order: "DESC", Max: 10}, "2,3,1", false},
+		{"page", datastore.BrowseRequest{Sort: "fdcId", Max: 1, Offset: 1}, "2", false},
+		{"source", datastore.BrowseRequest{Sort: "fdcId", Source: "SR", Max: 10}, "3", false},
+		{"injected source", datastore.BrowseRequest{Sort: "fdcId", Source: "SR' OR '1'='1", Max: 10}, "", false},
+		{"injected ids", datastore.BrowseRequest{Sort: "fdcId", FdcIDs: []string{"1) OR (1=1"}, Max: 10}, "", false},
+		{"injected sort", datastore.BrowseRequest{Sort: "fdc_id; DROP TABLE foods", Max: 10}, "", true},
+		{"injected order", datastore.BrowseRequest{Sort: "fdcId", Order: "DESC; DROP TABLE foods", Max: 10}, "1,2,3", false},
+		{"zero max", datastore.BrowseRequest{Sort: "fdcId"}, "", true},
+		{"negative max", datastore.BrowseRequest{Sort: "fdcId", Max: -1}, "", true},
+		{"negative offset", datastore.BrowseRequest{Sort: "fdcId", Max: 10, Offset: -1}, "", true},
+		{"after with nutrient sort", datastore.BrowseRequest{Sort: "fdcId", Max: 10, After: &datastore.Key{Value: "1", FdcID: "1"}, NutrientSort: &datastore.NutrientSort{Nutrientno: 208}}, "", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rs, err := d.Browse(tc.br)
+			if (err != nil) != tc.err {
+				t.Fatalf("error %v, want error %v", err, tc.err)
+			}
+			var ids []string
+			for _, f := range rs {
+				ids = append(ids, f.(fdc.Food).FdcID)
+			}
+			if got := strings.Join(ids, ","); got != tc.want {
+				t.Errorf("foods %s, want %s", got, tc.want)
+			}
+		})
+	}
+	if n := count(t, d); n != 3 {
+		t.Errorf("%d foods left, want 3", n)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	d := open(t)
+	for _, tc := range []struct {
+		name  string
+		sr    fdc.SearchRequest
+		total int
+		err   bool
+	}{
+		{"full text", fdc.SearchRequest{Query: "broccoli"}, 2, false},
+		{"stemmed", fdc.SearchRequest{Query: "soups"}, 2, false},
+		{"every term", fdc.SearchRequest{Query: "broccoli soups"}, 1, false},
+		{"field", fdc.SearchRequest{Query: "soup", SearchField: "foodDescription"}, 2, false},
+		{"category", fdc.SearchRequest{Query: "vegetables", SearchField: "foodGroup.description"}, 1, false},
+		{"phrase", fdc.SearchRequest{Query: "broccoli, water", SearchType: fdc.PHRASE, SearchField: "ingredients"}, 1, false},
+		{"wildcard", fdc.SearchRequest{Query: "tomat*", SearchType: fdc.WILDCARD}, 1, false},
+		{"regex", fdc.SearchRequest{Query: "^Broc", SearchType: fdc.REGEX, SearchField: "foodDescription_kw"}, 2, false},
+		{"bad regex", fdc.SearchRequest{Query: "(", SearchType: fdc.REGEX}, 0, true},
+		{"injected field", fdc.SearchRequest{Query: "soup", SearchField: "description; DROP TABLE foods"}, 0, true},
+		{"no terms", fdc.SearchRequest{Query: "--"}, 0, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var foods []interface{}
+			tc.sr.Max = 10
+			total, err := d.Search(datastore.SearchRequest{SearchRequest: tc.sr, Fuzziness: datastore.AutoFuzziness}, &foods)
+			if (err != nil) != tc.err {
+				t.Fatalf("error %v, want error %v", err, tc.err)
+			}
+			if total != tc.total || len(foods) != tc.total {
+				t.Errorf("total %d with %d hits, want %d", total, len(foods), tc.total)
+			}
+		})
+	}
+	for _, st := range []string{"", fdc.PHRASE, fdc.WILDCARD, datastore.FUZZY, datastore.PREFIX, datastore.MATCH} {
+		for _, q := range injections {
+			var foods []interface{}
+			sr := datastore.SearchRequest{SearchRequest: fdc.SearchRequest{Query: q, SearchType: st, Max: 10}, Fuzziness: 0}
+			if n, err := d.Search(sr, &foods); err != nil || n != 0 {
+				t.Errorf("%s search for %q found %d: %v", st, q, n, err)
+			}
+		}
+	}
+	if n := count(t, d); n != 3 {
+		t.Errorf("%d foods left, want 3", n)
+	}
+}
+
+func TestFullTextIndexFollowsFoods(t *testing.T) {
+	d := open(t)
+	search := func(q string) int {
+		var foods []interface{}
+		n, err := d.Search(datastore.SearchRequest{SearchRequest: fdc.SearchRequest{Query: q, Max: 10}}, &foods)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if err := d.PutFood(fdc.Food{FdcID: "2", Description: "Kale chips"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DeleteFood("1"); err != nil {
+		t.Fatal(err)
+	}
+	for q, want := range map[string]int{"kale": 1, "tomato": 0, "broccoli": 1} {
+		if n := search(q); n != want {
+			t.Errorf("%s found %d, want %d", q, n, want)
+		}
+	}
+}
+
+// hitIDs returns the fdcIds of the foods found by a search
+func hitIDs(t *testing.T, d *Datastore, sr datastore.SearchRequest) string {
+	t.Helper()
+	var foods []interface{}
+	if _, err := d.Search(sr, &foods); err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, f := range foods {
+		ids = append(ids, f.(map[string]interface{})["fdcId"].(string))
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestFullTextIndexAfterVacuum(t *testing.T) {
+	d := open(t)
+	// VACUUM may renumber the rowids of foods after deletes leave gaps in them
+	for _, id := range []string{"1", "2"} {
+		if err := d.DeleteFood(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.PutFood(fdc.Food{FdcID: "4", Description: "Kale chips"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.DB.Exec("VACUUM"); err != nil {
+		t.Fatal(err)
+	}
+	for q, want := range map[string]string{"kale": "4", "broccoli": "3", "soup": ""} {
+		if got := hitIDs(t, d, datastore.SearchRequest{SearchRequest: fdc.SearchRequest{Query: q, Max: 10}}); got != want {
+			t.Errorf("%s found %s, want %s", q, got, want)
+		}
+	}
+}
+
+func TestFullTextIndexMigration(t *testing.T) {
+	docids := -1
+	for i, m := range migrations {
+		if strings.Contains(strings.Join(m["sqlite3"], ";"), "foods_docids") {
+			docids = i
+			break
+		}
+	}
+	if docids < 0 {
+		t.Fatal("no foods_docids migration")
+	}
+	current := migrations
+	defer func() { migrations = current }()
+	// foods loaded before the full-text docids were moved to foods_docids
+	migrations = current[:docids]
+	d := open(t)
+	migrations = current
+	if err := d.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DeleteFood("1"); err != nil {
+		t.Fatal(err)
+	}
+	for q, want := range map[string]string{"broccoli": "3", "tomato": "2"} {
+		if got := hitIDs(t, d, datastore.SearchRequest{SearchRequest: fdc.SearchRequest{Query: q, Max: 10}}); got != want {
+			t.Errorf("%s found %s, want %s", q, got, want)
+		}
+	}
+}
+
+func TestIDOrder(t *testing.T) {
+	d := open(t)
+	for _, id := range []string{"999", "1000", "C10", "C2", "10"} {
+		if err := d.PutFood(fdc.Food{FdcID: id, Description: "Broccoli soup"}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	browse := func(br datastore.BrowseRequest) string {
+		t.Helper()
+		rs, err := d.Browse(br)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, f := range rs {
+			ids = append(ids, f.(fdc.Food).FdcID)
+		}
+		return strings.Join(ids, ",")
+	}
+	after := func(value, id string) *datastore.Key { return &datastore.Key{Value: value, FdcID: id} }
+	for _, tc := range []struct {
+		name string
+		br   datastore.BrowseRequest
+		want string
+	}{
+		{"fdcId", datastore.BrowseRequest{Sort: "fdcId", Max: 20}, "1,2,3,10,999,1000,C2,C10"},
+		{"fdcId desc", datastore.BrowseRequest{Sort: "fdcId", Order: "DESC", Max: 20}, "C10,C2,1000,999,10,3,2,1"},
+		{"after", datastore.BrowseRequest{Sort: "fdcId", Max: 3, After: after("10", "10")}, "999,1000,C2"},
+		{"after desc", datastore.BrowseRequest{Sort: "fdcId", Order: "DESC", Max: 3, After: after("C2", "C2")}, "1000,999,10"},
+		{"ties", datastore.BrowseRequest{Sort: "foodDescription", Max: 20}, "1,10,999,1000,C2,C10,3,2"},
+		{"ties after", datastore.BrowseRequest{Sort: "foodDescription", Max: 3, After: after("Broccoli soup", "999")}, "1000,C2,C10"},
+	} {
+		if got := browse(tc.br); got != tc.want {
+			t.Errorf("%s: %s, want %s", tc.name, got, tc.want)
+		}
+	}
+	if got := hitIDs(t, d, datastore.SearchRequest{SearchRequest: fdc.SearchRequest{Query: "soup", Max: 20}}); got != "1,2,10,999,1000,C2,C10" {
+		t.Errorf("search found %s", got)
+	}
+}
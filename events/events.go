@@ -0,0 +1,146 @@
+// Package events publishes changes to the foods in the datastore to
+// subscribers such as GraphQL subscriptions.  Changes come from mutations and
+// from ingest runs which report the foods they loaded.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	FoodChanged   = "CHANGED"
+	FoodDeleted   = "DELETED"
+	ReleaseLoaded = "RELEASE_LOADED"
+)
+
+// Buffer is the number of events a subscriber may fall behind by before it is
+// dropped
+const Buffer = 256
+
+// Event is a change to the datastore.  Food events have an FdcID and release
+// events the Release loaded and the number of Foods in it.  A release is one
+// event however many foods it changes so use Affects to tell whether it
+// changed a food.
+type Event struct {
+	Type    string    `json:"type"`
+	FdcID   string    `json:"fdcId,omitempty"`
+	Release string    `json:"release,omitempty"`
+	Foods   int       `json:"foods,omitempty"`
+	Time    time.Time `json:"time"`
+	fdcIDs  map[string]bool
+}
+
+// Release returns the event of a release changing the foods in fdcIDs
+func Release(release string, fdcIDs []string) Event {
+	ids := make(map[string]bool, len(fdcIDs))
+	for _, id := range fdcIDs {
+		ids[id] = true
+	}
+	return Event{Type: ReleaseLoaded, Release: release, Foods: len(ids), fdcIDs: ids}
+}
+
+// Affects reports whether an event changed or deleted a food
+func (e Event) Affects(fdcID string) bool {
+	if e.Type == ReleaseLoaded {
+		return e.fdcIDs[fdcID]
+	}
+	return e.FdcID == fdcID
+}
+
+// Subscription receives the events passing its filter on C.  C is closed when
+// the subscription is cancelled or falls more than Buffer events behind, in
+// which case Dropped reports true.
+type Subscription struct {
+	C       <-chan Event
+	c       chan Event
+	filter  func(Event) bool
+	dropped bool
+}
+
+// Dropped reports whether the subscription was closed for falling behind.  It
+// may only be called once C is closed.
+func (s *Subscription) Dropped() bool {
+	return s.dropped
+}
+
+// Bus delivers published events to its subscriptions and observers.  A nil
+// Bus discards events.
+type Bus struct {
+	mu        sync.Mutex
+	subs      map[*Subscription]bool
+	observers []func([]Event)
+}
+
+// NewBus returns a Bus without subscriptions
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]bool)}
+}
+
+// Subscribe returns a subscription to the events passing a filter.  A nil
+// filter passes every event.
+func (b *Bus) Subscribe(filter func(Event) bool) *Subscription {
+	c := make(chan Event, Buffer)
+	s := &Subscription{C: c, c: c, filter: filter}
+	b.mu.Lock()
+	b.subs[s] = true
+	b.mu.Unlock()
+	return s
+}
+
+// Observe calls fn with the events of each call to Publish before they are
+// sent to subscriptions.  Unlike subscriptions observers never fall behind, e.g.
+// a cache purged once for a whole release.
+func (b *Bus) Observe(fn func([]Event)) {
+	b.mu.Lock()
+	b.observers = append(b.observers, fn)
+	b.mu.Unlock()
+}
+
+// Cancel ends a subscription closing its channel
+func (b *Bus) Cancel(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[s] {
+		delete(b.subs, s)
+		close(s.c)
+	}
+}
+
+// Publish sends events to the observers and to every subscription whose
+// filter passes them.  Events without a time are given the current time.
+func (b *Bus) Publish(events ...Event) {
+	if b == nil || len(events) == 0 {
+		return
+	}
+	now := time.Now().UTC()
+	for i := range events {
+		if events[i].Time.IsZero() {
+			events[i].Time = now
+		}
+	}
+	b.mu.Lock()
+	observers := b.observers
+	b.mu.Unlock()
+	for _, fn := range observers {
+		fn(events)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, e := range events {
+		for s := range b.subs {
+			if s.filter != nil && !s.filter(e) {
+				continue
+			}
+			select {
+			case s.c <- e:
+			default:
+				// a subscriber this far behind must refetch so is told rather than missing events silently
+				s.dropped = true
+				delete(b.subs, s)
+				close(s.c)
+			}
+		}
+	}
+}
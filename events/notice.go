@@ -0,0 +1,102 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/littlebunch/fdc-graphql/auth"
+)
+
+// MaxNoticeSize is the largest notice read in bytes which allows for the ids
+// of a full release
+const MaxNoticeSize = 64 << 20
+
+// Notice reports changes made outside the server, e.g. by an ingest run.  Type
+// is CHANGED or DELETED for the foods in FdcIDs or RELEASE_LOADED for a
+// release whose foods all changed.
+type Notice struct {
+	Type    string   `json:"type"`
+	Release string   `json:"release,omitempty"`
+	FdcIDs  []string `json:"fdcIds"`
+}
+
+// Events returns the events to publish for a notice.  A release publishes a
+// single event for all of its foods as it may change hundreds of thousands.
+func (n Notice) Events() ([]Event, error) {
+	switch n.Type {
+	case FoodChanged, FoodDeleted:
+	case ReleaseLoaded:
+		if n.Release == "" {
+			return nil, fmt.Errorf("%s notices need a release", ReleaseLoaded)
+		}
+		return []Event{Release(n.Release, n.FdcIDs)}, nil
+	default:
+		return nil, fmt.Errorf("unknown notice type %q.  Use %s, %s or %s.", n.Type, FoodChanged, FoodDeleted, ReleaseLoaded)
+	}
+	events := make([]Event, 0, len(n.FdcIDs))
+	for _, id := range n.FdcIDs {
+		events = append(events, Event{Type: n.Type, FdcID: id})
+	}
+	return events, nil
+}
+
+// Handler publishes the events of a notice posted as JSON.  Only admins may
+// use it.
+func (b *Bus) Handler(c *gin.Context) {
+	if err := auth.Require(c.Request.Context(), auth.Admin); err != nil {
+		fail(c, http.StatusForbidden, err.Error())
+		return
+	}
+	var n Notice
+	if err := json.NewDecoder(http.MaxBytesReader(c.Writer, c.Request.Body, MaxNoticeSize)).Decode(&n); err != nil {
+		fail(c, http.StatusBadRequest, fmt.Sprintf("json decoding : %v", err))
+		return
+	}
+	events, err := n.Events()
+	if err != nil {
+		fail(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	b.Publish(events...)
+	c.JSON(http.StatusAccepted, gin.H{"published": len(events)})
+}
+
+// Notify posts a notice to the events route of a server authenticating with an
+// API key
+func Notify(url, key string, n Notice) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set("X-API-Key", key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("%s returned %s %s", url, resp.Status, e.Error)
+	}
+	return nil
+}
+
+func fail(c *gin.Context, status int, msg string) {
+	c.AbortWithStatusJSON(status, gin.H{
+		"error":  msg,
+		"status": status,
+	})
+}
@@ -0,0 +1,85 @@
+package events
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestNoticeEvents(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		notice Notice
+		want   []Event
+		err    bool
+	}{
+		{"changed", Notice{Type: FoodChanged, FdcIDs: []string{"1", "2"}}, []Event{{Type: FoodChanged, FdcID: "1"}, {Type: FoodChanged, FdcID: "2"}}, false},
+		{"deleted", Notice{Type: FoodDeleted, FdcIDs: []string{"1"}}, []Event{{Type: FoodDeleted, FdcID: "1"}}, false},
+		{"release", Notice{Type: ReleaseLoaded, Release: "SR 2026-10", FdcIDs: []string{"1", "2", "2"}}, []Event{{Type: ReleaseLoaded, Release: "SR 2026-10", Foods: 2}}, false},
+		{"release without name", Notice{Type: ReleaseLoaded, FdcIDs: []string{"1"}}, nil, true},
+		{"unknown type", Notice{Type: "TRUNCATED"}, nil, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			evs, err := tc.notice.Events()
+			if (err != nil) != tc.err {
+				t.Fatalf("error %v, want error %v", err, tc.err)
+			}
+			if len(evs) != len(tc.want) {
+				t.Fatalf("%d events, want %d", len(evs), len(tc.want))
+			}
+			for i, e := range evs {
+				w := tc.want[i]
+				if e.Type != w.Type || e.FdcID != w.FdcID || e.Release != w.Release || e.Foods != w.Foods {
+					t.Errorf("event %d is %+v, want %+v", i, e, w)
+				}
+			}
+		})
+	}
+}
+
+func TestAffects(t *testing.T) {
+	release := Release("SR 2026-10", []string{"1", "2"})
+	changed := Event{Type: FoodChanged, FdcID: "3"}
+	for _, tc := range []struct {
+		e    Event
+		id   string
+		want bool
+	}{
+		{release, "1", true},
+		{release, "2", true},
+		{release, "3", false},
+		{changed, "3", true},
+		{changed, "1", false},
+	} {
+		if got := tc.e.Affects(tc.id); got != tc.want {
+			t.Errorf("%s %s affects %s = %v, want %v", tc.e.Type, tc.e.FdcID, tc.id, got, tc.want)
+		}
+	}
+}
+
+func TestReleasePublishedOnce(t *testing.T) {
+	b := NewBus()
+	var observed []Event
+	b.Observe(func(evs []Event) { observed = append(observed, evs...) })
+	watch := b.Subscribe(func(e Event) bool { return e.Affects("2") })
+	other := b.Subscribe(func(e Event) bool { return e.Affects("C1") })
+	ids := make([]string, 10*Buffer)
+	for i := range ids {
+		ids[i] = strconv.Itoa(i)
+	}
+	evs, err := Notice{Type: ReleaseLoaded, Release: "SR 2026-10", FdcIDs: ids}.Events()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Publish(evs...)
+	if len(observed) != 1 {
+		t.Errorf("%d events observed, want 1", len(observed))
+	}
+	if e := <-watch.C; e.Type != ReleaseLoaded || watch.Dropped() {
+		t.Errorf("watcher got %+v dropped %v", e, watch.Dropped())
+	}
+	select {
+	case e := <-other.C:
+		t.Errorf("unaffected subscriber got %+v", e)
+	default:
+	}
+}
@@ -0,0 +1,419 @@
+// Package gqlhttp serves GraphQL over HTTP following the GraphQL over HTTP
+// specification.  Queries may be sent with GET as URL parameters or with POST as
+// JSON, application/graphql or form bodies.
+package gqlhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/littlebunch/fdc-graphql/auth"
+	"github.com/littlebunch/fdc-graphql/cache"
+	"github.com/littlebunch/fdc-graphql/complexity"
+	"github.com/littlebunch/fdc-graphql/persisted"
+	"github.com/littlebunch/fdc-graphql/resolvers"
+)
+
+// Media types of GraphQL requests and responses
+const (
+	JSON            = "application/json"
+	GraphQL         = "application/graphql"
+	GraphQLResponse = "application/graphql-response+json"
+	Form            = "application/x-www-form-urlencoded"
+)
+
+// MaxBodySize is the largest request body read in bytes
+const MaxBodySize = 1 << 20
+
+// Request is a GraphQL request
+type Request struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+	Extensions    map[string]interface{} `json:"extensions"`
+}
+
+// BatchConfig are the settings in the batch section of the config file.
+// MaxSize is the most operations a batch may hold and Concurrency the most run
+// at once.
+type BatchConfig struct {
+	MaxSize     int `yaml:"maxSize"`
+	Concurrency int `yaml:"concurrency"`
+}
+
+// Handler executes GraphQL requests against a schema.  Charge, if set, is
+// called with the number of operations in a batch beyond the first so they can
+// be counted against rate limits.  It writes the response and returns false if
+// the batch is refused.  Persisted, if set, resolves persisted query hashes
+// and Cache, if set, keeps the results of queries.
+type Handler struct {
+	Schema    graphql.Schema
+	Limits    complexity.Config
+	Batch     BatchConfig
+	Charge    func(c *gin.Context, n int) bool
+	Persisted *persisted.Cache
+	Cache     *cache.Cache
+}
+
+// ServeGin reads a request from a GET or POST, executes it and writes the result.
+// Malformed requests and documents which fail to parse or validate get 400 Bad
+// Request and mutations sent with GET get 405 Method Not Allowed.  A batch gets
+// an array of results in the order of its operations.  Cacheable results get
+// Cache-Control and X-Cache headers.
+func (h *Handler) ServeGin(c *gin.Context) {
+	contentType := responseType(c.GetHeader("Accept"))
+	reqs, batch, status, err := ReadRequests(c.Request)
+	switch {
+	case err != nil:
+	case batch && len(reqs) == 0:
+		status, err = http.StatusBadRequest, errors.New("batch has no operations")
+	case batch && h.Batch.MaxSize > 0 && len(reqs) > h.Batch.MaxSize:
+		status, err = http.StatusBadRequest, fmt.Errorf("batch has %d operations.  At most %d are allowed.", len(reqs), h.Batch.MaxSize)
+	}
+	if err != nil {
+		Write(c, contentType, status, &graphql.Result{Errors: []gqlerrors.FormattedError{gqlerrors.FormatError(err)}})
+		return
+	}
+	if !batch {
+		result, status, cached := h.execute(c.Request.Context(), reqs[0], c.Request.Method)
+		if status == http.StatusMethodNotAllowed {
+			c.Header("Allow", http.MethodPost)
+		}
+		if cached != "" {
+			c.Header("X-Cache", cached)
+			c.Header("Cache-Control", h.cacheControl(c))
+		}
+		Write(c, contentType, status, result)
+		return
+	}
+	if h.Charge != nil && !h.Charge(c, len(reqs)-1) {
+		return
+	}
+	results := h.ExecuteBatch(c.Request.Context(), reqs)
+	bodies := make([]interface{}, len(results))
+	for i, r := range results {
+		bodies[i] = body(r)
+	}
+	c.Header("Content-Type", contentType+"; charset=utf-8")
+	c.JSON(http.StatusOK, bodies)
+}
+
+// ExecuteBatch runs the operations of a batch running at most
+// Batch.Concurrency at once
+func (h *Handler) ExecuteBatch(ctx context.Context, reqs []Request) []*graphql.Result {
+	n := h.Batch.Concurrency
+	if n <= 0 {
+		n = 1
+	}
+	var (
+		wg      sync.WaitGroup
+		sem     = make(chan struct{}, n)
+		results = make([]*graphql.Result, len(reqs))
+	)
+	for i := range reqs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i], _ = h.Execute(ctx, reqs[i], http.MethodPost)
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+// Write writes a result with a status as JSON of a media type.  Successful GETs
+// get an ETag and 304 Not Modified when the client already has the result.
+func Write(c *gin.Context, contentType string, status int, result *graphql.Result) {
+	b, err := json.Marshal(body(result))
+	if err != nil {
+		status, b = http.StatusInternalServerError, []byte(`{"errors":[{"message":"cannot encode the result"}]}`)
+	}
+	if c.Request.Method == http.MethodGet && status == http.StatusOK {
+		sum := sha256.Sum256(b)
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+		c.Header("ETag", etag)
+		if matchETag(c.GetHeader("If-None-Match"), etag) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+	}
+	c.Data(status, contentType+"; charset=utf-8", b)
+}
+
+// matchETag reports whether an If-None-Match header matches an entity tag
+func matchETag(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheControl is the Cache-Control header of a cacheable result.  Results for
+// authenticated callers may only be kept by their own clients.
+func (h *Handler) cacheControl(c *gin.Context) string {
+	scope := "public"
+	if id, ok := auth.FromContext(c.Request.Context()); ok && id.Name != auth.Anonymous {
+		scope = "private"
+	}
+	return fmt.Sprintf("%s, max-age=%d", scope, int(h.Cache.MaxAge().Seconds()))
+}
+
+// body is the JSON of a result.  Results of requests which failed before
+// execution have no data entry.
+func body(result *graphql.Result) interface{} {
+	if result.Data == nil && result.HasErrors() {
+		return gin.H{"errors": result.Errors}
+	}
+	return result
+}
+
+// responseType chooses the media type of a response from an Accept header.
+// application/graphql-response+json is only used when a client asks for it.
+func responseType(accept string) string {
+	for _, part := range strings.Split(accept, ",") {
+		if mt, _, err := mime.ParseMediaType(strings.TrimSpace(part)); err == nil && mt == GraphQLResponse {
+			return GraphQLResponse
+		}
+	}
+	return JSON
+}
+
+// ReadRequests reads GraphQL requests from the URL parameters of a GET or the
+// body of a POST.  A POST may send a JSON array of requests which is reported as
+// a batch.  It returns the status to respond with when the request is malformed.
+func ReadRequests(r *http.Request) ([]Request, bool, int, error) {
+	switch r.Method {
+	case http.MethodGet:
+		req, status, err := fromValues(r.URL.Query())
+		return []Request{req}, false, status, err
+	case http.MethodPost:
+	default:
+		return nil, false, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not supported", r.Method)
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, MaxBodySize))
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		return nil, false, http.StatusRequestEntityTooLarge, fmt.Errorf("request body is larger than %d bytes", MaxBodySize)
+	case err != nil:
+		return nil, false, http.StatusBadRequest, err
+	}
+	if b := bytes.TrimSpace(body); len(b) > 0 && b[0] == '[' {
+		var reqs []Request
+		if err = json.Unmarshal(b, &reqs); err != nil {
+			return nil, true, http.StatusBadRequest, fmt.Errorf("json decoding : %v", err)
+		}
+		return reqs, true, http.StatusOK, nil
+	}
+	req, status, err := fromBody(r, body)
+	return []Request{req}, false, status, err
+}
+
+// fromBody reads a request from the body of a POST in the format given by its
+// Content-Type.  A body without one is read as JSON.
+func fromBody(r *http.Request, body []byte) (Request, int, error) {
+	var (
+		req Request
+		err error
+	)
+	mt := JSON
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		if mt, _, err = mime.ParseMediaType(ct); err != nil {
+			return req, http.StatusUnsupportedMediaType, fmt.Errorf("invalid Content-Type %q", ct)
+		}
+	}
+	switch mt {
+	case GraphQL:
+		// the body is the document with any other parameters in the URL
+		req, status, err := fromValues(r.URL.Query())
+		req.Query = string(body)
+		return req, status, err
+	case Form:
+		// clients such as curl -d label JSON bodies as forms
+		if b := strings.TrimSpace(string(body)); !strings.HasPrefix(b, "{") {
+			v, err := url.ParseQuery(b)
+			if err != nil {
+				return req, http.StatusBadRequest, fmt.Errorf("form decoding : %v", err)
+			}
+			return fromValues(v)
+		}
+	case JSON:
+	default:
+		return req, http.StatusUnsupportedMediaType, fmt.Errorf("Content-Type %s is not supported.  Use %s or %s.", mt, JSON, GraphQL)
+	}
+	if err = json.Unmarshal(body, &req); err != nil {
+		return req, http.StatusBadRequest, fmt.Errorf("json decoding : %v", err)
+	}
+	return req, http.StatusOK, nil
+}
+
+// fromValues reads a request from URL or form parameters where variables and
+// extensions are JSON encoded
+func fromValues(v url.Values) (Request, int, error) {
+	req := Request{Query: v.Get("query"), OperationName: v.Get("operationName")}
+	for name, dst := range map[string]*map[string]interface{}{"variables": &req.Variables, "extensions": &req.Extensions} {
+		if s := v.Get(name); s != "" {
+			if err := json.Unmarshal([]byte(s), dst); err != nil {
+				return req, http.StatusBadRequest, fmt.Errorf("%s must be a JSON object: %v", name, err)
+			}
+		}
+	}
+	return req, http.StatusOK, nil
+}
+
+// Execute parses, validates and runs a request returning the result and the
+// status to respond with.  method is the HTTP method the request was sent with.
+// Subscriptions are refused as they need a WebSocket.
+func (h *Handler) Execute(ctx context.Context, req Request, method string) (*graphql.Result, int) {
+	result, status, _ := h.execute(ctx, req, method)
+	return result, status
+}
+
+// execute runs a request like Execute answering queries from the cache when it
+// can.  cached is HIT or MISS for cacheable results and empty otherwise.
+func (h *Handler) execute(ctx context.Context, req Request, method string) (result *graphql.Result, status int, cached string) {
+	doc, op, status, errs := h.Prepare(&req, method)
+	if errs != nil {
+		return &graphql.Result{Errors: errs}, status, ""
+	}
+	if op.Operation == ast.OperationTypeSubscription {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(errors.New("subscription operations must be sent over a WebSocket"))}, http.StatusBadRequest, ""
+	}
+	var (
+		key string
+		gen uint64
+	)
+	if h.Cache != nil && op.Operation == ast.OperationTypeQuery {
+		key = cache.Key(doc, req.OperationName, req.Variables)
+		if r, ok := h.Cache.Get(key); ok {
+			return r, http.StatusOK, "HIT"
+		}
+		gen = h.Cache.Generation()
+	}
+	result = graphql.Execute(graphql.ExecuteParams{
+		Schema:        h.Schema,
+		AST:           doc,
+		OperationName: req.OperationName,
+		Args:          req.Variables,
+		Context:       resolvers.NewContext(ctx),
+	})
+	// no data means the request failed before execution, e.g. a variable of the wrong type
+	if result.Data == nil && result.HasErrors() {
+		return result, http.StatusBadRequest, ""
+	}
+	// partial results are not kept as their errors may be passing, e.g. a datastore timeout
+	if key == "" || result.HasErrors() {
+		return result, http.StatusOK, ""
+	}
+	h.Cache.Set(key, gen, result)
+	return result, http.StatusOK, "MISS"
+}
+
+// Prepare resolves any persisted query of a request then parses, validates and
+// checks the complexity of its document returning the operation to run.  On
+// failure it returns the errors and the status to respond with.
+func (h *Handler) Prepare(req *Request, method string) (*ast.Document, *ast.OperationDefinition, int, []gqlerrors.FormattedError) {
+	fail := func(status int, errs ...gqlerrors.FormattedError) (*ast.Document, *ast.OperationDefinition, int, []gqlerrors.FormattedError) {
+		return nil, nil, status, errs
+	}
+	var pq persisted.Query
+	if h.Persisted != nil {
+		var err error
+		if pq, err = h.Persisted.Lookup(req.Query, req.Extensions); err != nil {
+			return fail(persistedStatus(err), persistedError(err))
+		}
+		req.Query = pq.Text
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		return fail(http.StatusBadRequest, gqlerrors.FormatError(errors.New("query is required")))
+	}
+	src := source.NewSource(&source.Source{Body: []byte(req.Query), Name: "GraphQL request"})
+	doc, err := parser.Parse(parser.ParseParams{Source: src})
+	if err != nil {
+		return fail(http.StatusBadRequest, gqlerrors.FormatErrors(err)...)
+	}
+	if vr := graphql.ValidateDocument(&h.Schema, doc, nil); !vr.IsValid {
+		return fail(http.StatusBadRequest, vr.Errors...)
+	}
+	if pq.Register {
+		h.Persisted.PutQuery(pq.Hash, pq.Text)
+	}
+	op, err := operation(doc, req.OperationName)
+	if err != nil {
+		return fail(http.StatusBadRequest, gqlerrors.FormatError(err))
+	}
+	if method == http.MethodGet && op.Operation != ast.OperationTypeQuery {
+		return fail(http.StatusMethodNotAllowed, gqlerrors.FormatError(fmt.Errorf("%s operations must be sent with POST", op.Operation)))
+	}
+	if _, err := h.Limits.Check(h.Schema, doc, req.OperationName, req.Variables); err != nil {
+		return fail(http.StatusBadRequest, gqlerrors.FormatError(err))
+	}
+	return doc, op, http.StatusOK, nil
+}
+
+// persistedStatus is the status of a persisted query error.  Unknown hashes
+// get 200 OK as clients read the error code to know to send the query.
+func persistedStatus(err error) int {
+	switch err {
+	case persisted.ErrNotFound:
+		return http.StatusOK
+	case persisted.ErrNotAllowed:
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+// persistedError formats a persisted query error with its code
+func persistedError(err error) gqlerrors.FormattedError {
+	fe := gqlerrors.FormatError(err)
+	var pe *persisted.Error
+	if errors.As(err, &pe) {
+		fe.Extensions = pe.Extensions()
+	}
+	return fe
+}
+
+// operation returns the operation of a document to execute
+func operation(doc *ast.Document, name string) (*ast.OperationDefinition, error) {
+	var ops []*ast.OperationDefinition
+	for _, d := range doc.Definitions {
+		if op, ok := d.(*ast.OperationDefinition); ok {
+			if name == "" || (op.Name != nil && op.Name.Value == name) {
+				ops = append(ops, op)
+			}
+		}
+	}
+	switch {
+	case len(ops) == 0 && name != "":
+		return nil, fmt.Errorf("unknown operation named %q", name)
+	case len(ops) == 0:
+		return nil, errors.New("must provide an operation")
+	case len(ops) > 1:
+		return nil, errors.New("must provide operation name if query contains multiple operations")
+	}
+	return ops[0], nil
+}
@@ -0,0 +1,252 @@
+// Package label builds FDA style Nutrition Facts panels for foods from their
+// servings and nutrient values.  Declared amounts and percent daily values are
+// rounded following 21 CFR 101.9.
+package label
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	fdc "github.com/littlebunch/fdc-api/model"
+	"github.com/littlebunch/fdc-graphql/dailyvalue"
+)
+
+// ErrServing is returned when a food has no serving to build a label for
+var ErrServing = errors.New("no serving with a weight")
+
+// energy is the nutrient number of calories
+const energy = 208
+
+// Label is a Nutrition Facts panel for one serving of a food
+type Label struct {
+	FdcID           string  `json:"fdcId"`
+	FoodDescription string  `json:"foodDescription"`
+	ServingIndex    int     `json:"servingIndex"`
+	ServingSize     string  `json:"servingSize"`
+	ServingWeight   float64 `json:"servingWeight"`
+	ServingUnit     string  `json:"servingUnit"`
+	Calories        *int    `json:"calories"`
+	Nutrients       []Line  `json:"nutrients"`
+	Vitamins        []Line  `json:"vitamins"`
+}
+
+// Line is a nutrient declared on a label.  Amount and PercentDailyValue are nil
+// when the food has no value for the nutrient.
+type Line struct {
+	Name              string   `json:"name"`
+	Nutrientno        uint     `json:"nutrientno"`
+	Value             *float64 `json:"value"`
+	Amount            *float64 `json:"amount"`
+	Unit              string   `json:"unit"`
+	Display           string   `json:"display"`
+	PercentDailyValue *int     `json:"percentDailyValue"`
+	Indent            int      `json:"indent"`
+	Bold              bool     `json:"bold"`
+}
+
+// declaration describes how a nutrient is declared on the label
+type declaration struct {
+	name       string
+	nutrientno uint
+	unit       string
+	round      func(v float64) (float64, bool)
+	dv         func(pct float64) int
+	indent     int
+	bold       bool
+}
+
+// panel are the nutrients in the main part of the label
+var panel = []declaration{
+	{name: "Total Fat", nutrientno: 204, unit: "g", round: fat, dv: macroDV, bold: true},
+	{name: "Saturated Fat", nutrientno: 606, unit: "g", round: fat, dv: macroDV, indent: 1},
+	{name: "Trans Fat", nutrientno: 605, unit: "g", round: fat, indent: 1},
+	{name: "Cholesterol", nutrientno: 601, unit: "mg", round: cholesterol, dv: macroDV, bold: true},
+	{name: "Sodium", nutrientno: 307, unit: "mg", round: sodium, dv: macroDV, bold: true},
+	{name: "Total Carbohydrate", nutrientno: 205, unit: "g", round: grams, dv: macroDV, bold: true},
+	{name: "Dietary Fiber", nutrientno: 291, unit: "g", round: grams, dv: macroDV, indent: 1},
+	{name: "Total Sugars", nutrientno: 269, unit: "g", round: grams, indent: 1},
+	{name: "Includes %s Added Sugars", nutrientno: 539, unit: "g", round: grams, dv: macroDV, indent: 2},
+	{name: "Protein", nutrientno: 203, unit: "g", round: grams, bold: true},
+}
+
+// vitamins are the vitamins and minerals declared at the foot of the label
+var vitamins = []declaration{
+	{name: "Vitamin D", nutrientno: 328, unit: "mcg", round: nearest(0.1), dv: microDV},
+	{name: "Calcium", nutrientno: 301, unit: "mg", round: nearest(10), dv: microDV},
+	{name: "Iron", nutrientno: 303, unit: "mg", round: nearest(0.1), dv: microDV},
+	{name: "Potassium", nutrientno: 306, unit: "mg", round: nearest(10), dv: microDV},
+}
+
+// New builds the label for one of a food's servings given the food's nutrient
+// values per 100g
+func New(f fdc.Food, nutdata []fdc.NutrientData, serving int) (*Label, error) {
+	if serving < 0 || serving >= len(f.Servings) || f.Servings[serving].Weight <= 0 {
+		return nil, fmt.Errorf("food %s serving %d: %w", f.FdcID, serving, ErrServing)
+	}
+	sv := f.Servings[serving]
+	l := &Label{
+		FdcID:           f.FdcID,
+		FoodDescription: f.Description,
+		ServingIndex:    serving,
+		ServingWeight:   float64(sv.Weight),
+		ServingUnit:     sv.Nutrientbasis,
+	}
+	if l.ServingUnit == "" {
+		l.ServingUnit = "g"
+	}
+	l.ServingSize = servingSize(sv, l.ServingUnit)
+	values := make(map[uint]float64)
+	for _, nd := range nutdata {
+		if nd.Nutrientno == energy && !strings.EqualFold(nd.Unit, "kcal") {
+			continue
+		}
+		v, _ := strconv.ParseFloat(strconv.FormatFloat(float64(nd.Value), 'g', -1, 32), 64)
+		values[nd.Nutrientno] = v * l.ServingWeight / 100
+	}
+	if v, ok := values[energy]; ok {
+		c := int(calories(v))
+		l.Calories = &c
+	}
+	dv, _ := dailyvalue.Lookup(dailyvalue.Default)
+	for _, d := range panel {
+		l.Nutrients = append(l.Nutrients, d.line(values, dv))
+	}
+	for _, d := range vitamins {
+		l.Vitamins = append(l.Vitamins, d.line(values, dv))
+	}
+	return l, nil
+}
+
+// line declares a nutrient's value
+func (d declaration) line(values map[uint]float64, dv dailyvalue.Profile) Line {
+	l := Line{Name: d.name, Nutrientno: d.nutrientno, Unit: d.unit, Indent: d.indent, Bold: d.bold}
+	v, ok := values[d.nutrientno]
+	if !ok {
+		l.Display = "-"
+		l.Name = strings.Replace(l.Name, "%s ", "", 1)
+		return l
+	}
+	a, less := d.round(v)
+	l.Value, l.Amount = &v, &a
+	l.Display = strconv.FormatFloat(a, 'f', -1, 64) + d.unit
+	if less {
+		l.Display = "<" + l.Display
+	}
+	if strings.Contains(l.Name, "%s") {
+		l.Name, l.Display = fmt.Sprintf(l.Name, l.Display), ""
+	}
+	if d.dv != nil {
+		if pct, ok := dv.Percent(d.nutrientno, v, ""); ok {
+			p := d.dv(pct)
+			l.PercentDailyValue = &p
+		}
+	}
+	return l
+}
+
+// servingSize describes a serving as its household measure and weight, e.g.
+// 1 cup chopped (91g)
+func servingSize(sv fdc.Serving, unit string) string {
+	w := strconv.FormatFloat(float64(sv.Weight), 'f', -1, 32) + unit
+	d := strings.TrimSpace(sv.Description)
+	switch {
+	case d == "":
+		return w
+	case sv.Servingamount > 0 && !unicode.IsDigit([]rune(d)[0]):
+		d = strconv.FormatFloat(float64(sv.Servingamount), 'f', -1, 32) + " " + d
+	}
+	return fmt.Sprintf("%s (%s)", d, w)
+}
+
+// round rounds v to a multiple of step
+func round(v, step float64) float64 {
+	if step >= 1 {
+		return math.Round(v/step) * step
+	}
+	inv := math.Round(1 / step)
+	return math.Round(v*inv) / inv
+}
+
+// nearest returns a rule rounding to a multiple of step
+func nearest(step float64) func(v float64) (float64, bool) {
+	return func(v float64) (float64, bool) { return round(v, step), false }
+}
+
+// calories are declared as 0 below 5, to 5 up to 50 and to 10 above
+func calories(v float64) float64 {
+	switch {
+	case v < 5:
+		return 0
+	case v <= 50:
+		return round(v, 5)
+	}
+	return round(v, 10)
+}
+
+// fat is declared as 0 below 0.5g, to 0.5g below 5g and to 1g above
+func fat(v float64) (float64, bool) {
+	switch {
+	case v < 0.5:
+		return 0, false
+	case v < 5:
+		return round(v, 0.5), false
+	}
+	return round(v, 1), false
+}
+
+// cholesterol is declared as 0 below 2mg, as less than 5mg up to 5mg and to 5mg above
+func cholesterol(v float64) (float64, bool) {
+	switch {
+	case v < 2:
+		return 0, false
+	case v <= 5:
+		return 5, true
+	}
+	return round(v, 5), false
+}
+
+// sodium is declared as 0 below 5mg, to 5mg up to 140mg and to 10mg above
+func sodium(v float64) (float64, bool) {
+	switch {
+	case v < 5:
+		return 0, false
+	case v <= 140:
+		return round(v, 5), false
+	}
+	return round(v, 10), false
+}
+
+// grams declares carbohydrates and protein as 0 below 0.5g, as less than 1g
+// below 1g and to 1g above
+func grams(v float64) (float64, bool) {
+	switch {
+	case v < 0.5:
+		return 0, false
+	case v < 1:
+		return 1, true
+	}
+	return round(v, 1), false
+}
+
+// macroDV rounds the percent daily value of a macronutrient to 1%
+func macroDV(pct float64) int {
+	return int(math.Round(pct))
+}
+
+// microDV rounds the percent daily value of a vitamin or mineral to 0 below 2%,
+// to 2% up to 10%, to 5% up to 50% and to 10% above
+func microDV(pct float64) int {
+	switch {
+	case pct < 2:
+		return 0
+	case pct <= 10:
+		return int(round(pct, 2))
+	case pct <= 50:
+		return int(round(pct, 5))
+	}
+	return int(round(pct, 10))
+}
@@ -0,0 +1,144 @@
+package label
+
+import (
+	"errors"
+	"testing"
+
+	fdc "github.com/littlebunch/fdc-api/model"
+)
+
+// rule is a rounding rule declaring an amount and whether it is less than
+type rule func(v float64) (float64, bool)
+
+func TestRounding(t *testing.T) {
+	cal := func(v float64) (float64, bool) { return calories(v), false }
+	for _, tc := range []struct {
+		name string
+		rule rule
+		v    float64
+		want float64
+		less bool
+	}{
+		{"calories", cal, 4.9, 0, false},
+		{"calories", cal, 5, 5, false},
+		{"calories", cal, 7.4, 5, false},
+		{"calories", cal, 7.5, 10, false},
+		{"calories", cal, 50, 50, false},
+		{"calories", cal, 52, 50, false},
+		{"calories", cal, 55, 60, false},
+		{"fat", fat, 0.49, 0, false},
+		{"fat", fat, 0.5, 0.5, false},
+		{"fat", fat, 0.74, 0.5, false},
+		{"fat", fat, 0.75, 1, false},
+		{"fat", fat, 4.9, 5, false},
+		{"fat", fat, 5, 5, false},
+		{"fat", fat, 5.4, 5, false},
+		{"fat", fat, 5.5, 6, false},
+		{"cholesterol", cholesterol, 1.9, 0, false},
+		{"cholesterol", cholesterol, 2, 5, true},
+		{"cholesterol", cholesterol, 5, 5, true},
+		{"cholesterol", cholesterol, 7.4, 5, false},
+		{"cholesterol", cholesterol, 7.5, 10, false},
+		{"sodium", sodium, 4.9, 0, false},
+		{"sodium", sodium, 5, 5, false},
+		{"sodium", sodium, 7.5, 10, false},
+		{"sodium", sodium, 140, 140, false},
+		{"sodium", sodium, 144, 140, false},
+		{"sodium", sodium, 145, 150, false},
+		{"grams", grams, 0.49, 0, false},
+		{"grams", grams, 0.5, 1, true},
+		{"grams", grams, 0.99, 1, true},
+		{"grams", grams, 1, 1, false},
+		{"grams", grams, 1.5, 2, false},
+		{"tenths", nearest(0.1), 0.15, 0.2, false},
+		{"tens", nearest(10), 24.9, 20, false},
+	} {
+		if got, less := tc.rule(tc.v); got != tc.want || less != tc.less {
+			t.Errorf("%s(%g) = %g %v, want %g %v", tc.name, tc.v, got, less, tc.want, tc.less)
+		}
+	}
+}
+
+func TestPercentDailyValue(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		dv   func(float64) int
+		pct  float64
+		want int
+	}{
+		{"macro", macroDV, 0.4, 0},
+		{"macro", macroDV, 0.5, 1},
+		{"macro", macroDV, 12.5, 13},
+		{"micro", microDV, 1.9, 0},
+		{"micro", microDV, 2, 2},
+		{"micro", microDV, 3, 4},
+		{"micro", microDV, 10, 10},
+		{"micro", microDV, 11, 10},
+		{"micro", microDV, 12.5, 15},
+		{"micro", microDV, 50, 50},
+		{"micro", microDV, 54, 50},
+		{"micro", microDV, 55, 60},
+	} {
+		if got := tc.dv(tc.pct); got != tc.want {
+			t.Errorf("%s(%g) = %d, want %d", tc.name, tc.pct, got, tc.want)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	f := fdc.Food{FdcID: "1", Description: "Test food", Servings: []fdc.Serving{
+		{Nutrientbasis: "g", Description: "cup, chopped", Servingamount: 1, Weight: 50},
+		{Description: "pinch"},
+	}}
+	// values per 100g so half of each is declared for the 50g serving
+	nutdata := []fdc.NutrientData{
+		{Nutrientno: energy, Unit: "kJ", Value: 1000},
+		{Nutrientno: energy, Unit: "kcal", Value: 105},
+		{Nutrientno: 204, Unit: "g", Value: 78},
+		{Nutrientno: 601, Unit: "mg", Value: 6},
+		{Nutrientno: 307, Unit: "mg", Value: 282},
+		{Nutrientno: 539, Unit: "g", Value: 1.2},
+		{Nutrientno: 203, Unit: "g", Value: 1},
+		{Nutrientno: 301, Unit: "mg", Value: 52},
+	}
+	l, err := New(f, nutdata, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.ServingSize != "1 cup, chopped (50g)" || l.Calories == nil || *l.Calories != 50 {
+		t.Errorf("serving %q calories %v", l.ServingSize, l.Calories)
+	}
+	lines := make(map[uint]Line)
+	for _, ln := range append(l.Nutrients, l.Vitamins...) {
+		lines[ln.Nutrientno] = ln
+	}
+	for _, tc := range []struct {
+		nutrientno uint
+		name       string
+		display    string
+		dv         int
+	}{
+		{204, "Total Fat", "39g", 50},
+		{601, "Cholesterol", "<5mg", 1},
+		{307, "Sodium", "140mg", 6},
+		{539, "Includes <1g Added Sugars", "", 1},
+		{203, "Protein", "<1g", -1},
+		{301, "Calcium", "30mg", 2},
+		{205, "Total Carbohydrate", "-", -1},
+		{291, "Dietary Fiber", "-", -1},
+	} {
+		ln := lines[tc.nutrientno]
+		dv := -1
+		if ln.PercentDailyValue != nil {
+			dv = *ln.PercentDailyValue
+		}
+		if ln.Name != tc.name || ln.Display != tc.display || dv != tc.dv {
+			t.Errorf("%d is %q %q %d%%, want %q %q %d%%", tc.nutrientno, ln.Name, ln.Display, dv, tc.name, tc.display, tc.dv)
+		}
+	}
+	for _, serving := range []int{1, 2, -1} {
+		if _, err := New(f, nutdata, serving); !errors.Is(err, ErrServing) {
+			t.Errorf("serving %d: error %v, want ErrServing", serving, err)
+		}
+	}
+}
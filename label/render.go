@@ -0,0 +1,168 @@
+package label
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"html/template"
+	"strconv"
+)
+
+// footnote is the daily value footnote printed at the foot of a label
+const footnote = "* The % Daily Value (DV) tells you how much a nutrient in a serving of food contributes to a daily diet. 2,000 calories a day is used for general nutrition advice."
+
+// svgWidth is the width of a label in pixels
+const svgWidth = 300
+
+// svg accumulates the elements of a label drawn top to bottom
+type svg struct {
+	buf bytes.Buffer
+	y   int
+}
+
+// rule draws a horizontal rule of a thickness
+func (s *svg) rule(thickness int) {
+	fmt.Fprintf(&s.buf, `<rect x="8" y="%d" width="%d" height="%d"/>`, s.y, svgWidth-16, thickness)
+	s.y += thickness + 2
+}
+
+// text draws a row with text on the left and optionally on the right
+func (s *svg) text(size int, left string, leftBold bool, indent int, right string, rightBold bool) {
+	s.y += size
+	if left != "" {
+		fmt.Fprintf(&s.buf, `<text x="%d" y="%d" font-size="%d"%s>%s</text>`, 8+indent*12, s.y, size, weight(leftBold), left)
+	}
+	if right != "" {
+		fmt.Fprintf(&s.buf, `<text x="%d" y="%d" font-size="%d" text-anchor="end"%s>%s</text>`, svgWidth-8, s.y, size, weight(rightBold), right)
+	}
+	s.y += 4
+}
+
+func weight(bold bool) string {
+	if bold {
+		return ` font-weight="bold"`
+	}
+	return ""
+}
+
+// SVG renders the label as an SVG image
+func (l *Label) SVG() []byte {
+	var s svg
+	s.y = 4
+	s.text(30, "Nutrition Facts", true, 0, "", false)
+	s.text(11, html.EscapeString(l.FoodDescription), false, 0, "", false)
+	s.rule(1)
+	s.text(14, "Serving size", true, 0, html.EscapeString(l.ServingSize), true)
+	s.rule(8)
+	s.text(11, "Amount per serving", true, 0, "", false)
+	s.text(24, "Calories", true, 0, display(l.Calories, "-"), true)
+	s.rule(4)
+	s.text(11, "", false, 0, "% Daily Value*", true)
+	for _, n := range l.Nutrients {
+		s.rule(1)
+		left := html.EscapeString(n.Name)
+		if n.Display != "" {
+			left = fmt.Sprintf(`<tspan%s>%s</tspan> %s`, weight(n.Bold), left, html.EscapeString(n.Display))
+		}
+		s.text(12, left, false, n.Indent, percent(n.PercentDailyValue), true)
+	}
+	s.rule(8)
+	for i, n := range l.Vitamins {
+		if i > 0 {
+			s.rule(1)
+		}
+		s.text(12, html.EscapeString(n.Name+" "+n.Display), false, 0, percent(n.PercentDailyValue), false)
+	}
+	s.rule(4)
+	for _, line := range wrap(footnote, 56) {
+		s.text(9, html.EscapeString(line), false, 0, "", false)
+	}
+	s.y += 4
+	var out bytes.Buffer
+	fmt.Fprintf(&out, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="Helvetica, Arial, sans-serif">`, svgWidth, s.y, svgWidth, s.y)
+	fmt.Fprintf(&out, `<rect width="%d" height="%d" fill="white" stroke="black"/>`, svgWidth, s.y)
+	out.Write(s.buf.Bytes())
+	out.WriteString(`</svg>`)
+	return out.Bytes()
+}
+
+// wrap breaks text into lines of at most width characters
+func wrap(text string, width int) []string {
+	var (
+		lines []string
+		line  string
+	)
+	for _, w := range bytes.Fields([]byte(text)) {
+		if line != "" && len(line)+1+len(w) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += string(w)
+	}
+	return append(lines, line)
+}
+
+// display formats an optional amount
+func display(v *int, missing string) string {
+	if v == nil {
+		return missing
+	}
+	return strconv.Itoa(*v)
+}
+
+// percent formats an optional percent daily value
+func percent(v *int) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.Itoa(*v) + "%"
+}
+
+var page = template.Must(template.New("label").Funcs(template.FuncMap{
+	"display": display,
+	"percent": percent,
+	"indent":  func(n int) int { return n * 12 },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Nutrition Facts: {{.FoodDescription}}</title>
+<style>
+.nf { width: 284px; padding: 6px; border: 1px solid #000; font-family: Helvetica, Arial, sans-serif; font-size: 12px; }
+.nf h1 { margin: 0; font-size: 30px; }
+.nf .row { display: flex; justify-content: space-between; border-top: 1px solid #000; padding: 2px 0; }
+.nf .thick { border-top: 8px solid #000; }
+.nf .medium { border-top: 4px solid #000; }
+.nf .calories { font-size: 24px; font-weight: bold; }
+.nf .foot { font-size: 9px; }
+</style>
+</head>
+<body>
+<div class="nf">
+<h1>Nutrition Facts</h1>
+<div>{{.FoodDescription}}</div>
+<div class="row"><b>Serving size</b><b>{{.ServingSize}}</b></div>
+<div class="row thick"><b>Amount per serving</b></div>
+<div class="row calories" style="border-top:0"><span>Calories</span><span>{{display .Calories "-"}}</span></div>
+<div class="row medium"><span></span><b>% Daily Value*</b></div>
+{{range .Nutrients}}<div class="row"><span style="padding-left:{{indent .Indent}}px">{{if .Bold}}<b>{{.Name}}</b>{{else}}{{.Name}}{{end}} {{.Display}}</span><b>{{percent .PercentDailyValue}}</b></div>
+{{end}}<div class="thick"></div>
+{{range $i, $v := .Vitamins}}<div class="row"{{if eq $i 0}} style="border-top:0"{{end}}><span>{{.Name}} {{.Display}}</span><span>{{percent .PercentDailyValue}}</span></div>
+{{end}}<div class="row medium foot">{{.Footnote}}</div>
+</div>
+</body>
+</html>
+`))
+
+// HTML renders the label as an HTML page
+func (l *Label) HTML() ([]byte, error) {
+	var buf bytes.Buffer
+	err := page.Execute(&buf, struct {
+		*Label
+		Footnote string
+	}{l, footnote})
+	return buf.Bytes(), err
+}
@@ -3,21 +3,40 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/99designs/gqlgen/handler"
 	"github.com/fvbock/endless"
 	"github.com/gin-gonic/gin"
-	"github.com/graphql-go/graphql"
 	"github.com/littlebunch/fdc-api/ds"
 	"github.com/littlebunch/fdc-api/ds/cb"
 	fdc "github.com/littlebunch/fdc-api/model"
+	"github.com/littlebunch/fdc-graphql/auth"
+	"github.com/littlebunch/fdc-graphql/cache"
+	"github.com/littlebunch/fdc-graphql/config"
+	"github.com/littlebunch/fdc-graphql/dailyvalue"
+	"github.com/littlebunch/fdc-graphql/datastore"
+	"github.com/littlebunch/fdc-graphql/datastore/couchbase"
+	"github.com/littlebunch/fdc-graphql/datastore/memory"
+	"github.com/littlebunch/fdc-graphql/datastore/sqldb"
+	"github.com/littlebunch/fdc-graphql/events"
+	"github.com/littlebunch/fdc-graphql/gqlhttp"
+	"github.com/littlebunch/fdc-graphql/label"
+	"github.com/littlebunch/fdc-graphql/persisted"
+	"github.com/littlebunch/fdc-graphql/ratelimit"
+	"github.com/littlebunch/fdc-graphql/resolvers"
 	"github.com/littlebunch/fdc-graphql/schema"
+	"github.com/littlebunch/fdc-graphql/subscriptions"
+	"github.com/littlebunch/fdc-graphql/suggest"
+	"github.com/littlebunch/fdc-graphql/utils"
 )
 
 const (
@@ -31,8 +50,11 @@ var (
 	i   = flag.Bool("i", false, "Initialize the authentication store")
 	c   = flag.String("c", "config.yml", "YAML Config file")
 	l   = flag.String("l", "/tmp/fdcgql.out", "send log output to this file -- defaults to /tmp/fdcgcl.out")
+	m   = flag.String("m", "", "comma separated list of FDC JSON files or CSV directories to load into memory instead of using Couchbase")
+	s   = flag.String("s", "", "use a SQL database instead of Couchbase given as driver:dsn, e.g. sqlite3:fdc.db")
 	p   = flag.String("p", "8000", "TCP port to used")
 	r   = flag.String("r", "graphql", "root path to deploy -- defaults to 'v1'")
+	dv  = flag.String("dv", "", "JSON file of daily value profiles to add to the FDA defaults, e.g. DRIs by age and sex")
 	cs  fdc.Config
 	err error
 	dc  ds.DataSource
@@ -53,25 +75,95 @@ func init() {
 
 func main() {
 
-	var cb cb.Cb
+	var (
+		cb    cb.Cb
+		store datastore.Datastore
+	)
 	flag.Parse()
 	// get configuration
 	cs.GetConfig(c)
-	// Create a datastore and connect to it
-	dc = &cb
-	err = dc.ConnectDs(cs)
+	if *dv != "" {
+		if err = dailyvalue.Load(*dv); err != nil {
+			log.Fatalf("Cannot load daily values %v.", err)
+		}
+	}
+	if *m != "" {
+		// Load FDC downloads into an in-memory datastore
+		mem := memory.NewDatastore()
+		err = mem.Load(strings.Split(*m, ",")...)
+		if err != nil {
+			log.Fatalf("Cannot load %s into memory %v.", *m, err)
+		}
+		store = mem
+	} else if *s != "" {
+		// Connect to a SQL datastore
+		dsn := strings.SplitN(*s, ":", 2)
+		if len(dsn) != 2 {
+			log.Fatalf("SQL datastore must be given as driver:dsn not %s", *s)
+		}
+		db, err := sqldb.Open(dsn[0], dsn[1])
+		if err != nil {
+			log.Fatalf("Cannot get datastore connection %v.", err)
+		}
+		defer db.Close()
+		store = db
+	} else {
+		// Create a datastore and connect to it
+		dc = &cb
+		err = dc.ConnectDs(cs)
+		if err != nil {
+			log.Fatalf("Cannot get datastore connection %v.", err)
+		}
+		defer dc.CloseDs()
+		store = couchbase.NewDatastore(&cb, cs)
+	}
+	// initialize our jwt and API key authentication
+	conf, err := config.Read(*c)
+	if err != nil {
+		log.Fatalf("Cannot read config %s %v.", *c, err)
+	}
+	users, err := auth.OpenFile(conf.Auth.Users)
+	if err != nil {
+		log.Fatalf("Cannot open the authentication store %s %v.", conf.Auth.Users, err)
+	}
+	authn, err := auth.New(conf.Auth, users)
 	if err != nil {
-		log.Fatalf("Cannot get datastore connection %v.", err)
+		log.Fatalf("Cannot initialize authentication %v.", err)
+	}
+	if *i {
+		name, pwd, key, err := authn.Bootstrap()
+		switch {
+		case errors.Is(err, auth.ErrExists):
+			log.Printf("Authentication store %s already has user %s", conf.Auth.Users, name)
+		case err != nil:
+			log.Fatalf("Cannot initialize the authentication store %v.", err)
+		default:
+			// credentials go to stdout only, never the log file
+			fmt.Printf("Created user %s with password %s and API key %s\n", name, pwd, key)
+		}
 	}
-	defer dc.CloseDs()
-	// initialize our jwt authentication
-	//var u *auth.User
-	//if *i {
-	//	u.BootstrapUsers(session, cs.MongoDb.Collection)
-	//}
-	//authMiddleware := u.AuthMiddleware(session, cs.MongoDb.Collection)
-	//router := gin.Default()
-	schema, err := schema.InitSchema(cb, cs)
+	authMiddleware := auth.Disabled()
+	if conf.Auth.Enabled {
+		authMiddleware = authn.Middleware()
+	} else {
+		log.Println("Authentication is disabled so requests may only run queries.  Set auth.enabled in the config file to use mutations and the admin routes.")
+	}
+	limits := ratelimit.New(conf.RateLimit)
+	limiter := limits.Middleware()
+	// mutations and ingest notices publish to the bus for subscriptions
+	bus := events.NewBus()
+	var index *suggest.Index
+	if conf.Suggest.Enabled {
+		// foodSuggest falls back to PREFIX searches until the index is built
+		index = suggest.New(store)
+		index.Watch(bus)
+		go func() {
+			if err := index.Build(); err != nil {
+				log.Printf("Cannot build the suggest index %v.", err)
+			}
+		}()
+	}
+	schema, err := schema.InitSchema(store, bus, index)
 
 	if err != nil {
 		log.Fatalf("Cannot create the schema %v\n", err)
@@ -79,40 +171,98 @@ func main() {
 	router := gin.New()
 	router.Use(gin.Logger())
 	router.Use(gin.Recovery())
+	if conf.RateLimit.Enabled {
+		// clients could dodge their limits by sending X-Forwarded-For if any proxy were trusted
+		if err = router.SetTrustedProxies(conf.RateLimit.TrustedProxies); err != nil {
+			log.Fatalf("Cannot set trusted proxies %v.", err)
+		}
+	}
 
 	v1 := router.Group(fmt.Sprintf("%s", *r))
 	{
-		//v1.POST("/login", authMiddleware.LoginHandler)
 		v1.GET("/", gin.WrapH(handler.Playground("GraphQL playground", "/graphql")))
-		v1.GET("", func(c *gin.Context) {
-			result := graphql.Do(graphql.Params{
-				Schema:        schema,
-				RequestString: c.Query("query"),
-			})
-			c.JSON(http.StatusOK, result)
-		})
-		v1.POST("", func(c *gin.Context) {
-			type Q struct {
-				Query string `json:"query"`
+		// api routes are authenticated then counted against the caller's rate limits
+		api := v1.Group("", authMiddleware, limiter)
+		if conf.Auth.Enabled {
+			v1.POST("/login", limiter, authn.LoginHandler)
+			api.POST("/keys", authn.CreateKeyHandler)
+			api.DELETE("/keys/:id", authn.DeleteKeyHandler)
+			api.POST("/users", authn.UserHandler)
+		}
+		gql := &gqlhttp.Handler{Schema: schema, Limits: conf.Complexity, Batch: conf.Batch, Charge: limits.Charge}
+		if conf.Persisted.Enabled {
+			if gql.Persisted, err = persisted.New(conf.Persisted); err != nil {
+				log.Fatalf("Cannot load persisted queries %v.", err)
 			}
-			var q Q
-			err := c.BindJSON(&q)
-			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{
-					"error":  "json decoding : " + err.Error(),
-					"status": http.StatusBadRequest,
-				})
-				return
+		}
+		if conf.Cache.Enabled {
+			if gql.Cache, err = cache.New(conf.Cache); err != nil {
+				log.Fatalf("Cannot open the response cache %v.", err)
 			}
-
-			result := graphql.Do(graphql.Params{
-				Schema:        schema,
-				RequestString: q.Query,
-			})
-			c.JSON(http.StatusOK, result)
-		})
-
+			// results are stale once foods change or a release is loaded
+			gql.Cache.Watch(bus)
+			api.DELETE("/cache", gql.Cache.PurgeHandler)
+		}
+		get := []gin.HandlerFunc{authMiddleware, limiter, gql.ServeGin}
+		if conf.Subscriptions.Enabled {
+			// WebSocket clients may authenticate in connection_init so are upgraded before the auth middleware
+			ws := &subscriptions.Handler{GQL: gql, Config: conf.Subscriptions, Authenticate: auth.Unauthenticated, Charge: limits.Charge, Allow: limits.Allow}
+			if conf.Auth.Enabled {
+				ws.Authenticate = authn.Authenticate
+			}
+			get = append([]gin.HandlerFunc{ws.Upgrade}, get...)
+		}
+		v1.GET("", get...)
+		api.POST("", gql.ServeGin)
+		api.POST("/events", bus.Handler)
+		api.GET("/label/:id", labelHandler(&resolvers.Resolver{Ds: store}))
 	}
 	endless.ListenAndServe(":"+*p, router)
 
 }
+
+// labelHandler serves the Nutrition Facts label of a food as JSON, SVG or HTML
+// chosen by the format parameter.  The serving parameter is the zero based index
+// of the serving in the food's servingSizes.
+func labelHandler(res *resolvers.Resolver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		fail := func(status int, msg string) {
+			c.JSON(status, gin.H{
+				"error":  msg,
+				"status": status,
+			})
+		}
+		serving, err := strconv.Atoi(c.DefaultQuery("serving", "0"))
+		if err != nil {
+			fail(http.StatusBadRequest, "serving must be a number")
+			return
+		}
+		l, err := res.Label(c.Param("id"), serving)
+		switch {
+		case err != nil && (errors.Is(err, label.ErrServing) || !utils.ValidFdcid(c.Param("id"))):
+			fail(http.StatusBadRequest, err.Error())
+			return
+		case err != nil:
+			fail(http.StatusInternalServerError, err.Error())
+			return
+		case l == nil:
+			fail(http.StatusNotFound, fmt.Sprintf("food %s not found", c.Param("id")))
+			return
+		}
+		switch c.DefaultQuery("format", "json") {
+		case "json":
+			c.JSON(http.StatusOK, l)
+		case "svg":
+			c.Data(http.StatusOK, "image/svg+xml", l.SVG())
+		case "html":
+			b, err := l.HTML()
+			if err != nil {
+				fail(http.StatusInternalServerError, err.Error())
+				return
+			}
+			c.Data(http.StatusOK, "text/html; charset=utf-8", b)
+		default:
+			fail(http.StatusBadRequest, "format must be json, svg or html")
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package persisted
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Manifest is a read only Store of queries loaded from a JSON file.  The file
+// is either an object mapping hashes to queries or an Apollo persisted query
+// manifest with an operations array of ids and bodies.
+type Manifest struct {
+	queries map[string]string
+}
+
+// apolloManifest is the format written by Apollo's manifest generator
+type apolloManifest struct {
+	Operations []struct {
+		ID   string `json:"id"`
+		Body string `json:"body"`
+	} `json:"operations"`
+}
+
+// OpenManifest loads a manifest checking each hash matches its query
+func OpenManifest(path string) (*Manifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	queries := make(map[string]string)
+	var am apolloManifest
+	if err = json.Unmarshal(b, &am); err == nil && len(am.Operations) > 0 {
+		for _, op := range am.Operations {
+			queries[op.ID] = op.Body
+		}
+	} else if err = json.Unmarshal(b, &queries); err != nil {
+		return nil, fmt.Errorf("%s is not a persisted query manifest: %v", path, err)
+	}
+	for hash, q := range queries {
+		if Hash(q) != hash {
+			return nil, fmt.Errorf("%s: hash %s does not match its query", path, hash)
+		}
+	}
+	return &Manifest{queries: queries}, nil
+}
+
+// Query returns a query by hash
+func (m *Manifest) Query(hash string) (string, error) {
+	return m.queries[hash], nil
+}
+
+// PutQuery does nothing as a manifest is only changed by editing its file
+func (m *Manifest) PutQuery(hash, query string) error {
+	return nil
+}
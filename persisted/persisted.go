@@ -0,0 +1,209 @@
+// Package persisted implements automatic persisted queries as sent by Apollo
+// clients.  A client sends the SHA-256 hash of a query in the persistedQuery
+// extension and sends the query itself only when the server does not know it.
+package persisted
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Version is the version of the persisted query protocol supported
+const Version = 1
+
+// Errors returned by Lookup
+var (
+	ErrNotFound   = &Error{Code: "PERSISTED_QUERY_NOT_FOUND", Message: "PersistedQueryNotFound"}
+	ErrNotAllowed = &Error{Code: "PERSISTED_QUERY_NOT_ALLOWED", Message: "query is not in the allowlist"}
+)
+
+// Error is a persisted query error with the code clients look for in the
+// extensions of a GraphQL error
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Extensions returns the extensions of the GraphQL error
+func (e *Error) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": e.Code}
+}
+
+// Config are the settings in the persisted section of the config file.  Size is
+// the number of queries kept in memory.  With Allowlist only queries in the
+// Manifest file may be run.
+type Config struct {
+	Enabled   bool   `yaml:"enabled"`
+	Size      int    `yaml:"size"`
+	Allowlist bool   `yaml:"allowlist"`
+	Manifest  string `yaml:"manifest"`
+}
+
+// Store reads and writes persisted queries by hash.  Lookups return an empty
+// query without an error when there is no match.
+type Store interface {
+	Query(hash string) (string, error)
+	PutQuery(hash, query string) error
+}
+
+// Query is a query to run
+type Query struct {
+	Text     string
+	Hash     string
+	Register bool // whether the query is new and should be stored once it validates
+}
+
+// entry is a query in the LRU list
+type entry struct {
+	hash, query string
+}
+
+// Cache keeps the most recently used queries in memory in front of an
+// optional backend such as a Manifest
+type Cache struct {
+	Backend   Store
+	allowlist bool
+	size      int
+	mu        sync.Mutex
+	order     *list.List
+	items     map[string]*list.Element
+}
+
+// New returns a Cache for a config loading the manifest, if any, as its backend
+func New(cfg Config) (*Cache, error) {
+	c := &Cache{allowlist: cfg.Allowlist, size: cfg.Size, order: list.New(), items: make(map[string]*list.Element)}
+	if c.size <= 0 {
+		c.size = 1000
+	}
+	if cfg.Manifest != "" {
+		m, err := OpenManifest(cfg.Manifest)
+		if err != nil {
+			return nil, err
+		}
+		c.Backend = m
+	}
+	if c.allowlist && c.Backend == nil {
+		return nil, errors.New("the persisted query allowlist needs a manifest")
+	}
+	return c, nil
+}
+
+// Hash returns the hex SHA-256 hash of a query
+func Hash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// Lookup returns the query to run for a request's query and extensions.  A
+// request with only a hash gets the stored query or ErrNotFound.  In allowlist
+// mode queries not in the store get ErrNotAllowed.
+func (c *Cache) Lookup(query string, extensions map[string]interface{}) (Query, error) {
+	hash, err := extension(extensions)
+	if err != nil {
+		return Query{}, err
+	}
+	if hash == "" {
+		if !c.allowlist || strings.TrimSpace(query) == "" {
+			return Query{Text: query}, nil
+		}
+		hash = Hash(query)
+		if q, _ := c.Query(hash); q != query {
+			return Query{}, ErrNotAllowed
+		}
+		return Query{Text: query, Hash: hash}, nil
+	}
+	stored, err := c.Query(hash)
+	if err != nil {
+		return Query{}, err
+	}
+	switch {
+	case stored != "":
+		return Query{Text: stored, Hash: hash}, nil
+	case c.allowlist:
+		return Query{}, ErrNotAllowed
+	case query == "":
+		return Query{}, ErrNotFound
+	case Hash(query) != hash:
+		return Query{}, errors.New("provided sha256Hash does not match query")
+	}
+	return Query{Text: query, Hash: hash, Register: true}, nil
+}
+
+// extension returns the hash in the persistedQuery extension
+func extension(extensions map[string]interface{}) (string, error) {
+	pq, ok := extensions["persistedQuery"]
+	if !ok || pq == nil {
+		return "", nil
+	}
+	m, ok := pq.(map[string]interface{})
+	if !ok {
+		return "", errors.New("persistedQuery extension must be an object")
+	}
+	if v, _ := m["version"].(float64); v != Version {
+		return "", fmt.Errorf("persisted query version %v is not supported", m["version"])
+	}
+	hash, _ := m["sha256Hash"].(string)
+	if len(hash) != sha256.Size*2 {
+		return "", errors.New("persistedQuery extension needs a sha256Hash")
+	}
+	return strings.ToLower(hash), nil
+}
+
+// Query returns a query by hash from memory or the backend
+func (c *Cache) Query(hash string) (string, error) {
+	c.mu.Lock()
+	if e, ok := c.items[hash]; ok {
+		c.order.MoveToFront(e)
+		c.mu.Unlock()
+		return e.Value.(*entry).query, nil
+	}
+	c.mu.Unlock()
+	if c.Backend == nil {
+		return "", nil
+	}
+	q, err := c.Backend.Query(hash)
+	if err != nil || q == "" {
+		return "", err
+	}
+	c.add(hash, q)
+	return q, nil
+}
+
+// PutQuery stores a query in memory and the backend.  Backend errors are
+// logged as the query is still usable from memory.
+func (c *Cache) PutQuery(hash, query string) error {
+	c.add(hash, query)
+	if c.Backend != nil {
+		if err := c.Backend.PutQuery(hash, query); err != nil {
+			log.Printf("Cannot store persisted query %s: %v", hash, err)
+		}
+	}
+	return nil
+}
+
+// add puts a query at the front of the LRU list evicting the least recently
+// used once the cache is full
+func (c *Cache) add(hash, query string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[hash]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+	c.items[hash] = c.order.PushFront(&entry{hash: hash, query: query})
+	for c.order.Len() > c.size {
+		e := c.order.Back()
+		c.order.Remove(e)
+		delete(c.items, e.Value.(*entry).hash)
+	}
+}
@@ -0,0 +1,147 @@
+package persisted
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	known   = `{foods(fdcids:["1"]){fdcId}}`
+	unknown = `{foods(fdcids:["2"]){fdcId}}`
+)
+
+// manifest writes a manifest file returning its path
+func manifest(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "manifest.json")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// extensions returns request extensions with a persistedQuery hash
+func extensions(hash string) map[string]interface{} {
+	return map[string]interface{}{"persistedQuery": map[string]interface{}{"version": 1.0, "sha256Hash": hash}}
+}
+
+func TestLookup(t *testing.T) {
+	path := manifest(t, `{"`+Hash(known)+`": "{foods(fdcids:[\"1\"]){fdcId}}"}`)
+	for _, tc := range []struct {
+		name      string
+		allowlist bool
+		query     string
+		ext       map[string]interface{}
+		want      Query
+		err       error
+	}{
+		{"no extension", false, unknown, nil, Query{Text: unknown}, nil},
+		{"stored hash", false, "", extensions(Hash(known)), Query{Text: known, Hash: Hash(known)}, nil},
+		{"uppercase hash", false, "", extensions(strings.ToUpper(Hash(known))), Query{Text: known, Hash: Hash(known)}, nil},
+		{"unknown hash", false, "", extensions(Hash(unknown)), Query{}, ErrNotFound},
+		{"register", false, unknown, extensions(Hash(unknown)), Query{Text: unknown, Hash: Hash(unknown), Register: true}, nil},
+		{"register uppercase hash", false, unknown, extensions(strings.ToUpper(Hash(unknown))), Query{Text: unknown, Hash: Hash(unknown), Register: true}, nil},
+		{"allowlist query", true, known, nil, Query{Text: known, Hash: Hash(known)}, nil},
+		{"allowlist hash", true, "", extensions(Hash(known)), Query{Text: known, Hash: Hash(known)}, nil},
+		{"allowlist unknown query", true, unknown, nil, Query{}, ErrNotAllowed},
+		{"allowlist unknown hash", true, unknown, extensions(Hash(unknown)), Query{}, ErrNotAllowed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := New(Config{Allowlist: tc.allowlist, Manifest: path})
+			if err != nil {
+				t.Fatal(err)
+			}
+			q, err := c.Lookup(tc.query, tc.ext)
+			if err != tc.err {
+				t.Fatalf("error %v, want %v", err, tc.err)
+			}
+			if q != tc.want {
+				t.Errorf("query %+v, want %+v", q, tc.want)
+			}
+		})
+	}
+}
+
+func TestLookupErrors(t *testing.T) {
+	c, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name  string
+		query string
+		ext   map[string]interface{}
+	}{
+		{"hash mismatch", unknown, extensions(Hash(known))},
+		{"short hash", known, extensions(Hash(known)[:10])},
+		{"no hash", known, map[string]interface{}{"persistedQuery": map[string]interface{}{"version": 1.0}}},
+		{"version", known, map[string]interface{}{"persistedQuery": map[string]interface{}{"version": 2.0, "sha256Hash": Hash(known)}}},
+		{"not an object", known, map[string]interface{}{"persistedQuery": "x"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if q, err := c.Lookup(tc.query, tc.ext); err == nil {
+				t.Errorf("query %+v, want an error", q)
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	c, err := New(Config{Size: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{known, unknown} {
+		if err = c.PutQuery(Hash(q), q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = c.Lookup("", extensions(Hash(known))); err != ErrNotFound {
+		t.Errorf("evicted query: error %v, want %v", err, ErrNotFound)
+	}
+	if q, err := c.Lookup("", extensions(Hash(unknown))); err != nil || q.Text != unknown {
+		t.Errorf("registered query: %+v %v, want %s", q, err, unknown)
+	}
+}
+
+func TestOpenManifest(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"map", `{"` + Hash(known) + `": "{foods(fdcids:[\"1\"]){fdcId}}"}`, ""},
+		{"apollo", `{"format":"apollo-persisted-query-manifest","version":1,"operations":[{"id":"` + Hash(known) + `","name":"q","type":"query","body":"{foods(fdcids:[\"1\"]){fdcId}}"}]}`, ""},
+		{"bad json", `{"operations":`, "is not a persisted query manifest"},
+		{"not a map", `["` + known + `"]`, "is not a persisted query manifest"},
+		{"hash mismatch", `{"` + Hash(unknown) + `": "{foods(fdcids:[\"1\"]){fdcId}}"}`, "does not match its query"},
+		{"apollo hash mismatch", `{"operations":[{"id":"` + Hash(unknown) + `","body":"{foods(fdcids:[\"1\"]){fdcId}}"}]}`, "does not match its query"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := OpenManifest(manifest(t, tc.content))
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("error %v, want %s", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if q, _ := m.Query(Hash(known)); q != known {
+				t.Errorf("query %s, want %s", q, known)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(Config{Manifest: manifest(t, `{"`+Hash(unknown)+`": "{}"}`)}); err == nil {
+		t.Error("bad manifest: want an error")
+	}
+	if _, err := New(Config{Allowlist: true}); err == nil {
+		t.Error("allowlist without a manifest: want an error")
+	}
+}
@@ -0,0 +1,210 @@
+// Package ratelimit limits the number of requests each client may make per
+// minute and per day.  Clients are identified by their API key, their user name
+// or their IP address in that order.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/littlebunch/fdc-graphql/auth"
+)
+
+// Limits are the number of requests a client may make.  Zero is unlimited.
+type Limits struct {
+	PerMinute int `yaml:"perMinute"`
+	Daily     int `yaml:"daily"`
+}
+
+// Config are the settings in the ratelimit section of the config file.  Clients
+// overrides the default limits for API key ids, user names or IP addresses.
+// Only TrustedProxies may give the client IP in X-Forwarded-For.
+type Config struct {
+	Enabled        bool `yaml:"enabled"`
+	Limits         `yaml:",inline"`
+	Clients        map[string]Limits `yaml:"clients"`
+	TrustedProxies []string          `yaml:"trustedProxies"`
+}
+
+// window counts requests made since start
+type window struct {
+	start time.Time
+	count int
+}
+
+// client are the request counts of one client
+type client struct {
+	minute, day window
+	daily       bool // whether the client has a daily quota
+}
+
+// Limiter counts requests per client in memory
+type Limiter struct {
+	cfg     Config
+	mu      sync.Mutex
+	clients map[string]*client
+	swept   time.Time
+	now     func() time.Time
+}
+
+// result is the outcome of counting a request
+type result struct {
+	allowed bool
+	minute  window
+	day     window
+	retry   time.Time
+}
+
+// New returns a Limiter for a config
+func New(cfg Config) *Limiter {
+	return &Limiter{cfg: cfg, clients: make(map[string]*client), now: time.Now}
+}
+
+// Middleware counts each request against its client's limits setting the
+// X-RateLimit headers for the per minute limit and X-Quota headers for the daily
+// quota.  Requests over either are refused with 429 Too Many Requests.  It
+// should run after the auth middleware so clients can be told apart by key.
+func (l *Limiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l.Charge(c, 1) {
+			c.Next()
+		}
+	}
+}
+
+// Charge counts n requests for the client making a request, e.g. the operations
+// of a batch.  It refuses them all with 429 Too Many Requests and returns false
+// if they would put the client over its limits.
+func (l *Limiter) Charge(c *gin.Context, n int) bool {
+	if !l.cfg.Enabled || n <= 0 {
+		return true
+	}
+	key, limits := l.client(c.Request.Context(), c.ClientIP())
+	r := l.take(key, limits, n)
+	if limits.PerMinute > 0 {
+		c.Header("X-RateLimit-Limit", strconv.Itoa(limits.PerMinute))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining(limits.PerMinute, r.minute.count)))
+		c.Header("X-RateLimit-Reset", strconv.FormatInt(r.minute.start.Add(time.Minute).Unix(), 10))
+	}
+	if limits.Daily > 0 {
+		c.Header("X-Quota-Limit", strconv.Itoa(limits.Daily))
+		c.Header("X-Quota-Remaining", strconv.Itoa(remaining(limits.Daily, r.day.count)))
+		c.Header("X-Quota-Reset", strconv.FormatInt(r.day.start.AddDate(0, 0, 1).Unix(), 10))
+	}
+	if r.allowed {
+		return true
+	}
+	secs := int(r.retry.Sub(l.now()).Seconds() + 0.999)
+	if secs < 1 {
+		secs = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(secs))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+		"error":  exceeded(limits, r, n),
+		"status": http.StatusTooManyRequests,
+	})
+	return false
+}
+
+// Allow counts n requests for the caller identified in ctx, or by IP address
+// for anonymous callers, e.g. the operations sent over a WebSocket.  It returns
+// an error naming the limit if they would put the caller over its limits.
+func (l *Limiter) Allow(ctx context.Context, ip string, n int) error {
+	if !l.cfg.Enabled || n <= 0 {
+		return nil
+	}
+	key, limits := l.client(ctx, ip)
+	if r := l.take(key, limits, n); !r.allowed {
+		return errors.New(exceeded(limits, r, n))
+	}
+	return nil
+}
+
+// exceeded describes the limit which refused n requests
+func exceeded(limits Limits, r result, n int) string {
+	if limits.Daily > 0 && r.day.count+n > limits.Daily {
+		return fmt.Sprintf("daily quota of %d requests exceeded", limits.Daily)
+	}
+	return fmt.Sprintf("rate limit of %d requests per minute exceeded", limits.PerMinute)
+}
+
+// client returns the key counting a caller and the limits which apply to it
+func (l *Limiter) client(ctx context.Context, ip string) (string, Limits) {
+	key := "ip:" + ip
+	names := []string{ip}
+	if id, ok := auth.FromContext(ctx); ok && id.Name != auth.Anonymous {
+		key, names = "user:"+id.Name, append([]string{id.Name}, names...)
+		if id.Key != "" {
+			key, names = "key:"+id.Key, append([]string{id.Key}, names...)
+		}
+	}
+	for _, n := range names {
+		if limits, ok := l.cfg.Clients[n]; ok {
+			return key, limits
+		}
+	}
+	return key, l.cfg.Limits
+}
+
+// take counts n requests for a client unless they put it over its limits
+func (l *Limiter) take(key string, limits Limits, n int) result {
+	now := l.now()
+	minute := now.Truncate(time.Minute)
+	y, m, d := now.UTC().Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(minute, day)
+	cl := l.clients[key]
+	if cl == nil {
+		cl = &client{}
+		l.clients[key] = cl
+	}
+	if !cl.minute.start.Equal(minute) {
+		cl.minute = window{start: minute}
+	}
+	if !cl.day.start.Equal(day) {
+		cl.day = window{start: day}
+	}
+	cl.daily = limits.Daily > 0
+	var r result
+	switch {
+	case limits.Daily > 0 && cl.day.count+n > limits.Daily:
+		r.retry = day.AddDate(0, 0, 1)
+	case limits.PerMinute > 0 && cl.minute.count+n > limits.PerMinute:
+		r.retry = minute.Add(time.Minute)
+	default:
+		r.allowed = true
+		cl.minute.count += n
+		cl.day.count += n
+	}
+	r.minute, r.day = cl.minute, cl.day
+	return r
+}
+
+// sweep forgets clients whose counts have all expired at most once a minute.
+// Callers must hold the lock.
+func (l *Limiter) sweep(minute, day time.Time) {
+	if !minute.After(l.swept) {
+		return
+	}
+	l.swept = minute
+	for k, cl := range l.clients {
+		if cl.minute.start.Before(minute) && (cl.day.start.Before(day) || !cl.daily) {
+			delete(l.clients, k)
+		}
+	}
+}
+
+func remaining(limit, count int) int {
+	if count >= limit {
+		return 0
+	}
+	return limit - count
+}
@@ -0,0 +1,186 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/littlebunch/fdc-graphql/auth"
+)
+
+// clock is a time moved on by tests
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func newLimiter(cfg Config) (*Limiter, *clock) {
+	cfg.Enabled = true
+	c := &clock{t: time.Date(2026, 10, 18, 23, 58, 30, 0, time.UTC)}
+	l := New(cfg)
+	l.now = c.now
+	return l, c
+}
+
+// allowed returns how many of n single requests by a caller are allowed
+func allowed(l *Limiter, ctx context.Context, ip string, n int) int {
+	ok := 0
+	for i := 0; i < n; i++ {
+		if l.Allow(ctx, ip, 1) == nil {
+			ok++
+		}
+	}
+	return ok
+}
+
+func TestMinuteWindow(t *testing.T) {
+	l, c := newLimiter(Config{Limits: Limits{PerMinute: 3}})
+	ctx := context.Background()
+	if n := allowed(l, ctx, "10.0.0.1", 5); n != 3 {
+		t.Errorf("%d allowed, want 3", n)
+	}
+	r := l.take("ip:10.0.0.1", l.cfg.Limits, 1)
+	if r.allowed || !r.retry.Equal(time.Date(2026, 10, 18, 23, 59, 0, 0, time.UTC)) {
+		t.Errorf("allowed %v retry at %v", r.allowed, r.retry)
+	}
+	if n := allowed(l, ctx, "10.0.0.2", 1); n != 1 {
+		t.Error("another client is limited")
+	}
+	c.t = c.t.Add(29 * time.Second)
+	if n := allowed(l, ctx, "10.0.0.1", 1); n != 0 {
+		t.Error("allowed before the minute ended")
+	}
+	c.t = c.t.Add(time.Second)
+	if n := allowed(l, ctx, "10.0.0.1", 5); n != 3 {
+		t.Errorf("%d allowed in the next minute, want 3", n)
+	}
+}
+
+func TestDailyQuota(t *testing.T) {
+	l, c := newLimiter(Config{Limits: Limits{PerMinute: 2, Daily: 3}})
+	ctx := context.Background()
+	if n := allowed(l, ctx, "10.0.0.1", 3); n != 2 {
+		t.Errorf("%d allowed, want 2", n)
+	}
+	c.t = c.t.Add(time.Minute)
+	if n := allowed(l, ctx, "10.0.0.1", 3); n != 1 {
+		t.Errorf("%d allowed in the next minute, want 1", n)
+	}
+	err := l.Allow(ctx, "10.0.0.1", 1)
+	if err == nil || err.Error() != "daily quota of 3 requests exceeded" {
+		t.Errorf("error %v", err)
+	}
+	// the quota resets at midnight UTC
+	c.t = time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	if n := allowed(l, ctx, "10.0.0.1", 3); n != 2 {
+		t.Errorf("%d allowed the next day, want 2", n)
+	}
+}
+
+// charge runs Charge for n requests from an IP returning the response
+func charge(l *Limiter, ctx context.Context, n int) (*httptest.ResponseRecorder, bool) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/graphql", nil).WithContext(ctx)
+	ok := l.Charge(c, n)
+	return w, ok
+}
+
+func TestCharge(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	l, c := newLimiter(Config{Limits: Limits{PerMinute: 5, Daily: 100}})
+	ctx := context.Background()
+	w, ok := charge(l, ctx, 3)
+	h := w.Header()
+	if !ok || h.Get("X-RateLimit-Limit") != "5" || h.Get("X-RateLimit-Remaining") != "2" || h.Get("X-Quota-Remaining") != "97" {
+		t.Errorf("batch of 3: allowed %v headers %v", ok, h)
+	}
+	minute, day := time.Date(2026, 10, 18, 23, 59, 0, 0, time.UTC).Unix(), time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC).Unix()
+	if h.Get("X-RateLimit-Reset") != strconv.FormatInt(minute, 10) || h.Get("X-Quota-Reset") != strconv.FormatInt(day, 10) {
+		t.Errorf("reset headers %s %s", h.Get("X-RateLimit-Reset"), h.Get("X-Quota-Reset"))
+	}
+	// a batch over the limit is refused whole and not counted
+	w, ok = charge(l, ctx, 3)
+	if ok || w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "30" || w.Header().Get("X-RateLimit-Remaining") != "2" {
+		t.Errorf("batch over the limit: allowed %v status %d headers %v", ok, w.Code, w.Header())
+	}
+	if _, ok = charge(l, ctx, 2); !ok {
+		t.Error("batch within the limit refused")
+	}
+	if w, ok = charge(l, ctx, 0); !ok || w.Header().Get("X-RateLimit-Limit") != "" {
+		t.Error("an empty batch is counted")
+	}
+	c.t = c.t.Add(time.Minute)
+	if _, ok = charge(l, ctx, 5); !ok {
+		t.Error("batch in the next minute refused")
+	}
+	l.cfg.Enabled = false
+	if _, ok = charge(l, ctx, 1000); !ok {
+		t.Error("disabled limiter refused a batch")
+	}
+}
+
+func TestClientOverrides(t *testing.T) {
+	l, _ := newLimiter(Config{
+		Limits: Limits{PerMinute: 1},
+		Clients: map[string]Limits{
+			"abc123":   {PerMinute: 2},
+			"ann":      {PerMinute: 3},
+			"10.0.0.9": {PerMinute: 4},
+		},
+	})
+	withKey := auth.NewContext(context.Background(), auth.Identity{Name: "ann", Role: auth.Reader, Key: "abc123"})
+	withOtherKey := auth.NewContext(context.Background(), auth.Identity{Name: "ann", Role: auth.Reader, Key: "def456"})
+	ann := auth.NewContext(context.Background(), auth.Identity{Name: "ann", Role: auth.Reader})
+	bob := auth.NewContext(context.Background(), auth.Identity{Name: "bob", Role: auth.Reader})
+	cat := auth.NewContext(context.Background(), auth.Identity{Name: "cat", Role: auth.Reader})
+	anonymous := auth.NewContext(context.Background(), auth.Identity{Name: auth.Anonymous, Role: auth.Reader})
+	for _, tc := range []struct {
+		name string
+		ctx  context.Context
+		ip   string
+		want int
+	}{
+		{"key before user and IP", withKey, "10.0.0.9", 2},
+		{"user's other key", withOtherKey, "10.0.0.9", 3},
+		{"user before IP", ann, "10.0.0.9", 3},
+		{"IP of a user", bob, "10.0.0.9", 4},
+		{"IP", context.Background(), "10.0.0.9", 4},
+		// anonymous callers share the count of their IP
+		{"anonymous IP", anonymous, "10.0.0.9", 0},
+		{"default", cat, "10.0.0.1", 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if n := allowed(l, tc.ctx, tc.ip, 10); n != tc.want {
+				t.Errorf("%d allowed, want %d", n, tc.want)
+			}
+		})
+	}
+}
+
+func TestSweep(t *testing.T) {
+	l, c := newLimiter(Config{Limits: Limits{PerMinute: 10}, Clients: map[string]Limits{"10.0.0.2": {Daily: 10}}})
+	ctx := context.Background()
+	allowed(l, ctx, "10.0.0.1", 1)
+	allowed(l, ctx, "10.0.0.2", 1)
+	c.t = c.t.Add(time.Minute)
+	allowed(l, ctx, "10.0.0.3", 1)
+	if _, ok := l.clients["ip:10.0.0.1"]; ok {
+		t.Error("client without a daily quota kept after its minute")
+	}
+	if _, ok := l.clients["ip:10.0.0.2"]; !ok {
+		t.Error("client with a daily quota forgotten within the day")
+	}
+	// the next day forgets the quota once its minute has passed too
+	c.t = c.t.Add(24 * time.Hour)
+	allowed(l, ctx, "10.0.0.4", 1)
+	if len(l.clients) != 1 {
+		t.Errorf("%d clients kept, want 1", len(l.clients))
+	}
+	if n := allowed(l, ctx, "10.0.0.2", 20); n != 10 {
+		t.Errorf("%d allowed after the sweep, want 10", n)
+	}
+}
@@ -0,0 +1,176 @@
+package resolvers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/graphql-go/graphql"
+	"github.com/littlebunch/fdc-graphql/datastore"
+	"github.com/littlebunch/fdc-graphql/utils"
+)
+
+// cursor is the opaque position of an edge in a connection.  Browse cursors
+// hold the sort key of the edge's food so paging is stable while data changes.
+// Search cursors and browse cursors sorted by a nutrient hold the edge's offset.
+type cursor struct {
+	Sort   string         `json:"s,omitempty"`
+	Order  string         `json:"o,omitempty"`
+	Key    *datastore.Key `json:"k,omitempty"`
+	Offset int            `json:"n,omitempty"`
+}
+
+func (c cursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// decodeCursor decodes the after argument if one was sent
+func decodeCursor(p graphql.ResolveParams) (*cursor, error) {
+	var c cursor
+	s, ok := p.Args["after"].(string)
+	if !ok || s == "" {
+		return nil, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(b, &c)
+	}
+	if err != nil {
+		return nil, errors.New("invalid cursor in the after parameter")
+	}
+	return &c, nil
+}
+
+// first returns the number of edges requested defaulting to max
+func first(p graphql.ResolveParams, max int, errs *error) int {
+	n, ok := p.Args["first"].(int)
+	if !ok || n <= 0 {
+		return max
+	}
+	if n > utils.MAXPAGE {
+		utils.Seterror(errs, fmt.Sprintf("first parameter cannot exceed %d", utils.MAXPAGE))
+		n = utils.MAXPAGE
+	}
+	return n
+}
+
+// connection creates a connection object for a list of edges
+func connection(edges []interface{}, hasNext, hasPrevious bool, total int) map[string]interface{} {
+	pageInfo := map[string]interface{}{
+		"hasNextPage":     hasNext,
+		"hasPreviousPage": hasPrevious,
+	}
+	if len(edges) > 0 {
+		pageInfo["startCursor"] = edges[0].(map[string]interface{})["cursor"]
+		pageInfo["endCursor"] = edges[len(edges)-1].(map[string]interface{})["cursor"]
+	}
+	return map[string]interface{}{
+		"edges":      edges,
+		"pageInfo":   pageInfo,
+		"totalCount": total,
+	}
+}
+
+// field returns the value of a field in a food returned by a datastore as a string
+func field(node interface{}, name string) string {
+	m, ok := node.(map[string]interface{})
+	if !ok {
+		b, _ := json.Marshal(node)
+		json.Unmarshal(b, &m)
+	}
+	if v, ok := m[name]; ok && v != nil {
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+//FoodsBrowseConnection queries a page of foods based on a Browse object returning a Relay connection
+func (r *Resolver) FoodsBrowseConnection(p graphql.ResolveParams) (interface{}, error) {
+	var edges []interface{}
+	br, errs := utils.Browsequery(p)
+	n := first(p, int(br.Max), &errs)
+	c, err := decodeCursor(p)
+	if err != nil {
+		return nil, err
+	}
+	sort := br.Sort
+	if ns := br.NutrientSort; ns != nil {
+		sort = fmt.Sprintf("nutrient:%d:%t", ns.Nutrientno, ns.PerServing)
+	}
+	if c != nil {
+		if c.Sort != sort || c.Order != br.Order || (c.Key == nil) != (br.NutrientSort == nil) {
+			return nil, errors.New("the after cursor does not belong to this sort")
+		}
+		if c.Key != nil {
+			br.After = c.Key
+			br.Offset = 0
+		} else {
+			br.Offset = int64(c.Offset + 1)
+		}
+	}
+	total, err := r.Ds.BrowseCount(br)
+	if err != nil {
+		return nil, err
+	}
+	br.Max = int64(n + 1)
+	rs, err := r.Ds.Browse(br)
+	if err != nil {
+		return nil, err
+	}
+	hasNext := len(rs) > n
+	if hasNext {
+		rs = rs[:n]
+	}
+	r.prime(p, rs)
+	for i, node := range rs {
+		ec := cursor{Sort: sort, Order: br.Order}
+		if br.NutrientSort != nil {
+			ec.Offset = int(br.Offset) + i
+		} else {
+			ec.Key = &datastore.Key{Value: field(node, br.Sort), FdcID: field(node, "fdcId")}
+		}
+		edges = append(edges, map[string]interface{}{
+			"node":   node,
+			"cursor": ec.encode(),
+		})
+	}
+	return connection(edges, hasNext, c != nil || br.Offset > 0, total), errs
+}
+
+//FoodSearchConnection queries a SearchRequest returning a Relay connection
+func (r *Resolver) FoodSearchConnection(p graphql.ResolveParams) (interface{}, error) {
+	var (
+		sr    datastore.SearchRequest
+		edges []interface{}
+		rs    []interface{}
+		errs  error
+	)
+	sr, errs = utils.Searchquery(p)
+	ns, desc := utils.Searchsort(p, &errs)
+	sr.Max = first(p, sr.Max, &errs)
+	c, err := decodeCursor(p)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		sr.Page = c.Offset + 1
+	}
+	total, err := r.search(p.Context, sr, ns, desc, &rs)
+	if err != nil {
+		return nil, err
+	}
+	for i, node := range rs {
+		edges = append(edges, map[string]interface{}{
+			"node":   node,
+			"cursor": cursor{Offset: sr.Page + i}.encode(),
+		})
+	}
+	// only the ranked hits of a nutrient sorted search can be paged through
+	last := total
+	if ns != nil && last > utils.MAXRANK {
+		last = utils.MAXRANK
+	}
+	return connection(edges, sr.Page+len(rs) < last, sr.Page > 0, total), errs
+}
@@ -0,0 +1,35 @@
+package resolvers
+
+import (
+	"github.com/graphql-go/graphql"
+	fdc "github.com/littlebunch/fdc-api/model"
+	"github.com/littlebunch/fdc-graphql/dailyvalue"
+)
+
+//PercentDailyValue resolves a nutrient value as a percent of its daily value in the
+//profile argument.  Recipe nutrients use the amount in one serving of the recipe.
+func (r *Resolver) PercentDailyValue(p graphql.ResolveParams) (interface{}, error) {
+	var (
+		nd    fdc.NutrientData
+		value float64
+	)
+	profile, _ := p.Args["profile"].(string)
+	dv, err := dailyvalue.Lookup(profile)
+	if err != nil {
+		return nil, err
+	}
+	switch n := p.Source.(type) {
+	case fdc.NutrientData:
+		nd, value = n, value64(n.Value)
+	case scaledNutrient:
+		nd, value = n.nd, value64(n.nd.Value)
+	case *recipeNutrient:
+		nd, value = fdc.NutrientData{Nutrientno: n.Nutrientno, Unit: n.Unit}, n.PerServing
+	default:
+		return nil, nil
+	}
+	if pct, ok := dv.Percent(nd.Nutrientno, value, nd.Unit); ok {
+		return pct, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,41 @@
+package resolvers
+
+import (
+	"fmt"
+
+	"github.com/graphql-go/graphql"
+	"github.com/littlebunch/fdc-graphql/datastore"
+	"github.com/littlebunch/fdc-graphql/label"
+	"github.com/littlebunch/fdc-graphql/utils"
+)
+
+//Label builds the Nutrition Facts label for a serving of a food.  It returns nil
+//if the food is not found.
+func (r *Resolver) Label(fdcid string, serving int) (*label.Label, error) {
+	if !utils.ValidFdcid(fdcid) {
+		return nil, fmt.Errorf("invalid fdcId %q", fdcid)
+	}
+	rs, err := r.Ds.Browse(datastore.BrowseRequest{FdcIDs: []string{fdcid}, Max: 1, Sort: "fdcId", Order: "ASC"})
+	if err != nil || len(rs) == 0 {
+		return nil, err
+	}
+	nutdata, err := r.Ds.NutrientData([]string{fdcid}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return label.New(foodOf(rs[0]), nutdata, serving)
+}
+
+//NutritionLabel queries the Nutrition Facts label for a serving of a food
+func (r *Resolver) NutritionLabel(p graphql.ResolveParams) (interface{}, error) {
+	id := p.Args["fdcId"].(string)
+	serving, _ := p.Args["servingIndex"].(int)
+	l, err := r.Label(id, serving)
+	if err == nil && l == nil {
+		err = fmt.Errorf("food %s not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,149 @@
+package resolvers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	fdc "github.com/littlebunch/fdc-api/model"
+	"github.com/littlebunch/fdc-graphql/datastore"
+)
+
+type loadersKey struct{}
+
+// Loaders are the request scoped data loaders used by the resolvers.  Each
+// loader coalesces the fdcIds asked for while a level of the query is being
+// resolved into one batched datastore call and memoizes the results for the
+// rest of the request.
+type Loaders struct {
+	mu        sync.Mutex
+	foods     *loader
+	nutrients map[string]*loader
+	rankings  map[string]*ranking
+}
+
+// loader batches and caches lookups by key.  Resolvers call load which queues
+// the key and returns a thunk; graphql-go calls the thunks only after every
+// item in a list has been resolved so the first thunk fetches the whole batch.
+type loader struct {
+	mu      sync.Mutex
+	fetch   func(keys []string) (map[string]interface{}, error)
+	cache   map[string]*result
+	pending []string
+}
+
+// result is the memoized value for a key
+type result struct {
+	value interface{}
+	err   error
+	done  bool
+}
+
+// NewContext returns a context carrying a fresh set of Loaders.  Call it once
+// per request and pass the result as graphql.Params.Context.
+func NewContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, loadersKey{}, &Loaders{nutrients: make(map[string]*loader)})
+}
+
+// loaders returns the Loaders in a context or nil if there are none
+func loaders(ctx context.Context) *Loaders {
+	if ctx == nil {
+		return nil
+	}
+	l, _ := ctx.Value(loadersKey{}).(*Loaders)
+	return l
+}
+
+func newLoader(fetch func(keys []string) (map[string]interface{}, error)) *loader {
+	return &loader{fetch: fetch, cache: make(map[string]*result)}
+}
+
+// load queues a key for the next batch unless it has been seen before and
+// returns a thunk resolving its value
+func (l *loader) load(key string) func() (interface{}, error) {
+	l.mu.Lock()
+	r, ok := l.cache[key]
+	if !ok {
+		r = &result{}
+		l.cache[key] = r
+		l.pending = append(l.pending, key)
+	}
+	l.mu.Unlock()
+	return func() (interface{}, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if !r.done {
+			l.dispatch()
+		}
+		return r.value, r.err
+	}
+}
+
+// prime caches a value fetched by some other query
+func (l *loader) prime(key string, value interface{}) {
+	l.mu.Lock()
+	if _, ok := l.cache[key]; !ok {
+		l.cache[key] = &result{value: value, done: true}
+	}
+	l.mu.Unlock()
+}
+
+// dispatch fetches the pending keys.  Callers must hold the lock.
+func (l *loader) dispatch() {
+	keys := l.pending
+	l.pending = nil
+	values, err := l.fetch(keys)
+	for _, k := range keys {
+		r := l.cache[k]
+		r.value, r.err, r.done = values[k], err, true
+	}
+}
+
+// food returns the loader for foods by fdcId
+func (ls *Loaders) food(ds datastore.Datastore) *loader {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.foods == nil {
+		ls.foods = newLoader(func(keys []string) (map[string]interface{}, error) {
+			values := make(map[string]interface{})
+			rs, err := ds.Browse(datastore.BrowseRequest{FdcIDs: keys, Max: int64(len(keys)), Sort: "fdcId", Order: "ASC"})
+			for _, f := range rs {
+				values[field(f, "fdcId")] = f
+			}
+			return values, err
+		})
+	}
+	return ls.foods
+}
+
+// nutrientData returns the loader for a food's nutrient values limited to a list of nutrient numbers
+func (ls *Loaders) nutrientData(ds datastore.Datastore, nutids []int) *loader {
+	key := fmt.Sprint(nutids)
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.nutrients[key] == nil {
+		ls.nutrients[key] = newLoader(func(keys []string) (map[string]interface{}, error) {
+			values := make(map[string]interface{})
+			nutdata, err := ds.NutrientData(keys, nutids)
+			for _, n := range nutdata {
+				v, _ := values[n.FdcID].([]fdc.NutrientData)
+				values[n.FdcID] = append(v, n)
+			}
+			return values, err
+		})
+	}
+	return ls.nutrients[key]
+}
+
+// ranking returns the ranking of a nutrient sorted search identified by key
+func (ls *Loaders) ranking(key string) *ranking {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.rankings == nil {
+		ls.rankings = make(map[string]*ranking)
+	}
+	if ls.rankings[key] == nil {
+		ls.rankings[key] = &ranking{}
+	}
+	return ls.rankings[key]
+}
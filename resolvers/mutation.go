@@ -0,0 +1,249 @@
+package resolvers
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	fdc "github.com/littlebunch/fdc-api/model"
+	"github.com/littlebunch/fdc-graphql/auth"
+	"github.com/littlebunch/fdc-graphql/datastore"
+	"github.com/littlebunch/fdc-graphql/events"
+	"github.com/littlebunch/fdc-graphql/utils"
+)
+
+// customIDs is the number of distinct user defined fdcIds
+var customIDs = big.NewInt(1e12)
+
+//CreateFood stores a new user defined food.  Mutations require the editor role.
+func (r *Resolver) CreateFood(p graphql.ResolveParams) (interface{}, error) {
+	var f fdc.Food
+	w, err := r.writer(p)
+	if err != nil {
+		return nil, err
+	}
+	in := p.Args["food"].(map[string]interface{})
+	if d, _ := in["foodDescription"].(string); strings.TrimSpace(d) == "" {
+		return nil, errors.New("foodDescription is required")
+	}
+	if f.FdcID, err = r.newID(); err != nil {
+		return nil, err
+	}
+	f.Type, f.Source = datastore.CUSTOM, datastore.CUSTOM
+	nd, _, err := r.applyFood(&f, in)
+	if err != nil {
+		return nil, err
+	}
+	f.PublicationDate = time.Now().UTC()
+	if err = w.PutFood(f, nd); err != nil {
+		return nil, err
+	}
+	r.Events.Publish(events.Event{Type: events.FoodChanged, FdcID: f.FdcID})
+	return f, nil
+}
+
+//UpdateFood changes the fields of a user defined food given in the food argument.
+//Nutrient values are replaced when nutrients is given.
+func (r *Resolver) UpdateFood(p graphql.ResolveParams) (interface{}, error) {
+	w, err := r.writer(p)
+	if err != nil {
+		return nil, err
+	}
+	f, err := r.customFood(p.Args["fdcId"].(string))
+	if err != nil {
+		return nil, err
+	}
+	nd, replace, err := r.applyFood(&f, p.Args["food"].(map[string]interface{}))
+	if err != nil {
+		return nil, err
+	}
+	if !replace {
+		if nd, err = r.Ds.NutrientData([]string{f.FdcID}, nil); err != nil {
+			return nil, err
+		}
+	}
+	f.PublicationDate = time.Now().UTC()
+	if err = w.PutFood(f, nd); err != nil {
+		return nil, err
+	}
+	r.Events.Publish(events.Event{Type: events.FoodChanged, FdcID: f.FdcID})
+	return f, nil
+}
+
+//DeleteFood removes a user defined food returning its fdcId
+func (r *Resolver) DeleteFood(p graphql.ResolveParams) (interface{}, error) {
+	w, err := r.writer(p)
+	if err != nil {
+		return nil, err
+	}
+	f, err := r.customFood(p.Args["fdcId"].(string))
+	if err != nil {
+		return nil, err
+	}
+	if err = w.DeleteFood(f.FdcID); err != nil {
+		return nil, err
+	}
+	r.Events.Publish(events.Event{Type: events.FoodDeleted, FdcID: f.FdcID})
+	return f.FdcID, nil
+}
+
+// writer returns the datastore as a datastore.Writer if the caller has the
+// editor role
+func (r *Resolver) writer(p graphql.ResolveParams) (datastore.Writer, error) {
+	if err := auth.Require(p.Context, auth.Editor); err != nil {
+		return nil, err
+	}
+	w, ok := r.Ds.(datastore.Writer)
+	if !ok {
+		return nil, errors.New("the datastore does not support user defined foods")
+	}
+	return w, nil
+}
+
+// customFood reads a user defined food.  FDC foods cannot be changed.
+func (r *Resolver) customFood(id string) (fdc.Food, error) {
+	if !utils.ValidFdcid(id) || !datastore.IsCustom(id) {
+		return fdc.Food{}, fmt.Errorf("%s is not the fdcId of a user defined food", id)
+	}
+	rs, err := r.Ds.Browse(datastore.BrowseRequest{FdcIDs: []string{id}, Max: 1, Sort: "fdcId", Order: "ASC"})
+	if err != nil {
+		return fdc.Food{}, err
+	}
+	if len(rs) == 0 {
+		return fdc.Food{}, fmt.Errorf("food %s not found", id)
+	}
+	f := foodOf(rs[0])
+	if f.Type != datastore.CUSTOM {
+		return fdc.Food{}, fmt.Errorf("%s is not the fdcId of a user defined food", id)
+	}
+	return f, nil
+}
+
+// newID returns an unused fdcId for a user defined food
+func (r *Resolver) newID() (string, error) {
+	for i := 0; i < 10; i++ {
+		n, err := rand.Int(rand.Reader, customIDs)
+		if err != nil {
+			return "", err
+		}
+		id := fmt.Sprintf("%s%012d", datastore.CustomPrefix, n)
+		rs, err := r.Ds.Browse(datastore.BrowseRequest{FdcIDs: []string{id}, Max: 1, Sort: "fdcId", Order: "ASC"})
+		if err != nil {
+			return "", err
+		}
+		if len(rs) == 0 {
+			return id, nil
+		}
+	}
+	return "", errors.New("cannot find an unused fdcId")
+}
+
+// applyFood sets the fields of a food from a foodInput argument.  It returns the
+// food's nutrient values and whether they were given.
+func (r *Resolver) applyFood(f *fdc.Food, in map[string]interface{}) ([]fdc.NutrientData, bool, error) {
+	var (
+		dt   *fdc.DocType
+		nd   []fdc.NutrientData
+		errs error
+	)
+	for name, field := range map[string]*string{
+		"foodDescription": &f.Description,
+		"upc":             &f.Upc,
+		"ingredients":     &f.Ingredients,
+		"company":         &f.Manufacturer,
+	} {
+		if v, ok := in[name].(string); ok {
+			*field = strings.TrimSpace(v)
+		}
+	}
+	if f.Description == "" {
+		utils.Seterror(&errs, "foodDescription cannot be empty")
+	}
+	if v, ok := in["category"].(string); ok {
+		f.Group = nil
+		if v = strings.TrimSpace(v); v != "" {
+			f.Group = &fdc.FoodGroup{Description: v, Type: dt.ToString(fdc.FGGPC)}
+		}
+	}
+	if v, ok := in["servingSizes"].([]interface{}); ok {
+		f.Servings = nil
+		for i, s := range v {
+			m := s.(map[string]interface{})
+			sv := fdc.Serving{Nutrientbasis: "g"}
+			if b, ok := m["nutrientBasis"].(string); ok && b != "" {
+				sv.Nutrientbasis = b
+			}
+			if sv.Nutrientbasis != "g" && sv.Nutrientbasis != "ml" {
+				utils.Seterror(&errs, fmt.Sprintf("nutrientBasis of serving %d must be g or ml", i))
+			}
+			sv.Description, _ = m["servingUnit"].(string)
+			weight, _ := m["weight"].(float64)
+			value, _ := m["value"].(float64)
+			if weight <= 0 {
+				utils.Seterror(&errs, fmt.Sprintf("weight of serving %d must be greater than 0", i))
+			}
+			sv.Weight, sv.Servingamount = float32(weight), float32(value)
+			f.Servings = append(f.Servings, sv)
+		}
+	}
+	v, replace := in["nutrients"].([]interface{})
+	if replace {
+		dict, err := r.dictionary()
+		if err != nil {
+			return nil, false, err
+		}
+		seen := make(map[uint]bool)
+		for _, n := range v {
+			m := n.(map[string]interface{})
+			no := uint(m["nutrientno"].(int))
+			value := m["value"].(float64)
+			nut, ok := dict[no]
+			switch {
+			case !ok:
+				utils.Seterror(&errs, fmt.Sprintf("unknown nutrient %d", no))
+				continue
+			case seen[no]:
+				utils.Seterror(&errs, fmt.Sprintf("nutrient %d is given more than once", no))
+				continue
+			case value < 0:
+				utils.Seterror(&errs, fmt.Sprintf("value of nutrient %d cannot be negative", no))
+				continue
+			}
+			seen[no] = true
+			nd = append(nd, fdc.NutrientData{
+				FdcID:      f.FdcID,
+				Source:     datastore.CUSTOM,
+				Type:       dt.ToString(fdc.NUTDATA),
+				Value:      float32(value),
+				Unit:       nut.Unit,
+				Nutrientno: no,
+				Nutrient:   nut.Name,
+			})
+		}
+	}
+	return nd, replace, errs
+}
+
+// dictionary returns the nutrient dictionary keyed by nutrient number
+func (r *Resolver) dictionary() (map[uint]fdc.Nutrient, error) {
+	var dt *fdc.DocType
+	rs, err := r.Ds.GetDictionary(dt.ToString(fdc.NUT), 0, 1000)
+	if err != nil {
+		return nil, err
+	}
+	dict := make(map[uint]fdc.Nutrient)
+	for _, node := range rs {
+		n, ok := node.(fdc.Nutrient)
+		if !ok {
+			b, _ := json.Marshal(node)
+			json.Unmarshal(b, &n)
+		}
+		dict[n.Nutrientno] = n
+	}
+	return dict, nil
+}
@@ -0,0 +1,126 @@
+package resolvers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	fdc "github.com/littlebunch/fdc-api/model"
+	"github.com/littlebunch/fdc-graphql/datastore"
+	"github.com/littlebunch/fdc-graphql/utils"
+)
+
+// search runs a SearchRequest putting a page of hits into rs and returning the
+// total hit count.  The full-text indexes cannot order hits by nutrient values so
+// a nutrient sorted search ranks the first utils.MAXRANK hits and pages through
+// those.  The total still counts every hit.  The ranking is kept for the rest of
+// the request so other pages of the same search reuse it.
+func (r *Resolver) search(ctx context.Context, sr datastore.SearchRequest, ns *datastore.NutrientSort, desc bool, rs *[]interface{}) (int, error) {
+	if ns == nil {
+		return r.Ds.Search(sr, rs)
+	}
+	page, max := sr.Page, sr.Max
+	sr.Page, sr.Max = 0, utils.MAXRANK
+	rk := &ranking{}
+	if l := loaders(ctx); l != nil {
+		rk = l.ranking(fmt.Sprintf("%v %v %v", sr, *ns, desc))
+	}
+	rk.once.Do(func() {
+		rk.hits, rk.total, rk.err = r.rank(sr, *ns, desc)
+	})
+	if rk.err != nil {
+		return 0, rk.err
+	}
+	for i := page; i < len(rk.hits) && i < page+max; i++ {
+		*rs = append(*rs, rk.hits[i])
+	}
+	return rk.total, nil
+}
+
+// ranking is the hits of a nutrient sorted search in order with the total hit count
+type ranking struct {
+	once  sync.Once
+	hits  []interface{}
+	total int
+	err   error
+}
+
+// rank runs a SearchRequest and orders its hits by a NutrientSort
+func (r *Resolver) rank(sr datastore.SearchRequest, ns datastore.NutrientSort, desc bool) ([]interface{}, int, error) {
+	var hits []interface{}
+	total, err := r.Ds.Search(sr, &hits)
+	if err != nil {
+		return nil, 0, err
+	}
+	values, err := r.sortValues(hits, ns)
+	if err != nil {
+		return nil, 0, err
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		a, aok := values[field(hits[i], "fdcId")]
+		b, bok := values[field(hits[j], "fdcId")]
+		if aok != bok {
+			return aok
+		}
+		if desc {
+			return a > b
+		}
+		return a < b
+	})
+	return hits, total, nil
+}
+
+// sortValues returns the NutrientSort value of each food in a list keyed by fdcId.
+// Foods without a value are left out.
+func (r *Resolver) sortValues(foods []interface{}, ns datastore.NutrientSort) (map[string]float64, error) {
+	values := make(map[string]float64)
+	servings := make(map[string][]fdc.Serving)
+	if len(foods) == 0 {
+		return values, nil
+	}
+	ids := make([]string, len(foods))
+	for i, f := range foods {
+		ids[i] = field(f, "fdcId")
+	}
+	nutdata, err := r.Ds.NutrientData(ids, []int{int(ns.Nutrientno)})
+	if err != nil {
+		return nil, err
+	}
+	if ns.PerServing && len(nutdata) > 0 {
+		// search hits do not carry servings so read the foods with a value
+		ids = ids[:0]
+		for _, n := range nutdata {
+			ids = append(ids, n.FdcID)
+		}
+		rs, err := r.Ds.Browse(datastore.BrowseRequest{FdcIDs: ids, Max: int64(len(ids)), Sort: "fdcId", Order: "ASC"})
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range rs {
+			servings[field(f, "fdcId")] = servingsOf(f)
+		}
+	}
+	for _, n := range nutdata {
+		if v, ok := ns.SortValue(servings[n.FdcID], float64(n.Value)); ok {
+			values[n.FdcID] = v
+		}
+	}
+	return values, nil
+}
+
+// servingsOf returns the servings of a food returned by a datastore
+func servingsOf(node interface{}) []fdc.Serving {
+	return foodOf(node).Servings
+}
+
+// foodOf converts a food returned by a datastore to an fdc.Food
+func foodOf(node interface{}) fdc.Food {
+	f, ok := node.(fdc.Food)
+	if !ok {
+		b, _ := json.Marshal(node)
+		json.Unmarshal(b, &f)
+	}
+	return f
+}
@@ -0,0 +1,89 @@
+package resolvers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/littlebunch/fdc-graphql/datastore"
+)
+
+// crowded is a datastore reporting more search hits than it holds, as one
+// with more hits than utils.MAXRANK would
+type crowded struct {
+	datastore.Datastore
+	more int
+}
+
+func (d crowded) Search(sr datastore.SearchRequest, foods *[]interface{}) (int, error) {
+	n, err := d.Datastore.Search(sr, foods)
+	return n + d.more, err
+}
+
+func sortedSearch(terms string, first int) map[string]interface{} {
+	return map[string]interface{}{
+		"search": map[string]interface{}{"terms": terms, "order": "DESC", "nutrientSort": map[string]interface{}{"nutrientno": 208}},
+		"first":  first,
+	}
+}
+
+func TestNutrientSortedSearch(t *testing.T) {
+	r := testResolver(t)
+	r.Ds = crowded{Datastore: r.Ds, more: 5000}
+	rs, err := r.FoodSearchResult(params(sortedSearch("raw", 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr := rs.(*SearchResult)
+	if got := ids(t, sr.Hits); sr.Total != 5000+len(sr.Hits) || got != "171287,170379" {
+		t.Errorf("total %d hits %s", sr.Total, got)
+	}
+	c, err := r.FoodSearchConnection(params(sortedSearch("raw", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := c.(map[string]interface{})
+	var edges []string
+	for _, e := range conn["edges"].([]interface{}) {
+		edges = append(edges, field(e.(map[string]interface{})["node"], "fdcId"))
+	}
+	if got := strings.Join(edges, ","); got != "171287" {
+		t.Errorf("edges %s, want 171287", got)
+	}
+	if conn["totalCount"] != 5002 || conn["pageInfo"].(map[string]interface{})["hasNextPage"] != true {
+		t.Errorf("totalCount %v pageInfo %v", conn["totalCount"], conn["pageInfo"])
+	}
+}
+
+// counting is a datastore counting its searches
+type counting struct {
+	datastore.Datastore
+	searches int
+}
+
+func (d *counting) Search(sr datastore.SearchRequest, foods *[]interface{}) (int, error) {
+	d.searches++
+	return d.Datastore.Search(sr, foods)
+}
+
+func TestRankingReusedInRequest(t *testing.T) {
+	r := testResolver(t)
+	ds := &counting{Datastore: r.Ds}
+	r.Ds = ds
+	p := params(sortedSearch("raw", 1))
+	p.Context = NewContext(context.Background())
+	for _, want := range []string{"171287", "170379"} {
+		c, err := r.FoodSearchConnection(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		edges := c.(map[string]interface{})["edges"].([]interface{})
+		if got := field(edges[0].(map[string]interface{})["node"], "fdcId"); got != want {
+			t.Errorf("page starts at %s, want %s", got, want)
+		}
+		p.Args["after"] = c.(map[string]interface{})["pageInfo"].(map[string]interface{})["endCursor"]
+	}
+	if ds.searches != 1 {
+		t.Errorf("%d searches, want 1", ds.searches)
+	}
+}
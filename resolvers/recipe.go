@@ -0,0 +1,261 @@
+package resolvers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/graphql-go/graphql"
+	fdc "github.com/littlebunch/fdc-api/model"
+	"github.com/littlebunch/fdc-graphql/datastore"
+	"github.com/littlebunch/fdc-graphql/utils"
+)
+
+// massUnits converts amounts in units of mass to grams
+var massUnits = map[string]float64{
+	"g":  1,
+	"kg": 1000,
+	"mg": 0.001,
+	"oz": 28.349523125,
+	"lb": 453.59237,
+}
+
+// volumeUnits converts amounts in units of volume to ml.  They may only be used
+// for foods whose nutrients are given per 100ml.
+var volumeUnits = map[string]float64{
+	"ml": 1,
+	"l":  1000,
+}
+
+// recipe is the result of a recipe query
+type recipe struct {
+	Servings         int                `json:"servings"`
+	Weight           float64            `json:"weight"`
+	WeightPerServing float64            `json:"weightPerServing"`
+	Nutrients        []*recipeNutrient  `json:"nutrients"`
+	Ingredients      []recipeIngredient `json:"ingredients"`
+}
+
+// recipeNutrient is the total amount of a nutrient in a recipe
+type recipeNutrient struct {
+	Nutrientno uint     `json:"nutrientno"`
+	Nutrient   string   `json:"nutrient"`
+	Unit       string   `json:"unit"`
+	Value      float64  `json:"value"`
+	PerServing float64  `json:"perServing"`
+	Missing    []string `json:"missing"`
+}
+
+// recipeIngredient is an ingredient with its nutrient values scaled to the amount used
+type recipeIngredient struct {
+	FdcID           string        `json:"fdcId"`
+	FoodDescription string        `json:"foodDescription"`
+	Amount          float64       `json:"amount"`
+	Unit            string        `json:"unit"`
+	Weight          float64       `json:"weight"`
+	Nutrients       []interface{} `json:"nutrients"`
+}
+
+//Recipe totals the nutrient values of a list of ingredients
+func (r *Resolver) Recipe(p graphql.ResolveParams) (interface{}, error) {
+	var (
+		rc   recipe
+		ids  []string
+		seen = make(map[string]bool)
+		errs error
+	)
+	args, _ := p.Args["ingredients"].([]interface{})
+	if len(args) == 0 {
+		return nil, fmt.Errorf("a recipe needs at least one ingredient")
+	}
+	if len(args) > utils.MAXIDS {
+		return nil, fmt.Errorf("number of ingredients should not exceed %d", utils.MAXIDS)
+	}
+	rc.Servings = 1
+	if n, ok := p.Args["servings"].(int); ok {
+		if n <= 0 {
+			return nil, fmt.Errorf("servings must be greater than 0")
+		}
+		rc.Servings = n
+	}
+	for _, a := range args {
+		m := a.(map[string]interface{})
+		in := recipeIngredient{FdcID: m["fdcId"].(string), Amount: m["amount"].(float64), Unit: "g"}
+		if u, ok := m["unit"].(string); ok && u != "" {
+			in.Unit = u
+		}
+		if !utils.ValidFdcid(in.FdcID) {
+			utils.Seterror(&errs, fmt.Sprintf("invalid fdcId %q", in.FdcID))
+			continue
+		}
+		if in.Amount <= 0 {
+			utils.Seterror(&errs, fmt.Sprintf("amount of %s must be greater than 0", in.FdcID))
+			continue
+		}
+		rc.Ingredients = append(rc.Ingredients, in)
+		// a food may be listed more than once but its values are read once
+		if !seen[in.FdcID] {
+			seen[in.FdcID] = true
+			ids = append(ids, in.FdcID)
+		}
+	}
+	if errs != nil {
+		return nil, errs
+	}
+	foods, err := r.Ds.Browse(datastore.BrowseRequest{FdcIDs: ids, Max: int64(len(ids)), Sort: "fdcId", Order: "ASC"})
+	if err != nil {
+		return nil, err
+	}
+	r.prime(p, foods)
+	byID := make(map[string]interface{})
+	for _, f := range foods {
+		byID[field(f, "fdcId")] = f
+	}
+	nutdata, err := r.Ds.NutrientData(ids, nutids(p))
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string][]fdc.NutrientData)
+	for _, nd := range nutdata {
+		values[nd.FdcID] = append(values[nd.FdcID], nd)
+	}
+	totals := make(map[uint]*recipeNutrient)
+	for i := range rc.Ingredients {
+		in := &rc.Ingredients[i]
+		f, ok := byID[in.FdcID]
+		if !ok {
+			utils.Seterror(&errs, fmt.Sprintf("food %s not found", in.FdcID))
+			continue
+		}
+		in.FoodDescription = field(f, "foodDescription")
+		b, err := ingredientBasis(in, servingsOf(f))
+		if err != nil {
+			utils.Seterror(&errs, err.Error())
+			continue
+		}
+		in.Weight = b.Amount
+		in.Nutrients = scaled(values[in.FdcID], b)
+		rc.Weight += b.Amount
+		for _, nd := range values[in.FdcID] {
+			t := totals[nd.Nutrientno]
+			if t == nil {
+				t = &recipeNutrient{Nutrientno: nd.Nutrientno, Nutrient: nd.Nutrient, Unit: nd.Unit}
+				totals[nd.Nutrientno] = t
+			}
+			t.Value += value64(nd.Value) * b.Amount / 100
+		}
+	}
+	if errs != nil {
+		return nil, errs
+	}
+	for _, t := range totals {
+		for _, id := range ids {
+			if !hasNutrient(values[id], t.Nutrientno) {
+				t.Missing = append(t.Missing, id)
+			}
+		}
+		t.PerServing = t.Value / float64(rc.Servings)
+		rc.Nutrients = append(rc.Nutrients, t)
+	}
+	sort.Slice(rc.Nutrients, func(i, j int) bool { return rc.Nutrients[i].Nutrientno < rc.Nutrients[j].Nutrientno })
+	rc.WeightPerServing = rc.Weight / float64(rc.Servings)
+	return rc, nil
+}
+
+// ingredientBasis converts the amount of an ingredient into g or ml.  Units of
+// mass and volume are converted directly, "serving" is the food's first serving
+// and any other unit is looked up in the food's servings, e.g. cup or tbsp.
+func ingredientBasis(in *recipeIngredient, servings []fdc.Serving) (basis, error) {
+	b := basis{Unit: "g"}
+	if len(servings) > 0 && servings[0].Nutrientbasis != "" {
+		b.Unit = servings[0].Nutrientbasis
+	}
+	unit := strings.ToLower(strings.TrimSpace(in.Unit))
+	if g, ok := massUnits[unit]; ok && b.Unit == "g" {
+		b.Amount = in.Amount * g
+		return b, nil
+	}
+	if ml, ok := volumeUnits[unit]; ok && b.Unit == "ml" {
+		b.Amount = in.Amount * ml
+		return b, nil
+	}
+	if unit == "serving" {
+		if w, ok := datastore.ServingWeight(servings); ok {
+			b.Amount = in.Amount * w
+			return b, nil
+		}
+		return b, fmt.Errorf("food %s has no serving weight", in.FdcID)
+	}
+	if i := servingUnit(servings, unit); i >= 0 {
+		sv := servings[i]
+		per := float64(sv.Weight)
+		if sv.Servingamount > 0 {
+			per /= float64(sv.Servingamount)
+		}
+		b.Amount, b.Serving, b.ServingUnit, b.ServingValue = in.Amount*per, &i, sv.Description, float32(in.Amount)
+		return b, nil
+	}
+	return b, fmt.Errorf("cannot convert %s of food %s measured in %s", in.Unit, in.FdcID, b.Unit)
+}
+
+// servingUnit returns the index of the serving with a household unit matching
+// unit exactly or by its first word after any amount, e.g. cup matches
+// "cup, chopped", "1 cup, chopped" and "1/2 cups"
+func servingUnit(servings []fdc.Serving, unit string) int {
+	for _, exact := range []bool{true, false} {
+		for i, sv := range servings {
+			d := strings.ToLower(strings.TrimSpace(sv.Description))
+			if sv.Weight <= 0 || d == "" {
+				continue
+			}
+			if d == unit || (!exact && sameUnit(unitWord(d), unit)) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// unitWord returns the first word of a serving description which is not an
+// amount with its punctuation removed
+func unitWord(description string) string {
+	words := strings.FieldsFunc(description, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(",;:()[]", r)
+	})
+	for _, w := range words {
+		if isAmount(w) {
+			continue
+		}
+		return strings.TrimFunc(w, unicode.IsPunct)
+	}
+	return ""
+}
+
+// isAmount reports whether a word is a number such as 1, 1.5, 1/2 or ½
+func isAmount(w string) bool {
+	for _, r := range w {
+		if !unicode.IsNumber(r) && r != '.' && r != '/' {
+			return false
+		}
+	}
+	return true
+}
+
+// sameUnit reports whether two unit words are the same allowing for a plural
+func sameUnit(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	return a == b || strings.TrimSuffix(a, "s") == strings.TrimSuffix(b, "s")
+}
+
+// hasNutrient reports whether a list of nutrient values includes a nutrient
+func hasNutrient(nutdata []fdc.NutrientData, nutrientno uint) bool {
+	for _, nd := range nutdata {
+		if nd.Nutrientno == nutrientno {
+			return true
+		}
+	}
+	return false
+}
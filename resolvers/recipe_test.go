@@ -0,0 +1,80 @@
+package resolvers
+
+import (
+	"testing"
+
+	fdc "github.com/littlebunch/fdc-api/model"
+	"github.com/littlebunch/fdc-graphql/datastore/memory"
+)
+
+func TestServingUnit(t *testing.T) {
+	// descriptions as the loader builds them from SR portions and FNDDS or
+	// branded household servings
+	servings := []fdc.Serving{
+		{Description: "cup, chopped", Weight: 91, Servingamount: 1},
+		{Description: "1 NLEA serving", Weight: 148, Servingamount: 1},
+		{Description: "2 tbsp.", Weight: 30, Servingamount: 2},
+		{Description: "1/2 Slices", Weight: 14, Servingamount: 0.5},
+		{Description: "½ stalk (15g)", Weight: 15, Servingamount: 0.5},
+		{Description: "spear (about 5\" long)", Weight: 31, Servingamount: 1},
+		{Description: "oz", Weight: 0},
+		{Description: "large", Weight: 50, Servingamount: 1},
+	}
+	for _, tc := range []struct {
+		unit string
+		want int
+	}{
+		{"cup", 0},
+		{"cups", 0},
+		{"cup, chopped", 0},
+		{"nlea", 1},
+		{"tbsp", 2},
+		{"slice", 3},
+		{"stalk", 4},
+		{"spear", 5},
+		{"large", 7},
+		{"oz", -1},
+		{"1", -1},
+		{"chopped", -1},
+		{"", -1},
+	} {
+		if got := servingUnit(servings, tc.unit); got != tc.want {
+			t.Errorf("servingUnit(%q) = %d, want %d", tc.unit, got, tc.want)
+		}
+	}
+}
+
+func TestUnitWord(t *testing.T) {
+	for d, want := range map[string]string{
+		"cup, chopped":      "cup",
+		"1 cup, chopped":    "cup",
+		"1.5 oz":            "oz",
+		"1/2 cup":           "cup",
+		"(1 cup)":           "cup",
+		"2 tbsp.":           "tbsp",
+		"1 container (6oz)": "container",
+		"1":                 "",
+		"":                  "",
+	} {
+		if got := unitWord(d); got != want {
+			t.Errorf("unitWord(%q) = %q, want %q", d, got, want)
+		}
+	}
+}
+
+func TestRecipeHouseholdUnits(t *testing.T) {
+	r := testResolver(t)
+	if err := r.Ds.(*memory.Datastore).PutFood(fdc.Food{FdcID: "1", Description: "Spinach, raw", Servings: []fdc.Serving{
+		{Nutrientbasis: "g", Description: "1 cup, chopped", Servingamount: 1, Weight: 30},
+		{Nutrientbasis: "g", Description: "bunch", Servingamount: 1, Weight: 340},
+	}}, []fdc.NutrientData{{FdcID: "1", Nutrientno: 208, Value: 23}}); err != nil {
+		t.Fatal(err)
+	}
+	rs, err := r.Recipe(params(map[string]interface{}{"ingredients": []interface{}{ingredient("1", 2, "cups")}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc := rs.(recipe); !near(rc.Weight, 60) {
+		t.Errorf("weight %g, want 60", rc.Weight)
+	}
+}
@@ -2,25 +2,41 @@ package resolvers
 
 import (
 	"fmt"
-	"strings"
 
 	"github.com/graphql-go/graphql"
-	"github.com/littlebunch/fdc-api/ds/cb"
 	fdc "github.com/littlebunch/fdc-api/model"
+	"github.com/littlebunch/fdc-graphql/datastore"
+	"github.com/littlebunch/fdc-graphql/events"
+	"github.com/littlebunch/fdc-graphql/suggest"
 	"github.com/littlebunch/fdc-graphql/utils"
-	"gopkg.in/couchbase/gocb.v1"
 )
 
-//Resolver type for resolving queries
+//Resolver type for resolving queries.  Changes made by mutations are published
+//to Events for subscriptions.  Suggest, if set, answers foodSuggest queries.
 type Resolver struct {
-	Ds *cb.Cb
-	Cs fdc.Config
+	Ds      datastore.Datastore
+	Events  *events.Bus
+	Suggest *suggest.Index
 }
 
-//Food queries for a single Food by fdcId
+//Food queries for a single Food by fdcId.  Lookups made in the same request are
+//batched and cached by the request's loaders.
 func (r *Resolver) Food(p graphql.ResolveParams) (interface{}, error) {
 	var food fdc.Food
 	food.FdcID = p.Args["id"].(string)
+	if !utils.ValidFdcid(food.FdcID) {
+		return nil, fmt.Errorf("invalid fdcId %q", food.FdcID)
+	}
+	if l := loaders(p.Context); l != nil {
+		thunk := l.food(r.Ds).load(food.FdcID)
+		return func() (interface{}, error) {
+			f, err := thunk()
+			if err == nil && f == nil {
+				err = fmt.Errorf("food %s not found", food.FdcID)
+			}
+			return f, err
+		}, nil
+	}
 	err := r.Ds.Get(food.FdcID, &food)
 	if err != nil {
 		return nil, err
@@ -31,13 +47,12 @@ func (r *Resolver) Food(p graphql.ResolveParams) (interface{}, error) {
 //FoodSearchCount finds the number of hits for a proposed SearchRequest
 func (r *Resolver) FoodSearchCount(p graphql.ResolveParams) (interface{}, error) {
 	var (
-		sr        fdc.SearchRequest
+		sr        datastore.SearchRequest
 		err, errs error
 		c         int
 		rs        []interface{}
 	)
 	sr, errs = utils.Searchquery(p)
-	sr.IndexName = r.Cs.CouchDb.Fts
 	sr.Max = 1
 	if c, err = r.Ds.Search(sr, &rs); err != nil {
 		return nil, err
@@ -48,104 +63,141 @@ func (r *Resolver) FoodSearchCount(p graphql.ResolveParams) (interface{}, error)
 //Foods queries a list of Food objects by a list of fdcIds
 func (r *Resolver) Foods(p graphql.ResolveParams) (interface{}, error) {
 	var (
-		dt   *fdc.DocType
 		s    string
 		errs error
-		fIDs string
+		br   datastore.BrowseRequest
 	)
-	where := fmt.Sprintf("type=\"%s\" ", dt.ToString(fdc.FOOD))
 	if p.Args["fdcids"] != nil {
-		fIDs, s = utils.Fdcids(p.Args["fdcids"].([]interface{}))
+		br.FdcIDs, s = utils.Fdcids(p.Args["fdcids"].([]interface{}))
 		if s != "" {
 			errs = utils.Seterror(&errs, s)
 		}
-		if fIDs != "" {
-			where += fmt.Sprintf("AND fdcId in [%s]", fIDs)
-		}
 	}
-
-	rs, _ := r.Ds.Browse(r.Cs.CouchDb.Bucket, where, int64(0), int64(2), "fdcId", "desc")
+	br.Max = int64(utils.MAXIDS)
+	br.Sort = "fdcId"
+	br.Order = "desc"
+	rs, err := r.Ds.Browse(br)
+	if err != nil {
+		return nil, err
+	}
+	r.prime(p, rs)
 	return rs, errs
 }
 
+// prime caches the foods returned by a list query in the request's food loader
+func (r *Resolver) prime(p graphql.ResolveParams, foods []interface{}) {
+	if l := loaders(p.Context); l != nil {
+		fl := l.food(r.Ds)
+		for _, f := range foods {
+			fl.prime(field(f, "fdcId"), f)
+		}
+	}
+}
+
 //FoodSearch query for a SearchRequest
 func (r *Resolver) FoodSearch(p graphql.ResolveParams) (interface{}, error) {
 	var (
-		sr        fdc.SearchRequest
+		sr        datastore.SearchRequest
 		err, errs error
 		rs        []interface{}
 	)
 	sr, errs = utils.Searchquery(p)
-
-	sr.IndexName = r.Cs.CouchDb.Fts
-	if _, err = r.Ds.Search(sr, &rs); err != nil {
+	ns, desc := utils.Searchsort(p, &errs)
+	if _, err = r.search(p.Context, sr, ns, desc, &rs); err != nil {
 		return nil, err
 	}
 	return rs, errs
 }
 
-//FoodsBrowse queries a list of foods based on a Browse object
-func (r *Resolver) FoodsBrowse(p graphql.ResolveParams) (interface{}, error) {
+//FoodSuggest completes a prefix from the suggest index.  A PREFIX search is
+//run instead while the index is being built or if it is disabled.
+func (r *Resolver) FoodSuggest(p graphql.ResolveParams) (interface{}, error) {
 	var (
-		dt                  *fdc.DocType
-		max, page           int
-		sort, order, source string
-		errs                error
+		errs error
+		hits []interface{}
 	)
-	b := p.Args["browse"].(map[string]interface{})
-	if b["max"] == nil {
-		max = 50
-	} else {
-		max = b["max"].(int)
+	prefix, field, max := p.Args["prefix"].(string), suggest.Description, 10
+	if p.Args["field"] != nil {
+		field = p.Args["field"].(string)
 	}
-	if max > 150 {
-		errs = utils.Seterror(&errs, "cannot return more than 150 items")
+	if field != suggest.Description && field != suggest.Company {
+		return nil, fmt.Errorf("field must be %s or %s", suggest.Description, suggest.Company)
 	}
-	if b["page"] == nil {
-		page = 0
-	} else {
-		page = b["page"].(int)
+	if p.Args["max"] != nil {
+		max = p.Args["max"].(int)
 	}
-	if b["sort"] == nil {
-		sort = "fdcId"
-	} else {
-		sort = b["sort"].(string)
+	if max > suggest.Max {
+		errs = utils.Seterror(&errs, fmt.Sprintf("max cannot exceed %d", suggest.Max))
+		max = suggest.Max
 	}
-	if b["order"] == nil {
-		order = "ASC"
-	} else {
-		order = b["order"].(string)
-	}
-	if b["source"] != nil {
-		source = b["source"].(string)
+	if r.Suggest != nil && r.Suggest.Ready() {
+		rs, err := r.Suggest.Suggest(prefix, field, max)
+		if err != nil {
+			return nil, err
+		}
+		return rs, errs
 	}
-	if max == 0 {
-		max = 50
+	rs := []suggest.Suggestion{}
+	if len(datastore.Tokens(prefix)) == 0 || max <= 0 {
+		return rs, errs
 	}
-	if max > utils.MAXPAGE {
-		errs = utils.Seterror(&errs, fmt.Sprintf("max parameter cannot exceed %d", utils.MAXPAGE))
-		max = utils.MAXPAGE
+	sr := datastore.SearchRequest{SearchRequest: fdc.SearchRequest{Query: prefix, SearchField: field, SearchType: datastore.PREFIX, Max: max}, Operator: datastore.AND}
+	if _, err := r.Ds.Search(sr, &hits); err != nil {
+		return nil, err
 	}
-	if page < 0 {
-		page = 0
+	for _, h := range hits {
+		rs = append(rs, suggest.FromFood(h))
 	}
-	if sort == "" {
-		sort = "fdcId"
+	return rs, errs
+}
+
+//SearchResult is a page of search hits with their total.  Facets are only
+//counted when asked for.
+type SearchResult struct {
+	Total   int           `json:"total"`
+	Hits    []interface{} `json:"hits"`
+	request datastore.SearchRequest
+}
+
+//FoodSearchResult query for a SearchRequest returning its hits with their total
+func (r *Resolver) FoodSearchResult(p graphql.ResolveParams) (interface{}, error) {
+	var (
+		sr   datastore.SearchRequest
+		errs error
+	)
+	rs := []interface{}{}
+	sr, errs = utils.Searchquery(p)
+	ns, desc := utils.Searchsort(p, &errs)
+	total, err := r.search(p.Context, sr, ns, desc, &rs)
+	if err != nil {
+		return nil, err
 	}
-	if order == "" {
-		order = "ASC"
+	return &SearchResult{Total: total, Hits: rs, request: sr}, errs
+}
+
+//SearchFacets counts the hits of a SearchResult by dataSource, category, company
+//and nutrient value ranges
+func (r *Resolver) SearchFacets(p graphql.ResolveParams) (interface{}, error) {
+	res, ok := p.Source.(*SearchResult)
+	if !ok {
+		return nil, nil
 	}
-	if sort != "foodDescription" && sort != "company" && sort != "fdcId" {
-		errs = utils.Seterror(&errs, "unrecognized sort parameter.  Must be 'company', 'foodDescription' or 'fdcId'")
-		sort = "fdcId"
+	fr, errs := utils.Facetquery(p)
+	fs, err := r.Ds.Facets(res.request, fr)
+	if err != nil {
+		return nil, err
 	}
-	offset := page * max
-	where := fmt.Sprintf("type=\"%s\" ", dt.ToString(fdc.FOOD))
+	return fs, errs
+}
 
-	if source != "" {
-		where = where + fmt.Sprintf(" AND dataSource = '%s'", source)
+//FoodsBrowse queries a list of foods based on a Browse object
+func (r *Resolver) FoodsBrowse(p graphql.ResolveParams) (interface{}, error) {
+	br, errs := utils.Browsequery(p)
+	rs, err := r.Ds.Browse(br)
+	if err != nil {
+		return nil, err
 	}
-	rs, _ := r.Ds.Browse(r.Cs.CouchDb.Bucket, where, int64(offset), int64(max), sort, order)
+	r.prime(p, rs)
 	return rs, errs
 }
 
@@ -153,46 +205,131 @@ func (r *Resolver) FoodsBrowse(p graphql.ResolveParams) (interface{}, error) {
 func (r *Resolver) Nutrientdata(p graphql.ResolveParams) (interface{}, error) {
 
 	var (
-		nut       fdc.NutrientData
-		nutdata   []fdc.NutrientData
-		rows      gocb.QueryResults
-		nIDs      []int
-		fIDs      string
-		q         string
-		err, errs error
+		nutdata []fdc.NutrientData
+		nIDs    []int
+		fIDs    []string
+		s       string
+		err     error
+		errs    error
 	)
 
 	// build a string array of FDC id's
-	fIDs, _ = utils.Fdcids(p.Args["fdcids"].([]interface{}))
+	fIDs, s = utils.Fdcids(p.Args["fdcids"].([]interface{}))
+	if s != "" {
+		errs = utils.Seterror(&errs, s)
+	}
 
 	// build an int array of nutrient numbers
-	for _, gnid := range p.Args["nutids"].([]interface{}) {
-		nIDs = append(nIDs, gnid.(int))
+	nIDs = nutids(p)
+	sc, err := scaleArg(p)
+	if err != nil {
+		return nil, err
 	}
-	// put the nutrientno array into a string for the query
-	nstr := strings.Trim(strings.Join(strings.Fields(fmt.Sprint(nIDs)), ","), "[]")
+	if nutdata, err = r.Ds.NutrientData(fIDs, nIDs); err != nil {
+		return nil, err
+	}
+	if sc == nil {
+		return nutdata, errs
+	}
+	return r.scaleNutrientdata(fIDs, nutdata, *sc, errs)
+}
 
-	if nstr != "" {
-		q = fmt.Sprintf("select nutrientdata.* from %s as nutrientdata where type=\"NUTDATA\" and fdcId in [%s] and nutrientNumber in [%s] order by fdcId,nutrientNumber", r.Cs.CouchDb.Bucket, fIDs, nstr)
-	} else {
-		q = fmt.Sprintf("select nutrientdata.* from %s as nutrientdata where type=\"NUTDATA\" and fdcId in [%s] order by fdcId,nutrientNumber", r.Cs.CouchDb.Bucket, fIDs)
+// scaleNutrientdata scales the values of a list of foods leaving out the values
+// of foods which cannot be scaled
+func (r *Resolver) scaleNutrientdata(fIDs []string, nutdata []fdc.NutrientData, sc scale, errs error) (interface{}, error) {
+	var rs []interface{}
+	if len(fIDs) == 0 {
+		return rs, errs
 	}
-	rows, err = r.Ds.Conn.ExecuteN1qlQuery(gocb.NewN1qlQuery(q), nil)
+	bases := make(map[string]basis)
+	foods, err := r.Ds.Browse(datastore.BrowseRequest{FdcIDs: fIDs, Max: int64(len(fIDs)), Sort: "fdcId", Order: "ASC"})
 	if err != nil {
 		return nil, err
 	}
-	// put the query results into the nutrientdata array
-	for rows.Next(&nut) {
-		nutdata = append(nutdata, nut)
+	for _, f := range foods {
+		id := field(f, "fdcId")
+		b, err := sc.basis(id, servingsOf(f))
+		if err != nil {
+			utils.Seterror(&errs, err.Error())
+			continue
+		}
+		bases[id] = b
+	}
+	for i := 0; i < len(nutdata); {
+		j := i
+		for j < len(nutdata) && nutdata[j].FdcID == nutdata[i].FdcID {
+			j++
+		}
+		if b, ok := bases[nutdata[i].FdcID]; ok {
+			rs = append(rs, scaled(nutdata[i:j], b)...)
+		}
+		i = j
 	}
-	if err == nil {
-		err = errs
+	return rs, errs
+}
+
+//FoodNutrients resolves the nutrient data nested in a Food or FoodSearch.  Lookups for
+//all the foods in a list are batched into a single datastore query.
+func (r *Resolver) FoodNutrients(p graphql.ResolveParams) (interface{}, error) {
+	id := field(p.Source, "fdcId")
+	sc, err := scaleArg(p)
+	if err != nil {
+		return nil, err
+	}
+	if sc != nil {
+		return r.scaledFoodNutrients(p, id, *sc)
+	}
+	if l := loaders(p.Context); l != nil {
+		thunk := l.nutrientData(r.Ds, nutids(p)).load(id)
+		return func() (interface{}, error) {
+			nd, err := thunk()
+			if nd == nil {
+				nd = []fdc.NutrientData{}
+			}
+			return nd, err
+		}, nil
+	}
+	return r.Ds.NutrientData([]string{id}, nutids(p))
+}
+
+// scaledFoodNutrients resolves the nutrient data nested in a food scaled to a basis
+func (r *Resolver) scaledFoodNutrients(p graphql.ResolveParams, id string, sc scale) (interface{}, error) {
+	servings := r.servings(p, id)
+	nutdata := func() (interface{}, error) { return r.Ds.NutrientData([]string{id}, nutids(p)) }
+	if l := loaders(p.Context); l != nil {
+		nutdata = l.nutrientData(r.Ds, nutids(p)).load(id)
+	}
+	return func() (interface{}, error) {
+		sv, err := servings()
+		if err != nil {
+			return nil, err
+		}
+		b, err := sc.basis(id, sv)
+		if err != nil {
+			return nil, err
+		}
+		nd, err := nutdata()
+		if err != nil {
+			return nil, err
+		}
+		values, _ := nd.([]fdc.NutrientData)
+		return scaled(values, b), nil
+	}, nil
+}
+
+// nutids builds an int array of nutrient numbers from the nutids argument
+func nutids(p graphql.ResolveParams) []int {
+	var nIDs []int
+	if p.Args["nutids"] != nil {
+		for _, gnid := range p.Args["nutids"].([]interface{}) {
+			nIDs = append(nIDs, gnid.(int))
+		}
 	}
-	return nutdata, err
+	return nIDs
 }
 
 //Nutrients queries a list of nutrients
 func (r *Resolver) Nutrients(p graphql.ResolveParams) (interface{}, error) {
 	var dt *fdc.DocType
-	return r.Ds.GetDictionary(r.Cs.CouchDb.Bucket, dt.ToString(fdc.NUT), 0, 300)
+	return r.Ds.GetDictionary(dt.ToString(fdc.NUT), 0, 300)
 }
@@ -0,0 +1,246 @@
+package resolvers
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	fdc "github.com/littlebunch/fdc-api/model"
+	"github.com/littlebunch/fdc-graphql/datastore"
+	"github.com/littlebunch/fdc-graphql/datastore/memory"
+)
+
+// testResolver returns a Resolver on a memory datastore holding a few foods
+func testResolver(t *testing.T) *Resolver {
+	t.Helper()
+	ds := memory.NewDatastore()
+	for _, f := range []struct {
+		food fdc.Food
+		kcal float32
+		prot float32
+	}{
+		{fdc.Food{FdcID: "170379", Description: "Broccoli, raw", Source: "SR", Servings: []fdc.Serving{{Nutrientbasis: "g", Description: "cup", Servingamount: 1, Weight: 91}}}, 34, 2.82},
+		{fdc.Food{FdcID: "171287", Description: "Egg, whole, raw, fresh", Source: "SR"}, 143, 12.56},
+		{fdc.Food{FdcID: "356425", Description: "GREEK YOGHURT", Source: "GDSN", Manufacturer: "Acme"}, 97, 9},
+	} {
+		nd := []fdc.NutrientData{
+			{FdcID: f.food.FdcID, Nutrientno: 208, Nutrient: "Energy", Unit: "kcal", Value: f.kcal},
+			{FdcID: f.food.FdcID, Nutrientno: 203, Nutrient: "Protein", Unit: "g", Value: f.prot},
+		}
+		if err := ds.PutFood(f.food, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &Resolver{Ds: ds}
+}
+
+func params(args map[string]interface{}) graphql.ResolveParams {
+	return graphql.ResolveParams{Args: args}
+}
+
+// ids returns the fdcIds of a list of foods
+func ids(t *testing.T, rs interface{}) string {
+	t.Helper()
+	foods, ok := rs.([]interface{})
+	if !ok && rs != nil {
+		t.Fatalf("%T is not a list of foods", rs)
+	}
+	var ids []string
+	for _, f := range foods {
+		ids = append(ids, field(f, "fdcId"))
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestFood(t *testing.T) {
+	r := testResolver(t)
+	for _, tc := range []struct {
+		id   string
+		want string
+		err  bool
+	}{
+		{"170379", "Broccoli, raw", false},
+		{"999", "", true},
+		{"1' OR '1'='1", "", true},
+	} {
+		f, err := r.Food(params(map[string]interface{}{"id": tc.id}))
+		if (err != nil) != tc.err {
+			t.Errorf("%s: error %v, want error %v", tc.id, err, tc.err)
+		}
+		if err == nil && field(f, "foodDescription") != tc.want {
+			t.Errorf("%s: food %v, want %s", tc.id, f, tc.want)
+		}
+	}
+}
+
+func TestFoods(t *testing.T) {
+	r := testResolver(t)
+	for _, tc := range []struct {
+		name string
+		ids  []interface{}
+		want string
+		err  bool
+	}{
+		{"every id", []interface{}{"170379", "171287", "356425"}, "356425,171287,170379", false},
+		{"unknown id", []interface{}{"170379", "999"}, "170379", false},
+		{"invalid id", []interface{}{"170379", "x"}, "170379", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rs, err := r.Foods(params(map[string]interface{}{"fdcids": tc.ids}))
+			if (err != nil) != tc.err {
+				t.Errorf("error %v, want error %v", err, tc.err)
+			}
+			if got := ids(t, rs); got != tc.want {
+				t.Errorf("foods %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFoodsBrowse(t *testing.T) {
+	r := testResolver(t)
+	for _, tc := range []struct {
+		name string
+		args map[string]interface{}
+		want string
+		err  bool
+	}{
+		{"defaults", map[string]interface{}{}, "170379,171287,356425", false},
+		{"page", map[string]interface{}{"max": 1, "page": 1}, "171287", false},
+		{"description desc", map[string]interface{}{"sort": "foodDescription", "order": "DESC"}, "356425,171287,170379", false},
+		{"source", map[string]interface{}{"source": "GDSN"}, "356425", false},
+		{"negative max", map[string]interface{}{"max": -1}, "170379,171287,356425", false},
+		{"bad sort", map[string]interface{}{"sort": "ingredients"}, "170379,171287,356425", true},
+		{"nutrient filter", map[string]interface{}{"nutrientFilters": []interface{}{map[string]interface{}{"nutrientno": 208, "gt": 50.0}}}, "171287,356425", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rs, err := r.FoodsBrowse(params(map[string]interface{}{"browse": tc.args}))
+			if (err != nil) != tc.err {
+				t.Errorf("error %v, want error %v", err, tc.err)
+			}
+			if got := ids(t, rs); got != tc.want {
+				t.Errorf("foods %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+// failing is a datastore whose Browse always fails
+type failing struct {
+	datastore.Datastore
+}
+
+func (failing) Browse(br datastore.BrowseRequest) ([]interface{}, error) {
+	return nil, errors.New("datastore is down")
+}
+
+func TestBrowseErrors(t *testing.T) {
+	r := &Resolver{Ds: failing{testResolver(t).Ds}}
+	if _, err := r.Foods(params(map[string]interface{}{"fdcids": []interface{}{"170379"}})); err == nil {
+		t.Error("Foods hides the datastore error")
+	}
+	if _, err := r.FoodsBrowse(params(map[string]interface{}{"browse": map[string]interface{}{}})); err == nil {
+		t.Error("FoodsBrowse hides the datastore error")
+	}
+}
+
+func ingredient(id string, amount float64, unit string) interface{} {
+	in := map[string]interface{}{"fdcId": id, "amount": amount}
+	if unit != "" {
+		in["unit"] = unit
+	}
+	return in
+}
+
+func TestRecipe(t *testing.T) {
+	r := testResolver(t)
+	for _, tc := range []struct {
+		name        string
+		ingredients []interface{}
+		servings    interface{}
+		weight      float64
+		kcal        float64
+		err         bool
+	}{
+		{"one food", []interface{}{ingredient("170379", 200, "")}, nil, 200, 68, false},
+		{"two foods", []interface{}{ingredient("170379", 100, ""), ingredient("171287", 50, "")}, 2, 150, 34 + 71.5, false},
+		{"food listed twice", []interface{}{ingredient("170379", 100, ""), ingredient("170379", 100, "")}, nil, 200, 68, false},
+		{"serving unit", []interface{}{ingredient("170379", 1, "cup")}, nil, 91, 30.94, false},
+		{"kilograms", []interface{}{ingredient("171287", 0.1, "kg")}, nil, 100, 143, false},
+		{"unknown food", []interface{}{ingredient("999", 100, "")}, nil, 0, 0, true},
+		{"invalid fdcId", []interface{}{ingredient("x", 100, "")}, nil, 0, 0, true},
+		{"zero amount", []interface{}{ingredient("170379", 0, "")}, nil, 0, 0, true},
+		{"unknown unit", []interface{}{ingredient("171287", 1, "cup")}, nil, 0, 0, true},
+		{"no ingredients", []interface{}{}, nil, 0, 0, true},
+		{"no servings", []interface{}{ingredient("170379", 100, "")}, 0, 0, 0, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			args := map[string]interface{}{"ingredients": tc.ingredients}
+			if tc.servings != nil {
+				args["servings"] = tc.servings
+			}
+			rs, err := r.Recipe(params(args))
+			if (err != nil) != tc.err {
+				t.Fatalf("error %v, want error %v", err, tc.err)
+			}
+			if err != nil {
+				return
+			}
+			rc := rs.(recipe)
+			if !near(rc.Weight, tc.weight) {
+				t.Errorf("weight %g, want %g", rc.Weight, tc.weight)
+			}
+			var kcal *recipeNutrient
+			for _, n := range rc.Nutrients {
+				if n.Nutrientno == 208 {
+					kcal = n
+				}
+			}
+			if kcal == nil || !near(kcal.Value, tc.kcal) {
+				t.Fatalf("energy %v, want %g", kcal, tc.kcal)
+			}
+			if !near(kcal.PerServing*float64(rc.Servings), kcal.Value) {
+				t.Errorf("energy per serving %g of %d servings, total %g", kcal.PerServing, rc.Servings, kcal.Value)
+			}
+			if len(kcal.Missing) > 0 {
+				t.Errorf("energy missing from %v", kcal.Missing)
+			}
+		})
+	}
+}
+
+func TestNutrientdata(t *testing.T) {
+	r := testResolver(t)
+	for _, tc := range []struct {
+		name   string
+		fdcids []interface{}
+		nutids []interface{}
+		want   int
+		err    bool
+	}{
+		{"every nutrient", []interface{}{"170379"}, nil, 2, false},
+		{"one nutrient", []interface{}{"170379", "171287"}, []interface{}{208}, 2, false},
+		{"repeated id", []interface{}{"170379", "170379"}, nil, 2, false},
+		{"invalid id", []interface{}{"170379", "1; DROP TABLE foods"}, []interface{}{208}, 1, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			args := map[string]interface{}{"fdcids": tc.fdcids}
+			if tc.nutids != nil {
+				args["nutids"] = tc.nutids
+			}
+			rs, err := r.Nutrientdata(params(args))
+			if (err != nil) != tc.err {
+				t.Errorf("error %v, want error %v", err, tc.err)
+			}
+			if nd := rs.([]fdc.NutrientData); len(nd) != tc.want {
+				t.Errorf("%d values, want %d", len(nd), tc.want)
+			}
+		})
+	}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-3
+}
@@ -0,0 +1,125 @@
+package resolvers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/graphql-go/graphql"
+	fdc "github.com/littlebunch/fdc-api/model"
+	"github.com/littlebunch/fdc-graphql/datastore"
+)
+
+// scale is the scale argument of a nutrient field.  Values are scaled to either
+// one of the food's servings or an amount of the food in g or ml.
+type scale struct {
+	serving int
+	amount  float64
+}
+
+// basis is the amount of food a scaled nutrient value is given for
+type basis struct {
+	Amount       float64 `json:"amount"`
+	Unit         string  `json:"unit"`
+	Serving      *int    `json:"serving"`
+	ServingUnit  string  `json:"servingUnit"`
+	ServingValue float32 `json:"servingValue"`
+}
+
+// scaledNutrient is a nutrient value scaled from 100 units to a basis.  It
+// resolves its own fields so the NutrientData fields need not be copied.
+type scaledNutrient struct {
+	nd    fdc.NutrientData
+	basis basis
+}
+
+// Resolve implements graphql.FieldResolver
+func (s scaledNutrient) Resolve(p graphql.ResolveParams) (interface{}, error) {
+	if p.Info.FieldName == "basis" {
+		return s.basis, nil
+	}
+	p.Source = s.nd
+	return graphql.DefaultResolveFn(p)
+}
+
+// scaleArg reads the scale argument returning nil if values are wanted per 100 units
+func scaleArg(p graphql.ResolveParams) (*scale, error) {
+	m, ok := p.Args["scale"].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	serving, hasServing := m["serving"].(int)
+	amount, hasAmount := m["amount"].(float64)
+	switch {
+	case hasServing == hasAmount:
+		return nil, errors.New("scale needs one of serving or amount")
+	case hasServing && serving < 0:
+		return nil, errors.New("scale serving cannot be negative")
+	case hasAmount && amount <= 0:
+		return nil, errors.New("scale amount must be greater than 0")
+	}
+	return &scale{serving: serving, amount: amount}, nil
+}
+
+// basis returns the basis of a scale for a food's servings
+func (s scale) basis(fdcid string, servings []fdc.Serving) (basis, error) {
+	b := basis{Amount: s.amount, Unit: "g"}
+	if len(servings) > 0 && servings[0].Nutrientbasis != "" {
+		b.Unit = servings[0].Nutrientbasis
+	}
+	if s.amount > 0 {
+		return b, nil
+	}
+	if s.serving >= len(servings) {
+		return b, fmt.Errorf("food %s has no serving %d", fdcid, s.serving)
+	}
+	sv := servings[s.serving]
+	if sv.Weight <= 0 {
+		return b, fmt.Errorf("serving %d of food %s has no weight", s.serving, fdcid)
+	}
+	b.Amount, b.Serving, b.ServingUnit, b.ServingValue = float64(sv.Weight), &s.serving, sv.Description, sv.Servingamount
+	if sv.Nutrientbasis != "" {
+		b.Unit = sv.Nutrientbasis
+	}
+	return b, nil
+}
+
+// scaled returns nutrient values scaled from 100 units to a basis
+func scaled(nutdata []fdc.NutrientData, b basis) []interface{} {
+	rs := make([]interface{}, 0, len(nutdata))
+	f := b.Amount / 100
+	for _, nd := range nutdata {
+		nd.Value, nd.Min, nd.Max = float32(value64(nd.Value)*f), float32(value64(nd.Min)*f), float32(value64(nd.Max)*f)
+		rs = append(rs, scaledNutrient{nd: nd, basis: b})
+	}
+	return rs
+}
+
+// value64 converts a float32 value to the float64 with the same shortest
+// decimal representation so totals are free of float32 rounding noise
+func value64(v float32) float64 {
+	f, _ := strconv.ParseFloat(strconv.FormatFloat(float64(v), 'g', -1, 32), 64)
+	return f
+}
+
+// servings returns a thunk resolving the servings of a food.  Foods returned by
+// search do not carry their servings so those are read through the food loader.
+func (r *Resolver) servings(p graphql.ResolveParams, fdcid string) func() ([]fdc.Serving, error) {
+	if sv := servingsOf(p.Source); len(sv) > 0 {
+		return func() ([]fdc.Serving, error) { return sv, nil }
+	}
+	if l := loaders(p.Context); l != nil {
+		thunk := l.food(r.Ds).load(fdcid)
+		return func() ([]fdc.Serving, error) {
+			f, err := thunk()
+			return servingsOf(f), err
+		}
+	}
+	return func() ([]fdc.Serving, error) {
+		rs, err := r.Ds.Browse(datastore.BrowseRequest{FdcIDs: []string{fdcid}, Max: 1, Sort: "fdcId", Order: "ASC"})
+		if err != nil || len(rs) == 0 {
+			return nil, err
+		}
+		return servingsOf(rs[0]), nil
+	}
+}
@@ -0,0 +1,148 @@
+package resolvers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/graphql-go/graphql"
+	fdc "github.com/littlebunch/fdc-api/model"
+	"github.com/littlebunch/fdc-graphql/auth"
+	"github.com/littlebunch/fdc-graphql/events"
+	"github.com/littlebunch/fdc-graphql/utils"
+)
+
+// errDropped ends a subscription which fell too far behind the events
+var errDropped = errors.New("subscription dropped for falling behind.  Refetch the foods watched and subscribe again.")
+
+//FoodChanged subscribes to changes to the foods in fdcIds or to all foods
+//when it is omitted.  A release sends a change to each watched food it loaded
+//or, when watching all foods, a single RELEASE_LOADED event.
+func (r *Resolver) FoodChanged(p graphql.ResolveParams) (interface{}, error) {
+	var (
+		ids  map[string]bool
+		errs error
+	)
+	if p.Args["fdcIds"] != nil {
+		ids = make(map[string]bool)
+		for _, id := range p.Args["fdcIds"].([]interface{}) {
+			s, _ := id.(string)
+			if !utils.ValidFdcid(s) {
+				errs = utils.Seterror(&errs, fmt.Sprintf("invalid fdcId %q", s))
+				continue
+			}
+			ids[s] = true
+		}
+		if errs != nil {
+			return nil, errs
+		}
+	}
+	filter := func(e events.Event) bool {
+		if e.Type != events.ReleaseLoaded {
+			return e.FdcID != "" && (ids == nil || ids[e.FdcID])
+		}
+		if ids == nil {
+			return true
+		}
+		for id := range ids {
+			if e.Affects(id) {
+				return true
+			}
+		}
+		return false
+	}
+	if ids == nil {
+		return r.subscribe(p, filter, nil)
+	}
+	return r.subscribe(p, filter, func(e events.Event) []events.Event {
+		if e.Type != events.ReleaseLoaded {
+			return []events.Event{e}
+		}
+		var changes []events.Event
+		for id := range ids {
+			if e.Affects(id) {
+				changes = append(changes, events.Event{Type: events.FoodChanged, FdcID: id, Time: e.Time})
+			}
+		}
+		sort.Slice(changes, func(i, j int) bool { return changes[i].FdcID < changes[j].FdcID })
+		return changes
+	})
+}
+
+//ReleaseLoaded subscribes to the loading of FDC releases
+func (r *Resolver) ReleaseLoaded(p graphql.ResolveParams) (interface{}, error) {
+	return r.subscribe(p, func(e events.Event) bool {
+		return e.Type == events.ReleaseLoaded
+	}, nil)
+}
+
+//Event resolves a subscription field to the event being sent
+func (r *Resolver) Event(p graphql.ResolveParams) (interface{}, error) {
+	if err, ok := p.Source.(error); ok {
+		return nil, err
+	}
+	return p.Source, nil
+}
+
+//EventFood returns the food changed by an event or null if it was deleted
+func (r *Resolver) EventFood(p graphql.ResolveParams) (interface{}, error) {
+	e, ok := p.Source.(events.Event)
+	if !ok || e.FdcID == "" || e.Type == events.FoodDeleted {
+		return nil, nil
+	}
+	// no loaders as a subscription outlives any request cache
+	var food fdc.Food
+	if err := r.Ds.Get(e.FdcID, &food); err != nil {
+		return nil, nil
+	}
+	return food, nil
+}
+
+// subscribe returns the channel graphql reads a subscription's events from.  It
+// is closed when the subscription's context ends.  expand, if not nil, turns an
+// event into those sent.
+func (r *Resolver) subscribe(p graphql.ResolveParams, filter func(events.Event) bool, expand func(events.Event) []events.Event) (interface{}, error) {
+	if err := auth.Require(p.Context, auth.Reader); err != nil {
+		return nil, err
+	}
+	if r.Events == nil {
+		return nil, errors.New("subscriptions are not enabled")
+	}
+	s := r.Events.Subscribe(filter)
+	c := make(chan interface{})
+	go func() {
+		defer close(c)
+		defer r.Events.Cancel(s)
+		for {
+			var vs []interface{}
+			select {
+			case <-p.Context.Done():
+				return
+			case e, ok := <-s.C:
+				switch {
+				case !ok && !s.Dropped():
+					return
+				case !ok:
+					vs = []interface{}{errDropped}
+				case expand != nil:
+					for _, e := range expand(e) {
+						vs = append(vs, e)
+					}
+				default:
+					vs = []interface{}{e}
+				}
+			}
+			for _, v := range vs {
+				select {
+				case <-p.Context.Done():
+					return
+				case c <- v:
+				}
+				if v == errDropped {
+					return
+				}
+			}
+		}
+	}()
+	return c, nil
+}
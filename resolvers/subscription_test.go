@@ -0,0 +1,67 @@
+package resolvers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/littlebunch/fdc-graphql/auth"
+	"github.com/littlebunch/fdc-graphql/events"
+)
+
+// receive returns the next value sent on a subscription channel
+func receive(t *testing.T, c interface{}) interface{} {
+	t.Helper()
+	select {
+	case v, ok := <-c.(chan interface{}):
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return v
+	case <-time.After(time.Second):
+		t.Fatal("no event sent")
+	}
+	return nil
+}
+
+func TestFoodChangedRelease(t *testing.T) {
+	r := testResolver(t)
+	r.Events = events.NewBus()
+	ctx, cancel := context.WithCancel(auth.NewContext(context.Background(), auth.Identity{Name: "reader", Role: auth.Reader}))
+	defer cancel()
+	p := params(map[string]interface{}{"fdcIds": []interface{}{"171287", "170379", "356425"}})
+	p.Context = ctx
+	watched, err := r.FoodChanged(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p = params(map[string]interface{}{})
+	p.Context = ctx
+	all, err := r.FoodChanged(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Events.Publish(events.Release("SR 2026-10", []string{"170379", "171287", "999999"}))
+	r.Events.Publish(events.Event{Type: events.FoodDeleted, FdcID: "356425"})
+	for _, want := range []events.Event{
+		{Type: events.FoodChanged, FdcID: "170379"},
+		{Type: events.FoodChanged, FdcID: "171287"},
+		{Type: events.FoodDeleted, FdcID: "356425"},
+	} {
+		e := receive(t, watched).(events.Event)
+		if e.Type != want.Type || e.FdcID != want.FdcID || e.Time.IsZero() {
+			t.Errorf("watched foods got %+v, want %s %s", e, want.Type, want.FdcID)
+		}
+	}
+	e := receive(t, all).(events.Event)
+	if e.Type != events.ReleaseLoaded || e.FdcID != "" {
+		t.Errorf("all foods got %+v, want one %s", e, events.ReleaseLoaded)
+	}
+	p.Source = e
+	if food, err := r.EventFood(p); food != nil || err != nil {
+		t.Errorf("food of a release is %v %v", food, err)
+	}
+	if e := receive(t, all).(events.Event); e.Type != events.FoodDeleted {
+		t.Errorf("all foods got %+v after the release", e)
+	}
+}
@@ -2,17 +2,95 @@ package schema
 
 import (
 	"github.com/graphql-go/graphql"
-	"github.com/littlebunch/fdc-api/ds/cb"
-	fdc "github.com/littlebunch/fdc-api/model"
+	"github.com/littlebunch/fdc-graphql/datastore"
+	"github.com/littlebunch/fdc-graphql/events"
+	"github.com/littlebunch/fdc-graphql/label"
 	"github.com/littlebunch/fdc-graphql/resolvers"
+	"github.com/littlebunch/fdc-graphql/suggest"
 	"github.com/littlebunch/fdc-graphql/types"
 )
 
-// InitSchema -- Create and return the FDC schema which is based on the fdc.Foods package
-func InitSchema(cb cb.Cb, cs fdc.Config) (graphql.Schema, error) {
+// InitSchema -- Create and return the FDC schema which is based on the fdc.Foods package.
+// Subscriptions receive the events published to bus and foodSuggest uses sg
+// when it is not nil.
+func InitSchema(ds datastore.Datastore, bus *events.Bus, sg *suggest.Index) (graphql.Schema, error) {
 	var t types.Types
-	r := resolvers.Resolver{Ds: &cb, Cs: cs}
+	r := resolvers.Resolver{Ds: ds, Events: bus, Suggest: sg}
 	t.InitTypes()
+	// nested fields resolved from the datastore
+	for _, o := range []*graphql.Object{t.Food, t.FoodSearch} {
+		o.AddFieldConfig("nutrients", &graphql.Field{
+			Type: graphql.NewList(t.NutrientData),
+			Args: graphql.FieldConfigArgument{
+				"nutids": &graphql.ArgumentConfig{
+					Type:        graphql.NewList(graphql.Int),
+					Description: "Nutrient numbers to return.  All nutrients are returned if omitted.",
+				},
+				"scale": &graphql.ArgumentConfig{
+					Type:        t.Scale,
+					Description: "Scale values to a serving or an amount of the food instead of 100g",
+				},
+			},
+			Description: "Nutrient values for the food",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return r.FoodNutrients(p)
+			},
+		})
+	}
+	for _, o := range []*graphql.Object{t.NutrientData, t.RecipeNutrient} {
+		o.AddFieldConfig("percentDailyValue", &graphql.Field{
+			Type: graphql.Float,
+			Args: graphql.FieldConfigArgument{
+				"profile": &graphql.ArgumentConfig{
+					Type:        graphql.String,
+					Description: "Daily value profile -- adult (adults and children 4 years and older), child (1-3 years), infant (7-12 months), pregnant (pregnant or lactating women) or a profile loaded with the -dv flag.  Defaults to adult.",
+				},
+			},
+			Description: "Value as a percent of the daily value.  Null if the profile has no daily value for the nutrient.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return r.PercentDailyValue(p)
+			},
+		})
+	}
+	t.SearchResult.AddFieldConfig("facets", &graphql.Field{
+		Type: t.Facets,
+		Args: graphql.FieldConfigArgument{
+			"size": &graphql.ArgumentConfig{
+				Type:        graphql.Int,
+				Description: "Most values to count for each of dataSource, category and company.  Defaults to 10 and cannot exceed 100.",
+			},
+			"nutrients": &graphql.ArgumentConfig{
+				Type:        graphql.NewList(t.NutrientRanges),
+				Description: "Nutrients to count hits in ranges of values of",
+			},
+		},
+		Description: "Counts of all the hits, not just the page returned, by dataSource, category, company and nutrient value ranges",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return r.SearchFacets(p)
+		},
+	})
+	t.NutritionLabel.AddFieldConfig("svg", &graphql.Field{
+		Type:        graphql.String,
+		Description: "The label rendered as an SVG image",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return string(p.Source.(*label.Label).SVG()), nil
+		},
+	})
+	t.NutritionLabel.AddFieldConfig("html", &graphql.Field{
+		Type:        graphql.String,
+		Description: "The label rendered as an HTML page",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			b, err := p.Source.(*label.Label).HTML()
+			return string(b), err
+		},
+	})
+	t.FoodEvent.AddFieldConfig("food", &graphql.Field{
+		Type:        t.Food,
+		Description: "The food as changed.  Null if it was deleted.",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return r.EventFood(p)
+		},
+	})
 	// Define the queries
 	rootQuery := graphql.NewObject(graphql.ObjectConfig{
 		Name: "Query",
@@ -41,6 +119,26 @@ func InitSchema(cb cb.Cb, cs fdc.Config) (graphql.Schema, error) {
 					return r.FoodsBrowse(p)
 				},
 			},
+			"foodsBrowseConnection": &graphql.Field{
+				Type: t.FoodConnection,
+				Args: graphql.FieldConfigArgument{
+					"browse": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(t.BrowseRequest),
+					},
+					"first": &graphql.ArgumentConfig{
+						Type:        graphql.Int,
+						Description: "Number of foods to return.  Defaults to the browse max parameter.",
+					},
+					"after": &graphql.ArgumentConfig{
+						Type:        graphql.String,
+						Description: "Cursor after which to start the list",
+					},
+				},
+				Description: "Returns a Relay connection of foods.  Parameters sent in the browse input object.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return r.FoodsBrowseConnection(p)
+				},
+			},
 			"food": &graphql.Field{
 				Type: t.Food,
 				Args: graphql.FieldConfigArgument{
@@ -71,6 +169,10 @@ func InitSchema(cb cb.Cb, cs fdc.Config) (graphql.Schema, error) {
 					"nutids": &graphql.ArgumentConfig{
 						Type: graphql.NewList(graphql.Int),
 					},
+					"scale": &graphql.ArgumentConfig{
+						Type:        t.Scale,
+						Description: "Scale values to a serving or an amount of the food instead of 100g",
+					},
 				},
 				Description: "Returns one or more nutrient values for a food.",
 				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
@@ -101,9 +203,177 @@ func InitSchema(cb cb.Cb, cs fdc.Config) (graphql.Schema, error) {
 					return r.FoodSearch(p)
 				},
 			},
+			"foodsSearchConnection": &graphql.Field{
+				Type: t.FoodSearchConnection,
+				Args: graphql.FieldConfigArgument{
+					"search": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(t.SearchRequest),
+					},
+					"first": &graphql.ArgumentConfig{
+						Type:        graphql.Int,
+						Description: "Number of foods to return.  Defaults to the search max parameter.",
+					},
+					"after": &graphql.ArgumentConfig{
+						Type:        graphql.String,
+						Description: "Cursor after which to start the list",
+					},
+				},
+				Description: "Returns a Relay connection of search hits.  Parameters sent in the search input object.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return r.FoodSearchConnection(p)
+				},
+			},
+			"foodsSearchResult": &graphql.Field{
+				Type: t.SearchResult,
+				Args: graphql.FieldConfigArgument{
+					"search": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(t.SearchRequest),
+					},
+				},
+				Description: "Returns a page of search hits with their total and facets.  Parameters sent in the search input object.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return r.FoodSearchResult(p)
+				},
+			},
+			"foodSuggest": &graphql.Field{
+				Type: graphql.NewList(t.FoodSuggestion),
+				Args: graphql.FieldConfigArgument{
+					"prefix": &graphql.ArgumentConfig{
+						Type:        graphql.NewNonNull(graphql.String),
+						Description: "Text typed so far.  Every word must start a word of the field.",
+					},
+					"field": &graphql.ArgumentConfig{
+						Type:        graphql.String,
+						Description: "Field to complete -- foodDescription (the default) or company",
+					},
+					"max": &graphql.ArgumentConfig{
+						Type:        graphql.Int,
+						Description: "Maximum number of suggestions to return.  Defaults to 10 and cannot exceed 25.",
+					},
+				},
+				Description: "Returns foods completing a prefix for search-as-you-type",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return r.FoodSuggest(p)
+				},
+			},
+			"nutritionLabel": &graphql.Field{
+				Type: t.NutritionLabel,
+				Args: graphql.FieldConfigArgument{
+					"fdcId": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"servingIndex": &graphql.ArgumentConfig{
+						Type:        graphql.Int,
+						Description: "Zero based index of the serving in servingSizes.  Defaults to 0.",
+					},
+				},
+				Description: "Returns an FDA style Nutrition Facts label for a serving of a food",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return r.NutritionLabel(p)
+				},
+			},
+			"recipe": &graphql.Field{
+				Type: t.Recipe,
+				Args: graphql.FieldConfigArgument{
+					"ingredients": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(t.Ingredient))),
+					},
+					"servings": &graphql.ArgumentConfig{
+						Type:        graphql.Int,
+						Description: "Number of servings the recipe makes.  Defaults to 1.",
+					},
+					"nutids": &graphql.ArgumentConfig{
+						Type:        graphql.NewList(graphql.Int),
+						Description: "Nutrient numbers to total.  All nutrients are totalled if omitted.",
+					},
+				},
+				Description: "Totals the nutrient values of a list of ingredients",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return r.Recipe(p)
+				},
+			},
+		},
+	})
+	// Define the mutations
+	rootMutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createFood": &graphql.Field{
+				Type: t.Food,
+				Args: graphql.FieldConfigArgument{
+					"food": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(t.FoodInput),
+					},
+				},
+				Description: "Creates a user defined food.  Its fdcId starts with C and its type and dataSource are CUSTOM.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return r.CreateFood(p)
+				},
+			},
+			"updateFood": &graphql.Field{
+				Type: t.Food,
+				Args: graphql.FieldConfigArgument{
+					"fdcId": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"food": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(t.FoodInput),
+					},
+				},
+				Description: "Updates a user defined food",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return r.UpdateFood(p)
+				},
+			},
+			"deleteFood": &graphql.Field{
+				Type: graphql.String,
+				Args: graphql.FieldConfigArgument{
+					"fdcId": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Description: "Deletes a user defined food returning its fdcId",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return r.DeleteFood(p)
+				},
+			},
+		},
+	})
+	// Define the subscriptions
+	rootSubscription := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Subscription",
+		Fields: graphql.Fields{
+			"foodChanged": &graphql.Field{
+				Type: t.FoodEvent,
+				Args: graphql.FieldConfigArgument{
+					"fdcIds": &graphql.ArgumentConfig{
+						Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+						Description: "Foods to watch.  All foods are watched if omitted.",
+					},
+				},
+				Description: "Sends an event each time a watched food is changed by a mutation or an ingest run or is deleted",
+				Subscribe: func(p graphql.ResolveParams) (interface{}, error) {
+					return r.FoodChanged(p)
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return r.Event(p)
+				},
+			},
+			"releaseLoaded": &graphql.Field{
+				Type:        t.ReleaseEvent,
+				Description: "Sends an event each time an FDC release is loaded",
+				Subscribe: func(p graphql.ResolveParams) (interface{}, error) {
+					return r.ReleaseLoaded(p)
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return r.Event(p)
+				},
+			},
 		},
 	})
 	return graphql.NewSchema(graphql.SchemaConfig{
-		Query: rootQuery,
+		Query:        rootQuery,
+		Mutation:     rootMutation,
+		Subscription: rootSubscription,
 	})
 }
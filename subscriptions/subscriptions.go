@@ -0,0 +1,395 @@
+// Package subscriptions serves GraphQL operations, including subscriptions,
+// over WebSockets.  Both the graphql-transport-ws protocol of the graphql-ws
+// library and the older graphql-ws protocol of subscriptions-transport-ws are
+// spoken, chosen by the WebSocket subprotocol the client asks for.
+package subscriptions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/littlebunch/fdc-graphql/auth"
+	"github.com/littlebunch/fdc-graphql/gqlhttp"
+	"github.com/littlebunch/fdc-graphql/resolvers"
+)
+
+// WebSocket subprotocols
+const (
+	TransportWS = "graphql-transport-ws"
+	LegacyWS    = "graphql-ws"
+)
+
+// InitTimeout is how long a client has to send connection_init
+const InitTimeout = 10 * time.Second
+
+// writeTimeout is how long a message may take to send
+const writeTimeout = 10 * time.Second
+
+// Close codes of the graphql-transport-ws protocol
+const (
+	closeBadRequest   = 4400
+	closeUnauthorized = 4401
+	closeForbidden    = 4403
+	closeNotAccepted  = 4406
+	closeInitTimeout  = 4408
+	closeDuplicate    = 4409
+	closeTooManyInits = 4429
+)
+
+// Config are the settings in the subscriptions section of the config file.
+// KeepAlive is how often idle connections are pinged and MaxSubscriptions the
+// most operations a connection may run at once.
+type Config struct {
+	Enabled          bool          `yaml:"enabled"`
+	KeepAlive        time.Duration `yaml:"keepAlive"`
+	MaxSubscriptions int           `yaml:"maxSubscriptions"`
+}
+
+// Handler upgrades requests to WebSockets and runs the operations sent over
+// them with a gqlhttp.Handler.  Authenticate returns the caller's identity from
+// the upgrade request's headers or the connection_init payload whose entries
+// are read as headers, e.g. {"Authorization":"Bearer ..."}.  Charge, if set,
+// counts each connection against rate limits and Allow each operation sent
+// over it.
+type Handler struct {
+	GQL          *gqlhttp.Handler
+	Config       Config
+	Authenticate func(header http.Header) (auth.Identity, error)
+	Charge       func(c *gin.Context, n int) bool
+	Allow        func(ctx context.Context, ip string, n int) error
+}
+
+// upgrader accepts any origin as credentials are sent explicitly rather than
+// as cookies
+var upgrader = websocket.Upgrader{
+	Subprotocols: []string{TransportWS, LegacyWS},
+	CheckOrigin:  func(r *http.Request) bool { return true },
+}
+
+// message is a message of either protocol
+type message struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Upgrade serves WebSocket upgrade requests and passes other requests on.
+// Credentials in the upgrade request are checked before upgrading so it can be
+// refused with 401 Unauthorized.
+func (h *Handler) Upgrade(c *gin.Context) {
+	if !websocket.IsWebSocketUpgrade(c.Request) {
+		c.Next()
+		return
+	}
+	c.Abort()
+	var id *auth.Identity
+	if c.GetHeader("Authorization") != "" || c.GetHeader("X-API-Key") != "" {
+		identity, err := h.Authenticate(c.Request.Header)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer realm="fdcgql"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error":  err.Error(),
+				"status": http.StatusUnauthorized,
+			})
+			return
+		}
+		id = &identity
+		c.Request = c.Request.WithContext(auth.NewContext(c.Request.Context(), identity))
+	}
+	if h.Charge != nil && !h.Charge(c, 1) {
+		return
+	}
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// the upgrader has written the error response
+		return
+	}
+	cn := &conn{
+		h:    h,
+		ws:   ws,
+		id:   id,
+		ip:   c.ClientIP(),
+		subs: make(map[string]context.CancelFunc),
+	}
+	cn.serve(c.Request.Context())
+}
+
+// conn is a WebSocket connection and the operations running on it
+type conn struct {
+	h      *Handler
+	ws     *websocket.Conn
+	legacy bool
+	every  time.Duration  // keep alive interval
+	id     *auth.Identity // set once authenticated
+	ip     string         // address operations are counted against when anonymous
+	ctx    context.Context
+	acked  bool
+	wmu    sync.Mutex
+	smu    sync.Mutex
+	subs   map[string]context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// serve reads messages until the connection closes then stops its operations
+func (cn *conn) serve(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer func() {
+		cancel()
+		cn.wg.Wait()
+		cn.ws.Close()
+	}()
+	switch cn.ws.Subprotocol() {
+	case TransportWS:
+	case LegacyWS:
+		cn.legacy = true
+	default:
+		cn.close(closeNotAccepted, "Subprotocol not acceptable")
+		return
+	}
+	cn.every = cn.h.Config.KeepAlive
+	if cn.every <= 0 {
+		cn.every = 15 * time.Second
+	}
+	cn.ws.SetReadDeadline(time.Now().Add(InitTimeout))
+	cn.ws.SetPongHandler(func(string) error {
+		if cn.acked {
+			cn.ws.SetReadDeadline(time.Now().Add(2 * cn.every))
+		}
+		return nil
+	})
+	for {
+		var msg message
+		if err := cn.ws.ReadJSON(&msg); err != nil {
+			if ne, ok := err.(interface{ Timeout() bool }); ok && ne.Timeout() && !cn.acked {
+				cn.close(closeInitTimeout, "Connection initialisation timeout")
+			}
+			return
+		}
+		if cn.acked {
+			cn.ws.SetReadDeadline(time.Now().Add(2 * cn.every))
+		}
+		if !cn.handle(ctx, msg) {
+			return
+		}
+	}
+}
+
+// handle acts on a message returning false if the connection should close
+func (cn *conn) handle(ctx context.Context, msg message) bool {
+	switch msg.Type {
+	case "connection_init":
+		if cn.acked {
+			cn.close(closeTooManyInits, "Too many initialisation requests")
+			return false
+		}
+		return cn.init(ctx, msg.Payload)
+	case "ping":
+		if !cn.legacy {
+			cn.send(message{Type: "pong"})
+		}
+	case "pong":
+	case "subscribe", "start":
+		if !cn.acked {
+			cn.close(closeUnauthorized, "Unauthorized")
+			return false
+		}
+		return cn.start(msg)
+	case "complete", "stop":
+		// the client has stopped listening so no complete is sent
+		cn.stop(msg.ID)
+	case "connection_terminate":
+		return false
+	default:
+		cn.close(closeBadRequest, fmt.Sprintf("Invalid message type %q", msg.Type))
+		return false
+	}
+	return true
+}
+
+// init authenticates a connection with the upgrade request's credentials or
+// those in the connection_init payload
+func (cn *conn) init(ctx context.Context, payload json.RawMessage) bool {
+	if cn.id == nil {
+		var params map[string]interface{}
+		json.Unmarshal(payload, &params)
+		header := make(http.Header)
+		for k, v := range params {
+			if s, ok := v.(string); ok {
+				header.Set(k, s)
+			}
+		}
+		identity, err := cn.h.Authenticate(header)
+		if err != nil {
+			if cn.legacy {
+				cn.send(message{Type: "connection_error", Payload: errorPayload(err)})
+			}
+			cn.close(closeForbidden, "Forbidden")
+			return false
+		}
+		cn.id = &identity
+	}
+	cn.ctx = auth.NewContext(ctx, *cn.id)
+	cn.acked = true
+	cn.ws.SetReadDeadline(time.Now().Add(2 * cn.every))
+	cn.send(message{Type: "connection_ack"})
+	go cn.keepAlive(ctx)
+	return true
+}
+
+// start runs an operation sending its results until it completes or is stopped
+func (cn *conn) start(msg message) bool {
+	if msg.ID == "" {
+		cn.close(closeBadRequest, "Invalid message received")
+		return false
+	}
+	var req gqlhttp.Request
+	if err := json.Unmarshal(msg.Payload, &req); err != nil {
+		cn.close(closeBadRequest, "Invalid message received")
+		return false
+	}
+	cn.smu.Lock()
+	if _, ok := cn.subs[msg.ID]; ok {
+		cn.smu.Unlock()
+		if !cn.legacy {
+			cn.close(closeDuplicate, fmt.Sprintf("Subscriber for %s already exists", msg.ID))
+			return false
+		}
+		cn.fail(msg.ID, gqlerrors.NewFormattedError(fmt.Sprintf("operation %s is already running", msg.ID)))
+		return true
+	}
+	if max := cn.h.Config.MaxSubscriptions; max > 0 && len(cn.subs) >= max {
+		cn.smu.Unlock()
+		cn.fail(msg.ID, gqlerrors.NewFormattedError(fmt.Sprintf("at most %d operations may run on a connection", max)))
+		return true
+	}
+	ctx, cancel := context.WithCancel(cn.ctx)
+	cn.subs[msg.ID] = cancel
+	cn.smu.Unlock()
+	// every operation counts against rate limits as it would over HTTP
+	if cn.h.Allow != nil {
+		if err := cn.h.Allow(cn.ctx, cn.ip, 1); err != nil {
+			cn.stop(msg.ID)
+			cn.fail(msg.ID, gqlerrors.NewFormattedError(err.Error()))
+			return true
+		}
+	}
+	doc, op, _, errs := cn.h.GQL.Prepare(&req, http.MethodPost)
+	if errs != nil {
+		cn.stop(msg.ID)
+		cn.fail(msg.ID, errs...)
+		return true
+	}
+	cn.wg.Add(1)
+	go func() {
+		defer cn.wg.Done()
+		defer cn.stop(msg.ID)
+		params := graphql.ExecuteParams{
+			Schema:        cn.h.GQL.Schema,
+			AST:           doc,
+			OperationName: req.OperationName,
+			Args:          req.Variables,
+			Context:       ctx,
+		}
+		if op.Operation != ast.OperationTypeSubscription {
+			params.Context = resolvers.NewContext(ctx)
+			cn.next(ctx, msg.ID, graphql.Execute(params))
+		} else {
+			// keep reading until graphql closes the channel so its goroutine ends
+			for result := range graphql.ExecuteSubscription(params) {
+				cn.next(ctx, msg.ID, result)
+			}
+		}
+		if ctx.Err() == nil {
+			cn.send(message{ID: msg.ID, Type: "complete"})
+		}
+	}()
+	return true
+}
+
+// stop cancels an operation and forgets it
+func (cn *conn) stop(id string) {
+	cn.smu.Lock()
+	defer cn.smu.Unlock()
+	if cancel, ok := cn.subs[id]; ok {
+		cancel()
+		delete(cn.subs, id)
+	}
+}
+
+// next sends a result unless the operation has been stopped
+func (cn *conn) next(ctx context.Context, id string, result *graphql.Result) {
+	if ctx.Err() != nil {
+		return
+	}
+	typ := "next"
+	if cn.legacy {
+		typ = "data"
+	}
+	b, _ := json.Marshal(result)
+	cn.send(message{ID: id, Type: typ, Payload: b})
+}
+
+// fail reports errors which stopped an operation from starting.  The older
+// protocol sends them as a result followed by complete.
+func (cn *conn) fail(id string, errs ...gqlerrors.FormattedError) {
+	if cn.legacy {
+		b, _ := json.Marshal(gin.H{"errors": errs})
+		cn.send(message{ID: id, Type: "data", Payload: b})
+		cn.send(message{ID: id, Type: "complete"})
+		return
+	}
+	b, _ := json.Marshal(errs)
+	cn.send(message{ID: id, Type: "error", Payload: b})
+}
+
+// keepAlive pings the client so dead connections are noticed.  The older
+// protocol also expects ka messages.
+func (cn *conn) keepAlive(ctx context.Context) {
+	t := time.NewTicker(cn.every)
+	defer t.Stop()
+	for {
+		if cn.legacy {
+			cn.send(message{Type: "ka"})
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		cn.wmu.Lock()
+		err := cn.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout))
+		cn.wmu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// send writes a message.  Errors are left for the read loop to notice.
+func (cn *conn) send(msg message) {
+	cn.wmu.Lock()
+	defer cn.wmu.Unlock()
+	cn.ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+	cn.ws.WriteJSON(msg)
+}
+
+// close sends a close frame with a protocol close code
+func (cn *conn) close(code int, reason string) {
+	cn.wmu.Lock()
+	defer cn.wmu.Unlock()
+	cn.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(writeTimeout))
+}
+
+func errorPayload(err error) json.RawMessage {
+	b, _ := json.Marshal(gin.H{"message": err.Error()})
+	return b
+}
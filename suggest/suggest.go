@@ -0,0 +1,427 @@
+// Package suggest answers search-as-you-type queries from an in-memory prefix
+// index of the words in food descriptions and company names.  The index is
+// built from the datastore at startup and kept current from the events bus.
+package suggest
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	fdc "github.com/littlebunch/fdc-api/model"
+	"github.com/littlebunch/fdc-graphql/datastore"
+	"github.com/littlebunch/fdc-graphql/events"
+)
+
+// Config are the settings in the suggest section of the config file
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// Fields suggestions may be made on
+const (
+	Description = "foodDescription"
+	Company     = "company"
+)
+
+// Max is the most suggestions returned
+const Max = 25
+
+// pageSize is the number of foods read from the datastore at a time
+const pageSize = 1000
+
+// rebuildAt is the number of changes made at once above which the index is
+// rebuilt rather than reading each changed food
+const rebuildAt = pageSize
+
+// Suggestion is a completion of a prefix
+type Suggestion struct {
+	FdcID       string `json:"fdcId"`
+	Description string `json:"foodDescription"`
+	Company     string `json:"company,omitempty"`
+}
+
+// value returns the value of a field
+func (s *Suggestion) value(field string) string {
+	if field == Company {
+		return s.Company
+	}
+	return s.Description
+}
+
+// words indexes the words of a field.  Words are kept sorted so those starting
+// with a prefix are found by binary search.
+type words struct {
+	sorted   []string
+	postings map[string][]string
+}
+
+func newWords() *words {
+	return &words{postings: make(map[string][]string)}
+}
+
+// add indexes the words of a food's value
+func (w *words) add(id, value string) {
+	for _, t := range unique(value) {
+		if _, ok := w.postings[t]; !ok {
+			i := sort.SearchStrings(w.sorted, t)
+			w.sorted = append(w.sorted, "")
+			copy(w.sorted[i+1:], w.sorted[i:])
+			w.sorted[i] = t
+		}
+		w.postings[t] = append(w.postings[t], id)
+	}
+}
+
+// remove drops a food from the postings of the words of its value
+func (w *words) remove(id, value string) {
+	for _, t := range unique(value) {
+		ids := w.postings[t]
+		for i := range ids {
+			if ids[i] == id {
+				ids = append(ids[:i], ids[i+1:]...)
+				break
+			}
+		}
+		if len(ids) > 0 {
+			w.postings[t] = ids
+			continue
+		}
+		delete(w.postings, t)
+		if i := sort.SearchStrings(w.sorted, t); i < len(w.sorted) && w.sorted[i] == t {
+			w.sorted = append(w.sorted[:i], w.sorted[i+1:]...)
+		}
+	}
+}
+
+// starting returns the words starting with a prefix
+func (w *words) starting(prefix string) []string {
+	i := sort.SearchStrings(w.sorted, prefix)
+	j := i
+	for j < len(w.sorted) && strings.HasPrefix(w.sorted[j], prefix) {
+		j++
+	}
+	return w.sorted[i:j]
+}
+
+// unique returns the distinct words of a value
+func unique(value string) []string {
+	var rs []string
+	seen := make(map[string]bool)
+	for _, t := range datastore.Tokens(value) {
+		if !seen[t] {
+			seen[t] = true
+			rs = append(rs, t)
+		}
+	}
+	return rs
+}
+
+// Index is a prefix index of the foods in a datastore.  It is safe for
+// concurrent use.
+type Index struct {
+	ds       datastore.Datastore
+	mu       sync.RWMutex
+	ready    bool
+	foods    map[string]*Suggestion
+	fields   map[string]*words
+	building sync.Mutex
+	// changes made while a Build reads the datastore are kept in pending and
+	// made again on the new index
+	rebuilding bool
+	pending    []change
+	// events observed by Watch wait in queue for the goroutine applying them
+	// so publishers are not held up reading foods
+	qmu    sync.Mutex
+	queued *sync.Cond
+	queue  []events.Event
+	busy   bool
+}
+
+// change is a food put into the index or, when Suggestion is nil, removed
+type change struct {
+	FdcID      string
+	Suggestion *Suggestion
+}
+
+// New returns an empty Index of a datastore.  Call Build to fill it.
+func New(ds datastore.Datastore) *Index {
+	x := &Index{ds: ds, foods: make(map[string]*Suggestion), fields: map[string]*words{Description: newWords(), Company: newWords()}}
+	x.queued = sync.NewCond(&x.qmu)
+	return x
+}
+
+// Ready reports whether the index has been built
+func (x *Index) Ready() bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.ready
+}
+
+// Build reads every food from the datastore into a new index replacing the
+// current one once it is complete
+func (x *Index) Build() error {
+	x.building.Lock()
+	defer x.building.Unlock()
+	x.mu.Lock()
+	x.rebuilding, x.pending = true, nil
+	x.mu.Unlock()
+	defer func() {
+		x.mu.Lock()
+		x.rebuilding, x.pending = false, nil
+		x.mu.Unlock()
+	}()
+	foods := make(map[string]*Suggestion)
+	fields := map[string]*words{Description: newWords(), Company: newWords()}
+	br := datastore.BrowseRequest{Sort: "fdcId", Order: "asc", Max: pageSize}
+	for {
+		rs, err := x.ds.Browse(br)
+		if err != nil {
+			return err
+		}
+		for _, f := range rs {
+			s := FromFood(f)
+			if s.FdcID == "" {
+				continue
+			}
+			foods[s.FdcID] = &s
+		}
+		if len(rs) < pageSize {
+			break
+		}
+		last := FromFood(rs[len(rs)-1])
+		br.After = &datastore.Key{Value: last.FdcID, FdcID: last.FdcID}
+	}
+	// sorting the words once is much quicker than inserting them in order
+	for id, s := range foods {
+		for name, w := range fields {
+			for _, t := range unique(s.value(name)) {
+				if _, ok := w.postings[t]; !ok {
+					w.sorted = append(w.sorted, t)
+				}
+				w.postings[t] = append(w.postings[t], id)
+			}
+		}
+	}
+	for _, w := range fields {
+		sort.Strings(w.sorted)
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.foods, x.fields, x.ready = foods, fields, true
+	// the foods read may predate changes made while reading them
+	for _, c := range x.pending {
+		if c.Suggestion == nil {
+			x.remove(c.FdcID)
+			continue
+		}
+		x.put(c.Suggestion)
+	}
+	return nil
+}
+
+// Put adds a food to the index or replaces it
+func (x *Index) Put(s Suggestion) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.put(&s)
+	if x.rebuilding {
+		x.pending = append(x.pending, change{FdcID: s.FdcID, Suggestion: &s})
+	}
+}
+
+func (x *Index) put(s *Suggestion) {
+	x.remove(s.FdcID)
+	x.foods[s.FdcID] = s
+	for name, w := range x.fields {
+		w.add(s.FdcID, s.value(name))
+	}
+}
+
+// Remove drops a food from the index
+func (x *Index) Remove(fdcid string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.remove(fdcid)
+	if x.rebuilding {
+		x.pending = append(x.pending, change{FdcID: fdcid})
+	}
+}
+
+func (x *Index) remove(fdcid string) {
+	s, ok := x.foods[fdcid]
+	if !ok {
+		return
+	}
+	for name, w := range x.fields {
+		w.remove(fdcid, s.value(name))
+	}
+	delete(x.foods, fdcid)
+}
+
+// Suggest returns up to max foods with a word in field starting with each
+// term of prefix.  Foods whose value starts with the prefix come first then
+// shorter values before longer.
+func (x *Index) Suggest(prefix string, field string, max int) ([]Suggestion, error) {
+	if field != Description && field != Company {
+		return nil, fmt.Errorf("cannot suggest on %s", field)
+	}
+	terms := datastore.Tokens(prefix)
+	if len(terms) == 0 || max <= 0 {
+		return []Suggestion{}, nil
+	}
+	lower := strings.ToLower(strings.TrimSpace(prefix))
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	w := x.fields[field]
+	// candidates come from the term matching the fewest foods, the others are checked on each candidate
+	var (
+		best  []string
+		count = -1
+	)
+	for _, t := range terms {
+		ws, n := w.starting(t), 0
+		for _, s := range ws {
+			n += len(w.postings[s])
+		}
+		if count < 0 || n < count {
+			best, count = ws, n
+		}
+	}
+	var (
+		rs   []*Suggestion
+		seen = make(map[string]bool, count)
+	)
+	for _, s := range best {
+		for _, id := range w.postings[s] {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			f := x.foods[id]
+			if !matches(terms, f.value(field)) {
+				continue
+			}
+			rs = insert(rs, f, field, lower, max)
+		}
+	}
+	suggestions := make([]Suggestion, len(rs))
+	for i, f := range rs {
+		suggestions[i] = *f
+	}
+	return suggestions, nil
+}
+
+// matches reports whether every term starts a word of a value
+func matches(terms []string, value string) bool {
+	ws := datastore.Tokens(value)
+	for _, t := range terms {
+		found := false
+		for _, w := range ws {
+			if strings.HasPrefix(w, t) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// insert adds a food to a ranked list of at most max foods
+func insert(rs []*Suggestion, f *Suggestion, field, prefix string, max int) []*Suggestion {
+	less := func(a, b *Suggestion) bool {
+		av, bv := strings.ToLower(a.value(field)), strings.ToLower(b.value(field))
+		if ap, bp := strings.HasPrefix(av, prefix), strings.HasPrefix(bv, prefix); ap != bp {
+			return ap
+		}
+		if len(av) != len(bv) {
+			return len(av) < len(bv)
+		}
+		if av != bv {
+			return av < bv
+		}
+		return a.FdcID < b.FdcID
+	}
+	if len(rs) == max && !less(f, rs[max-1]) {
+		return rs
+	}
+	i := sort.Search(len(rs), func(i int) bool { return less(f, rs[i]) })
+	if len(rs) < max {
+		rs = append(rs, nil)
+	}
+	copy(rs[i+1:], rs[i:])
+	rs[i] = f
+	return rs
+}
+
+// Watch keeps the index current in the background.  Changed foods are read
+// from the datastore and a release rebuilds the whole index.
+func (x *Index) Watch(bus *events.Bus) {
+	go x.apply()
+	bus.Observe(func(evs []events.Event) {
+		x.qmu.Lock()
+		x.queue = append(x.queue, evs...)
+		x.qmu.Unlock()
+		x.queued.Broadcast()
+	})
+}
+
+// apply makes the changes queued by Watch.  Changes queued together are made
+// at once so a release, or more than rebuildAt changes, rebuild the index.
+func (x *Index) apply() {
+	for {
+		x.qmu.Lock()
+		for len(x.queue) == 0 {
+			x.busy = false
+			x.queued.Broadcast()
+			x.queued.Wait()
+		}
+		evs := x.queue
+		x.queue, x.busy = nil, true
+		x.qmu.Unlock()
+		rebuild := len(evs) > rebuildAt
+		for _, e := range evs {
+			rebuild = rebuild || e.Type == events.ReleaseLoaded
+		}
+		if rebuild {
+			if err := x.Build(); err != nil {
+				log.Printf("Cannot rebuild the suggest index: %v", err)
+			}
+			continue
+		}
+		for _, e := range evs {
+			switch e.Type {
+			case events.FoodDeleted:
+				x.Remove(e.FdcID)
+			case events.FoodChanged:
+				var f fdc.Food
+				if err := x.ds.Get(e.FdcID, &f); err != nil {
+					log.Printf("Cannot read food %s for the suggest index: %v", e.FdcID, err)
+					continue
+				}
+				x.Put(FromFood(f))
+			}
+		}
+	}
+}
+
+
+// FromFood returns the Suggestion of a food returned by the datastore
+func FromFood(f interface{}) Suggestion {
+	switch v := f.(type) {
+	case fdc.Food:
+		return Suggestion{FdcID: v.FdcID, Description: v.Description, Company: v.Manufacturer}
+	case *fdc.Food:
+		return Suggestion{FdcID: v.FdcID, Description: v.Description, Company: v.Manufacturer}
+	}
+	var s Suggestion
+	b, _ := json.Marshal(f)
+	json.Unmarshal(b, &s)
+	return s
+}
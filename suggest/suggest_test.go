@@ -0,0 +1,182 @@
+package suggest
+
+import (
+	"strings"
+	"testing"
+
+	fdc "github.com/littlebunch/fdc-api/model"
+	"github.com/littlebunch/fdc-graphql/datastore"
+	"github.com/littlebunch/fdc-graphql/datastore/memory"
+	"github.com/littlebunch/fdc-graphql/events"
+)
+
+func testDatastore(t *testing.T) *memory.Datastore {
+	t.Helper()
+	ds := memory.NewDatastore()
+	for _, f := range []fdc.Food{
+		{FdcID: "1", Description: "Broccoli, raw"},
+		{FdcID: "2", Description: "Broccoli soup", Manufacturer: "Acme Foods"},
+		{FdcID: "3", Description: "Brown rice", Manufacturer: "Acme Foods"},
+		{FdcID: "4", Description: "Greek yogurt", Manufacturer: "Brookside"},
+	} {
+		if err := ds.PutFood(f, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ds
+}
+
+func ids(rs []Suggestion) string {
+	var ids []string
+	for _, s := range rs {
+		ids = append(ids, s.FdcID)
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestSuggest(t *testing.T) {
+	x := New(testDatastore(t))
+	if err := x.Build(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		prefix string
+		field  string
+		max    int
+		want   string
+		err    bool
+	}{
+		{"bro", Description, Max, "3,2,1", false},
+		{"BROC", Description, Max, "2,1", false},
+		{"broc so", Description, Max, "2", false},
+		{"soup", Description, Max, "2", false},
+		{"bro", Description, 1, "3", false},
+		{"acme", Company, Max, "2,3", false},
+		{"bro", Company, Max, "4", false},
+		{"xyz", Description, Max, "", false},
+		{"", Description, Max, "", false},
+		{"bro", "ingredients", Max, "", true},
+	} {
+		rs, err := x.Suggest(tc.prefix, tc.field, tc.max)
+		if (err != nil) != tc.err {
+			t.Errorf("%s on %s: error %v, want error %v", tc.prefix, tc.field, err, tc.err)
+		}
+		if got := ids(rs); got != tc.want {
+			t.Errorf("%s on %s: %s, want %s", tc.prefix, tc.field, got, tc.want)
+		}
+	}
+}
+
+func TestPutAndRemove(t *testing.T) {
+	x := New(testDatastore(t))
+	if err := x.Build(); err != nil {
+		t.Fatal(err)
+	}
+	x.Put(Suggestion{FdcID: "1", Description: "Kale, raw"})
+	x.Remove("2")
+	for prefix, want := range map[string]string{"broc": "", "kale": "1", "bro": "3"} {
+		if rs, _ := x.Suggest(prefix, Description, Max); ids(rs) != want {
+			t.Errorf("%s: %s, want %s", prefix, ids(rs), want)
+		}
+	}
+}
+
+// paused is a datastore whose first Browse waits until resumed
+type paused struct {
+	datastore.Datastore
+	started, resume chan bool
+}
+
+func (d *paused) Browse(br datastore.BrowseRequest) ([]interface{}, error) {
+	rs, err := d.Datastore.Browse(br)
+	if d.started != nil {
+		close(d.started)
+		d.started = nil
+		<-d.resume
+	}
+	return rs, err
+}
+
+func TestChangesDuringBuild(t *testing.T) {
+	ds := &paused{Datastore: testDatastore(t), started: make(chan bool), resume: make(chan bool)}
+	started := ds.started
+	x := New(ds)
+	done := make(chan error)
+	go func() { done <- x.Build() }()
+	<-started
+	// the build has read the foods before these changes
+	x.Put(Suggestion{FdcID: "5", Description: "Zucchini"})
+	x.Put(Suggestion{FdcID: "1", Description: "Kale, raw"})
+	x.Remove("2")
+	close(ds.resume)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	for prefix, want := range map[string]string{"zuc": "5", "kale": "1", "broc": ""} {
+		if rs, _ := x.Suggest(prefix, Description, Max); ids(rs) != want {
+			t.Errorf("%s: %s, want %s", prefix, ids(rs), want)
+		}
+	}
+}
+
+// wait returns once the changes queued by Watch have been made
+func (x *Index) wait() {
+	x.qmu.Lock()
+	defer x.qmu.Unlock()
+	for x.busy || len(x.queue) > 0 {
+		x.queued.Wait()
+	}
+}
+
+// reading is a datastore counting its Gets which wait until resumed
+type reading struct {
+	datastore.Datastore
+	gets   int
+	resume chan bool
+}
+
+func (d *reading) Get(id string, obj interface{}) error {
+	<-d.resume
+	d.gets++
+	return d.Datastore.Get(id, obj)
+}
+
+func TestWatch(t *testing.T) {
+	mem := testDatastore(t)
+	ds := &reading{Datastore: mem, resume: make(chan bool)}
+	x := New(ds)
+	if err := x.Build(); err != nil {
+		t.Fatal(err)
+	}
+	bus := events.NewBus()
+	x.Watch(bus)
+	if err := mem.PutFood(fdc.Food{FdcID: "5", Description: "Zucchini"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	// publishing returns before the food is read
+	bus.Publish(events.Event{Type: events.FoodChanged, FdcID: "5"}, events.Event{Type: events.FoodDeleted, FdcID: "2"})
+	close(ds.resume)
+	x.wait()
+	for prefix, want := range map[string]string{"zuc": "5", "broc": "1"} {
+		if rs, _ := x.Suggest(prefix, Description, Max); ids(rs) != want {
+			t.Errorf("%s: %s, want %s", prefix, ids(rs), want)
+		}
+	}
+	// too many changes to read one by one rebuild the index
+	if err := mem.PutFood(fdc.Food{FdcID: "6", Description: "Kale"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	var evs []events.Event
+	for i := 0; i <= rebuildAt; i++ {
+		evs = append(evs, events.Event{Type: events.FoodChanged, FdcID: "6"})
+	}
+	bus.Publish(evs...)
+	bus.Publish(events.Release("SR 2026-10", []string{"6"}))
+	x.wait()
+	if ds.gets != 1 {
+		t.Errorf("%d foods read, want 1", ds.gets)
+	}
+	if rs, _ := x.Suggest("kale", Description, Max); ids(rs) != "6" {
+		t.Errorf("kale: %s, want 6", ids(rs))
+	}
+}
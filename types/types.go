@@ -4,15 +4,41 @@ import "github.com/graphql-go/graphql"
 
 // Types identifies types available for FDC graphql queries
 type Types struct {
-	FoodGroup     *graphql.Object
-	ServingSizes  *graphql.Object
-	Food          *graphql.Object
-	FoodSearch    *graphql.Object
-	Derivation    *graphql.Object
-	Nutrient      *graphql.Object
-	NutrientData  *graphql.Object
-	BrowseRequest *graphql.InputObject
-	SearchRequest *graphql.InputObject
+	FoodGroup            *graphql.Object
+	ServingSizes         *graphql.Object
+	Food                 *graphql.Object
+	FoodSearch           *graphql.Object
+	FoodSuggestion       *graphql.Object
+	Derivation           *graphql.Object
+	Nutrient             *graphql.Object
+	NutrientData         *graphql.Object
+	Basis                *graphql.Object
+	Scale                *graphql.InputObject
+	BrowseRequest        *graphql.InputObject
+	NutrientFilter       *graphql.InputObject
+	NutrientSort         *graphql.InputObject
+	SearchRequest        *graphql.InputObject
+	PageInfo             *graphql.Object
+	FoodConnection       *graphql.Object
+	FoodSearchConnection *graphql.Object
+	FacetCount           *graphql.Object
+	RangeCount           *graphql.Object
+	NutrientFacet        *graphql.Object
+	Facets               *graphql.Object
+	SearchResult         *graphql.Object
+	Range                *graphql.InputObject
+	NutrientRanges       *graphql.InputObject
+	Ingredient           *graphql.InputObject
+	RecipeNutrient       *graphql.Object
+	RecipeIngredient     *graphql.Object
+	Recipe               *graphql.Object
+	LabelLine            *graphql.Object
+	NutritionLabel       *graphql.Object
+	ServingInput         *graphql.InputObject
+	NutrientValueInput   *graphql.InputObject
+	FoodInput            *graphql.InputObject
+	FoodEvent            *graphql.Object
+	ReleaseEvent         *graphql.Object
 }
 
 //InitTypes loads a Types struct with graphql Objects
@@ -132,6 +158,24 @@ func (t *Types) InitTypes() {
 			},
 		},
 	})
+	t.FoodSuggestion = graphql.NewObject(graphql.ObjectConfig{
+		Name:        "FoodSuggestion",
+		Description: "A food completing a search-as-you-type prefix",
+		Fields: graphql.Fields{
+			"fdcId": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Food Data Central ID assigned to the food",
+			},
+			"foodDescription": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Name of the food",
+			},
+			"company": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Manufacturer of the food",
+			},
+		},
+	})
 
 	// nutrient derivation
 	t.Derivation = graphql.NewObject(graphql.ObjectConfig{
@@ -174,6 +218,46 @@ func (t *Types) InitTypes() {
 			},
 		},
 	})
+	t.Basis = graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Basis",
+		Description: "The amount of food a scaled nutrient value is given for",
+		Fields: graphql.Fields{
+			"amount": &graphql.Field{
+				Type:        graphql.Float,
+				Description: "Amount of food in the unit field",
+			},
+			"unit": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Unit of measure of the amount -- either g or ml",
+			},
+			"serving": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Index of the serving in servingSizes when scaled to a serving",
+			},
+			"servingUnit": &graphql.Field{
+				Type:        graphql.String,
+				Description: "The household description of the serving",
+			},
+			"servingValue": &graphql.Field{
+				Type:        graphql.Float,
+				Description: "Number of household units in the serving",
+			},
+		},
+	})
+	t.Scale = graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:        "scale",
+		Description: "Scales nutrient values to one of a food's servings or to an amount of the food.  Give one of serving or amount.",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"serving": &graphql.InputObjectFieldConfig{
+				Type:        graphql.Int,
+				Description: "Zero based index of the serving in servingSizes",
+			},
+			"amount": &graphql.InputObjectFieldConfig{
+				Type:        graphql.Float,
+				Description: "Amount of food in g or ml",
+			},
+		},
+	})
 	t.NutrientData = graphql.NewObject(graphql.ObjectConfig{
 		Name: "NutrientData",
 		Fields: graphql.Fields{
@@ -186,7 +270,7 @@ func (t *Types) InitTypes() {
 			},
 			"value": &graphql.Field{
 				Type:        graphql.Float,
-				Description: "Amount of the nutrient per 100g of food. Specified in unit defined in the unit field.",
+				Description: "Amount of the nutrient per 100g of food, or per the basis when scaled. Specified in unit defined in the unit field.",
 			},
 			"unit": &graphql.Field{
 				Type:        graphql.String,
@@ -219,6 +303,54 @@ func (t *Types) InitTypes() {
 			"type": &graphql.Field{
 				Type: graphql.String,
 			},
+			"basis": &graphql.Field{
+				Type:        t.Basis,
+				Description: "Amount of food the value is given for when scaled.  Null for values per 100g.",
+			},
+		},
+	})
+	t.NutrientFilter = graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:        "nutrientFilter",
+		Description: "Limits a browse to foods with a nutrient value per 100g in a range.  Foods without the nutrient are excluded.",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"nutrientno": &graphql.InputObjectFieldConfig{
+				Type:        graphql.NewNonNull(graphql.Int),
+				Description: "Nutrient number to filter on, e.g. 203 for protein",
+			},
+			"gt": &graphql.InputObjectFieldConfig{
+				Type:        graphql.Float,
+				Description: "Value must be greater than this",
+			},
+			"gte": &graphql.InputObjectFieldConfig{
+				Type:        graphql.Float,
+				Description: "Value must be greater than or equal to this",
+			},
+			"lt": &graphql.InputObjectFieldConfig{
+				Type:        graphql.Float,
+				Description: "Value must be less than this",
+			},
+			"lte": &graphql.InputObjectFieldConfig{
+				Type:        graphql.Float,
+				Description: "Value must be less than or equal to this",
+			},
+			"eq": &graphql.InputObjectFieldConfig{
+				Type:        graphql.Float,
+				Description: "Value must equal this",
+			},
+		},
+	})
+	t.NutrientSort = graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:        "nutrientSort",
+		Description: "Sorts foods by their value of a nutrient.  Foods without the nutrient are listed last.",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"nutrientno": &graphql.InputObjectFieldConfig{
+				Type:        graphql.NewNonNull(graphql.Int),
+				Description: "Nutrient number to sort on, e.g. 307 for sodium",
+			},
+			"perServing": &graphql.InputObjectFieldConfig{
+				Type:        graphql.Boolean,
+				Description: "Sort on the value in the food's first serving rather than per 100g.  Foods without a serving weight are listed last.",
+			},
 		},
 	})
 	t.BrowseRequest = graphql.NewInputObject(graphql.InputObjectConfig{
@@ -241,6 +373,18 @@ func (t *Types) InitTypes() {
 				Type:        graphql.String,
 				Description: "Sort order -- ASC or DESC.",
 			},
+			"source": &graphql.InputObjectFieldConfig{
+				Type:        graphql.String,
+				Description: "Limit the list to a dataSource, e.g. SR or GDSN.",
+			},
+			"nutrientFilters": &graphql.InputObjectFieldConfig{
+				Type:        graphql.NewList(t.NutrientFilter),
+				Description: "Limit the list to foods whose nutrient values pass every filter.",
+			},
+			"nutrientSort": &graphql.InputObjectFieldConfig{
+				Type:        t.NutrientSort,
+				Description: "Sort on a nutrient value in the direction of the order parameter instead of the sort field.",
+			},
 		},
 	})
 	t.SearchRequest = graphql.NewInputObject(graphql.InputObjectConfig{
@@ -257,7 +401,15 @@ func (t *Types) InitTypes() {
 			},
 			"type": &graphql.InputObjectFieldConfig{
 				Type:        graphql.String,
-				Description: "Type of search to run",
+				Description: "Type of search to run -- PHRASE, WILDCARD, REGEX, FUZZY, PREFIX or MATCH",
+			},
+			"fuzziness": &graphql.InputObjectFieldConfig{
+				Type:        graphql.Int,
+				Description: "Edits, 0 to 2, allowed between a term and a word in FUZZY and MATCH searches.  FUZZY defaults to 1 for terms of 3 to 5 letters and 2 for longer terms, MATCH to 0.",
+			},
+			"operator": &graphql.InputObjectFieldConfig{
+				Type:        graphql.String,
+				Description: "AND, the default, to require every term of a FUZZY, PREFIX or MATCH search or OR for any",
 			},
 			"page": &graphql.InputObjectFieldConfig{
 				Type:        graphql.Int,
@@ -267,7 +419,428 @@ func (t *Types) InitTypes() {
 				Type:        graphql.Int,
 				Description: "Maximum number of items to return. ",
 			},
+			"nutrientSort": &graphql.InputObjectFieldConfig{
+				Type:        t.NutrientSort,
+				Description: "Sort hits on a nutrient value.  Only the first 1000 hits are ranked.",
+			},
+			"order": &graphql.InputObjectFieldConfig{
+				Type:        graphql.String,
+				Description: "Order of a nutrientSort -- ASC or DESC.",
+			},
+		},
+	})
+	t.PageInfo = graphql.NewObject(graphql.ObjectConfig{
+		Name:        "PageInfo",
+		Description: "Describes the page of edges returned in a connection",
+		Fields: graphql.Fields{
+			"hasNextPage": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.Boolean),
+				Description: "True if more edges follow the last one returned",
+			},
+			"hasPreviousPage": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.Boolean),
+				Description: "True if edges precede the first one returned",
+			},
+			"startCursor": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Cursor of the first edge",
+			},
+			"endCursor": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Cursor of the last edge.  Send it as the after parameter to get the next page.",
+			},
+		},
+	})
+	t.FoodConnection = t.connection("Food", t.Food)
+	t.FoodSearchConnection = t.connection("FoodSearch", t.FoodSearch)
+	t.FacetCount = graphql.NewObject(graphql.ObjectConfig{
+		Name:        "FacetCount",
+		Description: "Number of search hits with a value",
+		Fields: graphql.Fields{
+			"value": &graphql.Field{
+				Type: graphql.String,
+			},
+			"count": &graphql.Field{
+				Type: graphql.Int,
+			},
+		},
+	})
+	t.RangeCount = graphql.NewObject(graphql.ObjectConfig{
+		Name:        "RangeCount",
+		Description: "Number of search hits with a nutrient value per 100g from min up to but not including max",
+		Fields: graphql.Fields{
+			"min": &graphql.Field{
+				Type: graphql.Float,
+			},
+			"max": &graphql.Field{
+				Type: graphql.Float,
+			},
+			"count": &graphql.Field{
+				Type: graphql.Int,
+			},
 		},
 	})
+	t.NutrientFacet = graphql.NewObject(graphql.ObjectConfig{
+		Name:        "NutrientFacet",
+		Description: "Numbers of search hits in ranges of a nutrient's values",
+		Fields: graphql.Fields{
+			"nutrientno": &graphql.Field{
+				Type: graphql.Int,
+			},
+			"ranges": &graphql.Field{
+				Type: graphql.NewList(t.RangeCount),
+			},
+		},
+	})
+	t.Facets = graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Facets",
+		Description: "Counts of search hits for filtering the search.  Values are listed most common first.",
+		Fields: graphql.Fields{
+			"dataSource": &graphql.Field{
+				Type: graphql.NewList(t.FacetCount),
+			},
+			"category": &graphql.Field{
+				Type: graphql.NewList(t.FacetCount),
+			},
+			"company": &graphql.Field{
+				Type: graphql.NewList(t.FacetCount),
+			},
+			"nutrients": &graphql.Field{
+				Type: graphql.NewList(t.NutrientFacet),
+			},
+		},
+	})
+	t.SearchResult = graphql.NewObject(graphql.ObjectConfig{
+		Name:        "SearchResult",
+		Description: "A page of search hits with their total and facets",
+		Fields: graphql.Fields{
+			"total": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Number of hits",
+			},
+			"hits": &graphql.Field{
+				Type:        graphql.NewList(t.FoodSearch),
+				Description: "The page of hits",
+			},
+		},
+	})
+	t.Range = graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:        "range",
+		Description: "Values from min up to but not including max.  Leave either out for an open ended range.",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"min": &graphql.InputObjectFieldConfig{
+				Type: graphql.Float,
+			},
+			"max": &graphql.InputObjectFieldConfig{
+				Type: graphql.Float,
+			},
+		},
+	})
+	t.NutrientRanges = graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:        "nutrientRanges",
+		Description: "Ranges of a nutrient's values per 100g to count search hits in",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"nutrientno": &graphql.InputObjectFieldConfig{
+				Type:        graphql.NewNonNull(graphql.Int),
+				Description: "Nutrient number, e.g. 208 for energy",
+			},
+			"ranges": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(t.Range))),
+			},
+		},
+	})
+	t.Ingredient = graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:        "ingredient",
+		Description: "An amount of a food used in a recipe",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"fdcId": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"amount": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(graphql.Float),
+			},
+			"unit": &graphql.InputObjectFieldConfig{
+				Type:        graphql.String,
+				Description: "g, kg, mg, oz, lb, ml or l, serving for the food's first serving or a household unit from its servingSizes such as cup.  Defaults to g.",
+			},
+		},
+	})
+	t.RecipeNutrient = graphql.NewObject(graphql.ObjectConfig{
+		Name: "RecipeNutrient",
+		Fields: graphql.Fields{
+			"nutrientno": &graphql.Field{
+				Type: graphql.Int,
+			},
+			"nutrient": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Name of the nutrient",
+			},
+			"unit": &graphql.Field{
+				Type: graphql.String,
+			},
+			"value": &graphql.Field{
+				Type:        graphql.Float,
+				Description: "Amount of the nutrient in the whole recipe",
+			},
+			"perServing": &graphql.Field{
+				Type:        graphql.Float,
+				Description: "Amount of the nutrient in one serving of the recipe",
+			},
+			"missing": &graphql.Field{
+				Type:        graphql.NewList(graphql.String),
+				Description: "fdcIds of ingredients with no value for the nutrient",
+			},
+		},
+	})
+	t.RecipeIngredient = graphql.NewObject(graphql.ObjectConfig{
+		Name: "RecipeIngredient",
+		Fields: graphql.Fields{
+			"fdcId": &graphql.Field{
+				Type: graphql.String,
+			},
+			"foodDescription": &graphql.Field{
+				Type: graphql.String,
+			},
+			"amount": &graphql.Field{
+				Type: graphql.Float,
+			},
+			"unit": &graphql.Field{
+				Type: graphql.String,
+			},
+			"weight": &graphql.Field{
+				Type:        graphql.Float,
+				Description: "Amount converted to g or ml",
+			},
+			"nutrients": &graphql.Field{
+				Type:        graphql.NewList(t.NutrientData),
+				Description: "Nutrient values for the amount of the ingredient used",
+			},
+		},
+	})
+	t.LabelLine = graphql.NewObject(graphql.ObjectConfig{
+		Name:        "LabelLine",
+		Description: "A nutrient declared on a Nutrition Facts label",
+		Fields: graphql.Fields{
+			"name": &graphql.Field{
+				Type: graphql.String,
+			},
+			"nutrientno": &graphql.Field{
+				Type: graphql.Int,
+			},
+			"value": &graphql.Field{
+				Type:        graphql.Float,
+				Description: "Unrounded amount in the serving.  Null if the food has no value for the nutrient.",
+			},
+			"amount": &graphql.Field{
+				Type:        graphql.Float,
+				Description: "Amount in the serving rounded by FDA rules",
+			},
+			"unit": &graphql.Field{
+				Type: graphql.String,
+			},
+			"display": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Amount as printed on the label, e.g. <1g",
+			},
+			"percentDailyValue": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Percent daily value rounded by FDA rules",
+			},
+			"indent": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Indentation level of the line on the label",
+			},
+			"bold": &graphql.Field{
+				Type: graphql.Boolean,
+			},
+		},
+	})
+	t.NutritionLabel = graphql.NewObject(graphql.ObjectConfig{
+		Name:        "NutritionLabel",
+		Description: "An FDA style Nutrition Facts panel for a serving of a food",
+		Fields: graphql.Fields{
+			"fdcId": &graphql.Field{
+				Type: graphql.String,
+			},
+			"foodDescription": &graphql.Field{
+				Type: graphql.String,
+			},
+			"servingIndex": &graphql.Field{
+				Type: graphql.Int,
+			},
+			"servingSize": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Household measure and weight of the serving, e.g. 1 cup (91g)",
+			},
+			"servingWeight": &graphql.Field{
+				Type: graphql.Float,
+			},
+			"servingUnit": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Unit of the serving weight -- either g or ml",
+			},
+			"calories": &graphql.Field{
+				Type: graphql.Int,
+			},
+			"nutrients": &graphql.Field{
+				Type:        graphql.NewList(t.LabelLine),
+				Description: "Nutrients in the main part of the label",
+			},
+			"vitamins": &graphql.Field{
+				Type:        graphql.NewList(t.LabelLine),
+				Description: "Vitamins and minerals at the foot of the label",
+			},
+		},
+	})
+	t.ServingInput = graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "servingInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"nutrientBasis": &graphql.InputObjectFieldConfig{
+				Type:        graphql.String,
+				Description: "Unit of measure of the weight -- either g or ml.  Defaults to g.",
+			},
+			"servingUnit": &graphql.InputObjectFieldConfig{
+				Type:        graphql.String,
+				Description: "The household description of the serving",
+			},
+			"weight": &graphql.InputObjectFieldConfig{
+				Type:        graphql.NewNonNull(graphql.Float),
+				Description: "Weight of the serving in g or ml",
+			},
+			"value": &graphql.InputObjectFieldConfig{
+				Type:        graphql.Float,
+				Description: "Number of household units in the serving",
+			},
+		},
+	})
+	t.NutrientValueInput = graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "nutrientValueInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"nutrientno": &graphql.InputObjectFieldConfig{
+				Type:        graphql.NewNonNull(graphql.Int),
+				Description: "Nutrient number from the nutrient dictionary",
+			},
+			"value": &graphql.InputObjectFieldConfig{
+				Type:        graphql.NewNonNull(graphql.Float),
+				Description: "Amount of the nutrient per 100g or 100ml in the dictionary unit",
+			},
+		},
+	})
+	t.FoodInput = graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:        "foodInput",
+		Description: "Fields of a user defined food.  Fields left out of an update are unchanged.",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"foodDescription": &graphql.InputObjectFieldConfig{
+				Type:        graphql.String,
+				Description: "Required when creating a food",
+			},
+			"upc": &graphql.InputObjectFieldConfig{
+				Type: graphql.String,
+			},
+			"ingredients": &graphql.InputObjectFieldConfig{
+				Type: graphql.String,
+			},
+			"company": &graphql.InputObjectFieldConfig{
+				Type: graphql.String,
+			},
+			"category": &graphql.InputObjectFieldConfig{
+				Type:        graphql.String,
+				Description: "Description of the food's category",
+			},
+			"servingSizes": &graphql.InputObjectFieldConfig{
+				Type:        graphql.NewList(t.ServingInput),
+				Description: "Replaces the food's servings",
+			},
+			"nutrients": &graphql.InputObjectFieldConfig{
+				Type:        graphql.NewList(t.NutrientValueInput),
+				Description: "Replaces the food's nutrient values",
+			},
+		},
+	})
+	t.Recipe = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Recipe",
+		Fields: graphql.Fields{
+			"servings": &graphql.Field{
+				Type: graphql.Int,
+			},
+			"weight": &graphql.Field{
+				Type:        graphql.Float,
+				Description: "Total weight of the ingredients in g or ml",
+			},
+			"weightPerServing": &graphql.Field{
+				Type: graphql.Float,
+			},
+			"nutrients": &graphql.Field{
+				Type:        graphql.NewList(t.RecipeNutrient),
+				Description: "Nutrient totals ordered by nutrient number",
+			},
+			"ingredients": &graphql.Field{
+				Type: graphql.NewList(t.RecipeIngredient),
+			},
+		},
+	})
+	t.FoodEvent = graphql.NewObject(graphql.ObjectConfig{
+		Name:        "FoodEvent",
+		Description: "A change to a food",
+		Fields: graphql.Fields{
+			"type": &graphql.Field{
+				Type:        graphql.String,
+				Description: "CHANGED, DELETED or RELEASE_LOADED when watching all foods and a release changed them",
+			},
+			"fdcId": &graphql.Field{
+				Type: graphql.String,
+			},
+			"time": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+		},
+	})
+	t.ReleaseEvent = graphql.NewObject(graphql.ObjectConfig{
+		Name:        "ReleaseEvent",
+		Description: "An FDC release loaded into the datastore",
+		Fields: graphql.Fields{
+			"release": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Name of the release, e.g. the files loaded",
+			},
+			"foods": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Number of foods loaded",
+			},
+			"time": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+		},
+	})
+}
 
+// connection creates a Relay connection type with its edge type for a node type
+func (t *Types) connection(name string, node *graphql.Object) *graphql.Object {
+	edge := graphql.NewObject(graphql.ObjectConfig{
+		Name: name + "Edge",
+		Fields: graphql.Fields{
+			"node": &graphql.Field{
+				Type: node,
+			},
+			"cursor": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.String),
+				Description: "Opaque position of the edge in the list",
+			},
+		},
+	})
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: name + "Connection",
+		Fields: graphql.Fields{
+			"edges": &graphql.Field{
+				Type: graphql.NewList(edge),
+			},
+			"pageInfo": &graphql.Field{
+				Type: graphql.NewNonNull(t.PageInfo),
+			},
+			"totalCount": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Total number of items in the list",
+			},
+		},
+	})
 }
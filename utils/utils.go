@@ -3,33 +3,73 @@ package utils
 import (
 	"errors"
 	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/graphql-go/graphql"
 	fdc "github.com/littlebunch/fdc-api/model"
+	"github.com/littlebunch/fdc-graphql/datastore"
 )
 
 // Maximum number of FDC Id's that may be requested per query
 const (
-	MAXIDS  = 100
-	MAXPAGE = 150
+	MAXIDS     = 100
+	MAXPAGE    = 150
+	MAXFILTERS = 10
+	MAXRANK    = 1000
+	MAXFACETS  = 100
+	MAXRANGES  = 20
 )
 
-//Fdcids creates a csv string representation of an array of fdcids for use in a query
-func Fdcids(fids []interface{}) (string, string) {
-	i := 0
-	fIDs := ""
-	var err string
+// filterOps maps the comparison fields of a nutrientFilter onto datastore.Ops
+// in the order filters are built from them
+var filterOps = []struct {
+	field string
+	op    string
+}{
+	{"gt", ">"},
+	{"gte", ">="},
+	{"lt", "<"},
+	{"lte", "<="},
+	{"eq", "="},
+}
+
+// fdcidPattern matches a well formed fdcId.  FDC assigns positive integers and
+// user defined foods have the same prefixed with datastore.CustomPrefix.
+var fdcidPattern = regexp.MustCompile(`^` + datastore.CustomPrefix + `?[0-9]{1,12}$`)
+
+// searchFields are the fields a search may be limited to
+var searchFields = map[string]bool{
+	"foodDescription": true,
+	"company":         true,
+	"ingredients":     true,
+	"upc":             true,
+	"category":        true,
+}
+
+//ValidFdcid reports whether an fdcId is well formed
+func ValidFdcid(id string) bool {
+	return fdcidPattern.MatchString(id)
+}
+
+//Fdcids creates a string array from an array of fdcids for use in a query.  Malformed ids are dropped.
+func Fdcids(fids []interface{}) ([]string, string) {
+	var (
+		fIDs []string
+		errs []string
+	)
 	for _, fid := range fids {
-		fIDs += fmt.Sprintf("\"%s\",", fid.(string))
-		i++
-		if i > MAXIDS {
-			err = fmt.Sprintf("number of fdcId's should not exceed %d", MAXIDS)
+		if len(fIDs) >= MAXIDS {
+			errs = append(errs, fmt.Sprintf("number of fdcId's should not exceed %d", MAXIDS))
 			break
 		}
+		if !ValidFdcid(fid.(string)) {
+			errs = append(errs, fmt.Sprintf("invalid fdcId %q", fid.(string)))
+			continue
+		}
+		fIDs = append(fIDs, fid.(string))
 	}
-	fIDs = strings.Trim(fIDs, ",")
-	return fIDs, err
+	return fIDs, strings.Join(errs, ";")
 }
 
 //Seterror adds an error to an error array
@@ -38,16 +78,16 @@ func Seterror(err *error, msg string) error {
 	if *err == nil {
 		*err = errors.New(msg)
 	} else {
-		*err = fmt.Errorf("%w;"+msg, *err)
+		*err = fmt.Errorf("%w;%s", *err, msg)
 	}
 	return *err
 
 }
 
 //Searchquery builds a SearchRequest from query parameters
-func Searchquery(p graphql.ResolveParams) (fdc.SearchRequest, error) {
+func Searchquery(p graphql.ResolveParams) (datastore.SearchRequest, error) {
 	var (
-		sr        fdc.SearchRequest
+		sr        datastore.SearchRequest
 		max, page int
 		errs      error
 	)
@@ -81,15 +121,35 @@ func Searchquery(p graphql.ResolveParams) (fdc.SearchRequest, error) {
 
 	if b["type"] != nil {
 		t := b["type"].(string)
-		if t != fdc.PHRASE && t != fdc.WILDCARD && t != fdc.REGEX {
-			errs = Seterror(&errs, fmt.Sprintf("Search type must be %s, %s, or %s ", fdc.PHRASE, fdc.WILDCARD, fdc.REGEX))
+		if t != fdc.PHRASE && t != fdc.WILDCARD && t != fdc.REGEX && !datastore.IsWords(t) {
+			errs = Seterror(&errs, fmt.Sprintf("Search type must be %s, %s, %s, %s, %s or %s ", fdc.PHRASE, fdc.WILDCARD, fdc.REGEX, datastore.FUZZY, datastore.PREFIX, datastore.MATCH))
 			sr.SearchType = ""
 		} else {
 			sr.SearchType = t
 		}
 	}
+	sr.Fuzziness = datastore.AutoFuzziness
+	if b["fuzziness"] != nil {
+		sr.Fuzziness = b["fuzziness"].(int)
+		if sr.Fuzziness < 0 || sr.Fuzziness > datastore.MaxFuzziness {
+			errs = Seterror(&errs, fmt.Sprintf("fuzziness must be from 0 to %d", datastore.MaxFuzziness))
+			sr.Fuzziness = datastore.AutoFuzziness
+		}
+	}
+	sr.Operator = datastore.AND
+	if b["operator"] != nil {
+		sr.Operator = strings.ToUpper(b["operator"].(string))
+		if sr.Operator != datastore.AND && sr.Operator != datastore.OR {
+			errs = Seterror(&errs, fmt.Sprintf("operator must be %s or %s", datastore.AND, datastore.OR))
+			sr.Operator = datastore.AND
+		}
+	}
 	if b["field"] != nil {
 		sr.SearchField = b["field"].(string)
+		if sr.SearchField != "" && !searchFields[sr.SearchField] && strings.ToLower(sr.SearchField) != "category" {
+			errs = Seterror(&errs, "unrecognized search field.  Must be 'foodDescription', 'company', 'ingredients', 'upc' or 'category'")
+			sr.SearchField = ""
+		}
 		if strings.ToLower(sr.SearchField) == "category" {
 			sr.SearchField = "foodGroup.description"
 		}
@@ -104,3 +164,192 @@ func Searchquery(p graphql.ResolveParams) (fdc.SearchRequest, error) {
 
 	return sr, errs
 }
+
+//Browsequery builds a BrowseRequest from query parameters
+func Browsequery(p graphql.ResolveParams) (datastore.BrowseRequest, error) {
+	var (
+		max, page           int
+		sort, order, source string
+		errs                error
+	)
+	b := p.Args["browse"].(map[string]interface{})
+	if b["max"] == nil {
+		max = 50
+	} else {
+		max = b["max"].(int)
+	}
+	if max > 150 {
+		errs = Seterror(&errs, "cannot return more than 150 items")
+	}
+	if b["page"] == nil {
+		page = 0
+	} else {
+		page = b["page"].(int)
+	}
+	if b["sort"] == nil {
+		sort = "fdcId"
+	} else {
+		sort = b["sort"].(string)
+	}
+	if b["order"] == nil {
+		order = "ASC"
+	} else {
+		order = b["order"].(string)
+	}
+	if b["source"] != nil {
+		source = b["source"].(string)
+	}
+	if max <= 0 {
+		max = 50
+	}
+	if max > MAXPAGE {
+		errs = Seterror(&errs, fmt.Sprintf("max parameter cannot exceed %d", MAXPAGE))
+		max = MAXPAGE
+	}
+	if page < 0 {
+		page = 0
+	}
+	if sort == "" {
+		sort = "fdcId"
+	}
+	order = sortOrder(order, &errs)
+	if sort != "foodDescription" && sort != "company" && sort != "fdcId" {
+		errs = Seterror(&errs, "unrecognized sort parameter.  Must be 'company', 'foodDescription' or 'fdcId'")
+		sort = "fdcId"
+	}
+	ns := Nutrientsort(b["nutrientSort"], &errs)
+	if ns != nil {
+		sort = "fdcId"
+	}
+	return datastore.BrowseRequest{
+		Source:       source,
+		Offset:       int64(page * max),
+		Max:          int64(max),
+		Sort:         sort,
+		Order:        order,
+		Nutrients:    Nutrientfilters(b["nutrientFilters"], &errs),
+		NutrientSort: ns,
+	}, errs
+}
+
+//Searchsort reads the nutrientSort and order parameters of a search.  It returns
+//nil when the hits are to be left in the order the datastore returns them.
+func Searchsort(p graphql.ResolveParams, errs *error) (*datastore.NutrientSort, bool) {
+	b := p.Args["search"].(map[string]interface{})
+	order, _ := b["order"].(string)
+	return Nutrientsort(b["nutrientSort"], errs), sortOrder(order, errs) == "DESC"
+}
+
+// sortOrder validates an order parameter defaulting to ASC
+func sortOrder(order string, errs *error) string {
+	order = strings.ToUpper(order)
+	if order == "" {
+		order = "ASC"
+	}
+	if order != "ASC" && order != "DESC" {
+		Seterror(errs, "unrecognized order parameter.  Must be 'ASC' or 'DESC'")
+		order = "ASC"
+	}
+	return order
+}
+
+//Nutrientsort builds a NutrientSort from the nutrientSort parameter
+func Nutrientsort(arg interface{}, errs *error) *datastore.NutrientSort {
+	m, ok := arg.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	no, _ := m["nutrientno"].(int)
+	if no <= 0 {
+		Seterror(errs, "nutrientSort needs a nutrientno")
+		return nil
+	}
+	perServing, _ := m["perServing"].(bool)
+	return &datastore.NutrientSort{Nutrientno: uint(no), PerServing: perServing}
+}
+
+//Facetquery builds a FacetRequest from the size and nutrients parameters of a
+//facets field
+func Facetquery(p graphql.ResolveParams) (datastore.FacetRequest, error) {
+	var (
+		fr   datastore.FacetRequest
+		errs error
+	)
+	fr.Size = 10
+	if p.Args["size"] != nil {
+		fr.Size = p.Args["size"].(int)
+	}
+	if fr.Size > MAXFACETS {
+		errs = Seterror(&errs, fmt.Sprintf("size cannot exceed %d", MAXFACETS))
+		fr.Size = MAXFACETS
+	}
+	if fr.Size < 0 {
+		fr.Size = 0
+	}
+	nutrients, _ := p.Args["nutrients"].([]interface{})
+	if len(nutrients) > MAXFILTERS {
+		errs = Seterror(&errs, fmt.Sprintf("number of nutrient facets should not exceed %d", MAXFILTERS))
+		nutrients = nutrients[:MAXFILTERS]
+	}
+	for _, n := range nutrients {
+		m, _ := n.(map[string]interface{})
+		no, _ := m["nutrientno"].(int)
+		ranges, _ := m["ranges"].([]interface{})
+		if no <= 0 || len(ranges) == 0 {
+			errs = Seterror(&errs, "nutrient facets need a nutrientno and ranges")
+			continue
+		}
+		if len(ranges) > MAXRANGES {
+			errs = Seterror(&errs, fmt.Sprintf("number of ranges should not exceed %d", MAXRANGES))
+			ranges = ranges[:MAXRANGES]
+		}
+		nr := datastore.NutrientRanges{Nutrientno: uint(no)}
+		for _, r := range ranges {
+			var dr datastore.Range
+			rm, _ := r.(map[string]interface{})
+			if v, ok := rm["min"].(float64); ok {
+				dr.Min = &v
+			}
+			if v, ok := rm["max"].(float64); ok {
+				dr.Max = &v
+			}
+			if dr.Min != nil && dr.Max != nil && *dr.Min >= *dr.Max {
+				errs = Seterror(&errs, fmt.Sprintf("range min %g must be less than max %g", *dr.Min, *dr.Max))
+				continue
+			}
+			nr.Ranges = append(nr.Ranges, dr)
+		}
+		if len(nr.Ranges) > 0 {
+			fr.Nutrients = append(fr.Nutrients, nr)
+		}
+	}
+	return fr, errs
+}
+
+//Nutrientfilters builds a list of NutrientFilters from the nutrientFilters parameter
+func Nutrientfilters(arg interface{}, errs *error) []datastore.NutrientFilter {
+	var nfs []datastore.NutrientFilter
+	filters, _ := arg.([]interface{})
+	if len(filters) > MAXFILTERS {
+		Seterror(errs, fmt.Sprintf("number of nutrient filters should not exceed %d", MAXFILTERS))
+		filters = filters[:MAXFILTERS]
+	}
+	for _, f := range filters {
+		m, _ := f.(map[string]interface{})
+		no, _ := m["nutrientno"].(int)
+		if no <= 0 {
+			Seterror(errs, "nutrient filters need a nutrientno")
+			continue
+		}
+		n := len(nfs)
+		for _, fo := range filterOps {
+			if v, ok := m[fo.field].(float64); ok {
+				nfs = append(nfs, datastore.NutrientFilter{Nutrientno: uint(no), Op: fo.op, Value: v})
+			}
+		}
+		if len(nfs) == n {
+			Seterror(errs, fmt.Sprintf("nutrient filter for %d needs one of gt, gte, lt, lte or eq", no))
+		}
+	}
+	return nfs
+}
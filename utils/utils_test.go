@@ -0,0 +1,225 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	fdc "github.com/littlebunch/fdc-api/model"
+	"github.com/littlebunch/fdc-graphql/datastore"
+)
+
+func TestValidFdcid(t *testing.T) {
+	for _, tc := range []struct {
+		id   string
+		want bool
+	}{
+		{"171287", true},
+		{"C123", true},
+		{"1", true},
+		{"123456789012", true},
+		{"", false},
+		{"C", false},
+		{"c123", false},
+		{"1234567890123", false},
+		{"-1", false},
+		{"12a", false},
+		{" 123", false},
+		{"123\n", false},
+		{"1 OR 1=1", false},
+		{"1' OR '1'='1", false},
+		{"1; DROP TABLE foods", false},
+		{"1) UNION SELECT * FROM foods --", false},
+	} {
+		if got := ValidFdcid(tc.id); got != tc.want {
+			t.Errorf("ValidFdcid(%q) = %v, want %v", tc.id, got, tc.want)
+		}
+	}
+}
+
+func TestFdcids(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		ids    []interface{}
+		want   []string
+		errors []string
+	}{
+		{"valid", []interface{}{"171287", "C1"}, []string{"171287", "C1"}, nil},
+		{"injection dropped", []interface{}{"171287", "1' OR '1'='1", "1; DROP TABLE foods"}, []string{"171287"}, []string{`invalid fdcId "1' OR '1'='1"`, `invalid fdcId "1; DROP TABLE foods"`}},
+		{"empty", []interface{}{}, nil, nil},
+		{"too many", manyIDs(MAXIDS + 5), manyStrings(MAXIDS), []string{"should not exceed"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, errs := Fdcids(tc.ids)
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("ids = %v, want %v", got, tc.want)
+			}
+			if len(tc.errors) == 0 && errs != "" {
+				t.Errorf("unexpected errors %q", errs)
+			}
+			for _, e := range tc.errors {
+				if !strings.Contains(errs, e) {
+					t.Errorf("errors %q do not report %s", errs, e)
+				}
+			}
+		})
+	}
+}
+
+func manyStrings(n int) []string {
+	var rs []string
+	for i := 1; i <= n; i++ {
+		rs = append(rs, strings.Repeat("1", 1+i%12))
+	}
+	return rs
+}
+
+func manyIDs(n int) []interface{} {
+	var rs []interface{}
+	for _, s := range manyStrings(n) {
+		rs = append(rs, s)
+	}
+	return rs
+}
+
+func TestSeterror(t *testing.T) {
+	var errs error
+	Seterror(&errs, "first")
+	Seterror(&errs, "100% of %d")
+	if got := errs.Error(); got != "first;100% of %d" {
+		t.Errorf("Seterror = %q", got)
+	}
+	if errors.Unwrap(errs) == nil {
+		t.Error("Seterror does not wrap the earlier errors")
+	}
+}
+
+func browse(args map[string]interface{}) graphql.ResolveParams {
+	return graphql.ResolveParams{Args: map[string]interface{}{"browse": args}}
+}
+
+func TestBrowsequery(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		args  map[string]interface{}
+		sort  string
+		order string
+		max   int64
+		err   bool
+	}{
+		{"defaults", map[string]interface{}{}, "fdcId", "ASC", 50, false},
+		{"description desc", map[string]interface{}{"sort": "foodDescription", "order": "desc"}, "foodDescription", "DESC", 50, false},
+		{"company", map[string]interface{}{"sort": "company", "max": 10}, "company", "ASC", 10, false},
+		{"unknown sort", map[string]interface{}{"sort": "ingredients"}, "fdcId", "ASC", 50, true},
+		{"injected sort", map[string]interface{}{"sort": "fdc_id; DROP TABLE foods"}, "fdcId", "ASC", 50, true},
+		{"injected order", map[string]interface{}{"order": "ASC; DROP TABLE foods"}, "fdcId", "ASC", 50, true},
+		{"zero max", map[string]interface{}{"max": 0}, "fdcId", "ASC", 50, false},
+		{"negative max", map[string]interface{}{"max": -1}, "fdcId", "ASC", 50, false},
+		{"large max", map[string]interface{}{"max": 1000}, "fdcId", "ASC", MAXPAGE, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			br, err := Browsequery(browse(tc.args))
+			if br.Sort != tc.sort || br.Order != tc.order || br.Max != tc.max {
+				t.Errorf("sort %s %s max %d, want %s %s max %d", br.Sort, br.Order, br.Max, tc.sort, tc.order, tc.max)
+			}
+			if (err != nil) != tc.err {
+				t.Errorf("error %v, want error %v", err, tc.err)
+			}
+		})
+	}
+}
+
+func search(args map[string]interface{}) graphql.ResolveParams {
+	return graphql.ResolveParams{Args: map[string]interface{}{"search": args}}
+}
+
+func TestSearchquery(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		args       map[string]interface{}
+		searchType string
+		field      string
+		err        bool
+	}{
+		{"defaults", map[string]interface{}{"terms": "bread"}, "", "", false},
+		{"phrase", map[string]interface{}{"type": fdc.PHRASE, "field": "ingredients"}, fdc.PHRASE, "ingredients", false},
+		{"regex", map[string]interface{}{"type": fdc.REGEX, "field": "company"}, fdc.REGEX, "company_kw", false},
+		{"fuzzy", map[string]interface{}{"type": datastore.FUZZY}, datastore.FUZZY, "", false},
+		{"category", map[string]interface{}{"field": "category"}, "", "foodGroup.description", false},
+		{"unknown type", map[string]interface{}{"type": "SOUNDEX"}, "", "", true},
+		{"unknown field", map[string]interface{}{"field": "servings"}, "", "", true},
+		{"injected field", map[string]interface{}{"field": "description) OR (1=1"}, "", "", true},
+		{"injected type", map[string]interface{}{"type": "PHRASE' OR '1'='1"}, "", "", true},
+		{"bad fuzziness", map[string]interface{}{"type": datastore.FUZZY, "fuzziness": 3}, datastore.FUZZY, "", true},
+		{"bad operator", map[string]interface{}{"operator": "XOR"}, "", "", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sr, err := Searchquery(search(tc.args))
+			if sr.SearchType != tc.searchType || sr.SearchField != tc.field {
+				t.Errorf("type %q field %q, want %q %q", sr.SearchType, sr.SearchField, tc.searchType, tc.field)
+			}
+			if (err != nil) != tc.err {
+				t.Errorf("error %v, want error %v", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestSearchsort(t *testing.T) {
+	for _, tc := range []struct {
+		order string
+		desc  bool
+		err   bool
+	}{
+		{"", false, false},
+		{"asc", false, false},
+		{"DESC", true, false},
+		{"DESC; DROP TABLE foods", false, true},
+	} {
+		var errs error
+		_, desc := Searchsort(search(map[string]interface{}{"order": tc.order}), &errs)
+		if desc != tc.desc || (errs != nil) != tc.err {
+			t.Errorf("order %q: desc %v error %v, want %v %v", tc.order, desc, errs, tc.desc, tc.err)
+		}
+	}
+}
+
+func TestNutrientfilters(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		arg  interface{}
+		want string
+		err  bool
+	}{
+		{"every op", []interface{}{map[string]interface{}{"nutrientno": 208, "eq": 5.0, "lte": 4.0, "lt": 3.0, "gte": 2.0, "gt": 1.0}}, "208>1,208>=2,208<3,208<=4,208=5", false},
+		{"in order given", []interface{}{
+			map[string]interface{}{"nutrientno": 307, "lt": 140.0},
+			map[string]interface{}{"nutrientno": 203, "gte": 10.0},
+		}, "307<140,203>=10", false},
+		{"no nutrientno", []interface{}{map[string]interface{}{"gt": 1.0}}, "", true},
+		{"no comparison", []interface{}{map[string]interface{}{"nutrientno": 208}}, "", true},
+		{"none", nil, "", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				errs error
+				got  []string
+			)
+			// maps are read in a different order each time
+			for i := 0; i < 20; i++ {
+				got = got[:0]
+				for _, nf := range Nutrientfilters(tc.arg, &errs) {
+					got = append(got, fmt.Sprintf("%d%s%g", nf.Nutrientno, nf.Op, nf.Value))
+				}
+				if strings.Join(got, ",") != tc.want {
+					t.Fatalf("filters %s, want %s", strings.Join(got, ","), tc.want)
+				}
+			}
+			if (errs != nil) != tc.err {
+				t.Errorf("error %v, want error %v", errs, tc.err)
+			}
+		})
+	}
+}